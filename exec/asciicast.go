@@ -0,0 +1,95 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// See: https://docs.asciinema.org/manual/asciicast/v2/
+
+const ASCIICAST_VERSION = 2
+
+// Used for recordings of commands that do not have a pseudo-terminal
+var DefaultAsciicastSize = Size{Width: 80, Height: 24}
+
+//
+// AsciicastHeader
+//
+
+type AsciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint              `json:"width"`
+	Height    uint              `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"` // Unix time
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+//
+// AsciicastEventCode
+//
+
+type AsciicastEventCode string
+
+const (
+	OutputAsciicastEvent AsciicastEventCode = "o"
+	InputAsciicastEvent  AsciicastEventCode = "i"
+	ResizeAsciicastEvent AsciicastEventCode = "r"
+	MarkerAsciicastEvent AsciicastEventCode = "m"
+
+	// Not part of the asciicast v2 specification (which does not distinguish
+	// stderr from stdout), so other players will ignore it. Used only if
+	// [Recorder.SeparateStderr] is true.
+	ErrorAsciicastEvent AsciicastEventCode = "e"
+)
+
+//
+// AsciicastEvent
+//
+
+type AsciicastEvent struct {
+	Time float64 // seconds since the start of the recording
+	Code AsciicastEventCode
+	Data string
+}
+
+// ([json.Marshaler] interface)
+func (self AsciicastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{self.Time, self.Code, self.Data})
+}
+
+// ([json.Unmarshaler] interface)
+func (self *AsciicastEvent) UnmarshalJSON(data []byte) error {
+	var array []json.RawMessage
+	if err := json.Unmarshal(data, &array); err != nil {
+		return err
+	}
+
+	if len(array) != 3 {
+		return fmt.Errorf("malformed asciicast event: %s", data)
+	}
+
+	if err := json.Unmarshal(array[0], &self.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(array[1], &self.Code); err != nil {
+		return err
+	}
+	return json.Unmarshal(array[2], &self.Data)
+}
+
+// Formats the size as "{width}x{height}".
+func FormatAsciicastResize(size Size) string {
+	return fmt.Sprintf("%dx%d", size.Width, size.Height)
+}
+
+// Parses "{width}x{height}".
+func ParseAsciicastResize(data string) (Size, error) {
+	var size Size
+	if _, err := fmt.Sscanf(data, "%dx%d", &size.Width, &size.Height); err == nil {
+		return size, nil
+	} else {
+		return Size{}, fmt.Errorf("malformed asciicast resize: %q", data)
+	}
+}
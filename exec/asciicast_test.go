@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestAsciicast(t *testing.T) {
+	var buffer bytes.Buffer
+
+	recorder := NewRecorder(&buffer)
+	if err := recorder.Start(Size{Width: 100, Height: 30}); err != nil {
+		t.Errorf("recorder.Start: %s", err.Error())
+		return
+	}
+
+	stdout := recorder.Writer(OutputAsciicastEvent)
+	stderr := recorder.Writer(ErrorAsciicastEvent)
+
+	// "ü" is split between two writes
+	stdout.Write([]byte("hello w\xc3"))
+	stdout.Write([]byte("\xbcrld\r\n"))
+	stderr.Write([]byte("oops\r\n"))
+	recorder.RecordResize(Size{Width: 120, Height: 40})
+	recorder.Record(InputAsciicastEvent, []byte("exit\r"))
+	if err := recorder.Flush(); err != nil {
+		t.Errorf("recorder.Flush: %s", err.Error())
+		return
+	}
+
+	player, err := NewPlayer(&buffer)
+	if err != nil {
+		t.Errorf("NewPlayer: %s", err.Error())
+		return
+	}
+
+	if (player.Header.Width != 100) || (player.Header.Height != 30) {
+		t.Errorf("header size: %dx%d", player.Header.Width, player.Header.Height)
+	}
+
+	player.Speed = 0
+	process, err := player.Start(context.TODO())
+	if err != nil {
+		t.Errorf("player.Start: %s", err.Error())
+		return
+	}
+	defer process.Close()
+
+	var stderr_ bytes.Buffer
+	stderrDone := make(chan struct{})
+	go func() {
+		for p := range process.Stderr {
+			stderr_.Write(p)
+		}
+		close(stderrDone)
+	}()
+
+	var stdout_ bytes.Buffer
+	for p := range process.Stdout {
+		stdout_.Write(p)
+	}
+	<-stderrDone
+
+	if stdout_.String() != "hello würld\r\n" {
+		t.Errorf("stdout: %q", stdout_.String())
+	}
+	if stderr_.String() != "oops\r\n" {
+		t.Errorf("stderr: %q", stderr_.String())
+	}
+
+	if size, ok := <-player.Resize; !ok || (size != Size{Width: 120, Height: 40}) {
+		t.Errorf("resize: %v", size)
+	}
+
+	if exitStatus, err := process.Wait(); err == nil {
+		if exitStatus.Termination != nil {
+			t.Errorf("termination: %+v", exitStatus.Termination)
+		}
+	} else {
+		t.Errorf("process.Wait: %s", err.Error())
+	}
+}
+
+func TestRecorderSharedCode(t *testing.T) {
+	var buffer bytes.Buffer
+
+	recorder := NewRecorder(&buffer)
+	if err := recorder.Start(DefaultAsciicastSize); err != nil {
+		t.Fatalf("recorder.Start: %s", err.Error())
+	}
+
+	// Like stdout and stderr without SeparateStderr
+	stdout := recorder.Writer(OutputAsciicastEvent)
+	stderr := recorder.Writer(OutputAsciicastEvent)
+
+	// The incomplete "ü" must not be joined with the other writer's bytes
+	stdout.Write([]byte("w\xc3"))
+	stderr.Write([]byte("oops"))
+	stdout.Write([]byte("\xbcrld"))
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("recorder.Flush: %s", err.Error())
+	}
+
+	player, err := NewPlayer(&buffer)
+	if err != nil {
+		t.Fatalf("NewPlayer: %s", err.Error())
+	}
+	player.Speed = 0
+	process, err := player.Start(context.TODO())
+	if err != nil {
+		t.Fatalf("player.Start: %s", err.Error())
+	}
+	defer process.Close()
+
+	var stdout_ bytes.Buffer
+	for p := range process.Stdout {
+		stdout_.Write(p)
+	}
+	if stdout_.String() != "woopsürld" {
+		t.Errorf("stdout: %q", stdout_.String())
+	}
+}
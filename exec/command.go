@@ -3,10 +3,15 @@ package exec
 import (
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/tliron/go-kutil/util"
 )
 
 const DEFAULT_CHANNEL_SIZE = 10
 
+const DEFAULT_SPILL_SIZE = util.DEFAULT_SPILL_SIZE
+
 //
 // Command
 //
@@ -17,7 +22,15 @@ type Command struct {
 	Dir            string
 	Environment    map[string]string
 	PseudoTerminal *Size
+	StderrMode     StderrMode // only used when PseudoTerminal is not nil
 	ChannelSize    int
+	Overflow       util.ChannelOverflow // what to do when Process.Stdout or Process.Stderr are full; empty for util.FailChannelOverflow
+	SpillSize      int                  // only used when Overflow is util.SpillChannelOverflow; zero for DEFAULT_SPILL_SIZE
+	CopyBytes      bool                 // when false the channels may alias buffers that are reused
+	ProcessGroup   ProcessGroupMode     // ignored when PseudoTerminal is not nil (always OwnSession)
+	Termination    []TerminationStage   // when nil will use DefaultTerminationSequence
+	Timeout        time.Duration        // when non-zero will terminate the process after this duration
+	Recorder       *Recorder            // when not nil will record the session
 
 	done chan error
 }
@@ -25,7 +38,9 @@ type Command struct {
 func NewCommand() *Command {
 	return &Command{
 		ChannelSize: DEFAULT_CHANNEL_SIZE,
-		done:        make(chan error),
+		SpillSize:   DEFAULT_SPILL_SIZE,
+		CopyBytes:   true,
+		done:        make(chan error, 1),
 	}
 }
 
@@ -34,8 +49,13 @@ func (self *Command) Wait() error {
 	return err
 }
 
+// Releases [Command.Wait] with the error. Does not block: if Command.Wait
+// was not yet called then only the first error is kept.
 func (self *Command) Stop(err error) {
-	self.done <- err
+	select {
+	case self.done <- err:
+	default:
+	}
 }
 
 func (self *Command) AddPath(key string, path string) {
@@ -58,3 +78,44 @@ func (self *Command) AddPath(key string, path string) {
 		}
 	}
 }
+
+//
+// StderrMode
+//
+
+type StderrMode string
+
+const (
+	// Stderr is sent to [Process.Stderr] via a pipe. This is the default.
+	// Note that because stderr is then not a TTY file some shell programs
+	// may disable their interactive mode. In such cases it may be possible
+	// to force interactive mode, for example: `bash -i`.
+	PipeStderr StderrMode = "pipe"
+
+	// Stderr gets its own pseudo-terminal and is sent to [Process.Stderr].
+	PseudoTerminalStderr StderrMode = "pseudo-terminal"
+
+	// Stderr shares the pseudo-terminal of stdout and is thus merged into
+	// [Process.Stdout]. Nothing will be sent to [Process.Stderr].
+	MergedStderr StderrMode = "merged"
+)
+
+//
+// ProcessGroupMode
+//
+
+type ProcessGroupMode string
+
+const (
+	// The process stays in our process group. This is the default. Termination
+	// signals will be sent to the process alone, so its children may be orphaned.
+	ParentProcessGroup ProcessGroupMode = "parent"
+
+	// The process becomes the leader of a new process group. Termination signals
+	// will be sent to the whole group.
+	OwnProcessGroup ProcessGroupMode = "group"
+
+	// The process becomes the leader of a new session (and thus also of a new
+	// process group). Termination signals will be sent to the whole group.
+	OwnSession ProcessGroupMode = "session"
+)
@@ -4,19 +4,33 @@ package exec
 
 import (
 	contextpkg "context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/creack/pty"
-	"github.com/tliron/go-kutil/util"
 )
 
-func (self *Command) Start(context contextpkg.Context) (*Process, error) {
-	copy := false // TODO: whether to copy byte slices
+// How often to check whether a process group is gone after its leader exited.
+var processGroupPollInterval = 50 * time.Millisecond
 
-	process := newProcess(self.ChannelSize)
+// How long to keep reading from pseudo-terminals (and the stderr pipe used
+// alongside them) after the process exited.
+// Descendants may keep them open indefinitely, in which case the rest of
+// their output is discarded.
+var pseudoTerminalDrainTimeout = 200 * time.Millisecond
+
+func (self *Command) Start(context contextpkg.Context) (*Process, error) {
+	driver, err := NewProcessDriver(context, self)
+	if err != nil {
+		return nil, err
+	}
+	process := driver.Process
 
 	command := exec.Command(self.Name, self.Args...)
 	command.Dir = self.Dir
@@ -38,52 +52,138 @@ func (self *Command) Start(context contextpkg.Context) (*Process, error) {
 		}
 	}
 
-	start := func(stdinWriter io.WriteCloser, stdoutReader io.Reader, tty *os.File) {
-		// Write stdout
-		if stdoutReader != nil {
-			go func() {
-				io.Copy(util.NewChannelWriter(process.Stdout, copy), stdoutReader)
-				// When done will return an input/output error
-				// (an *fs.PathError wrapping a syscall.Errno)
-				log.Debug("stdout closed")
-			}()
+	var readers sync.WaitGroup
+	var startTime time.Time
+
+	read := func(name string, writer io.Writer, reader io.Reader) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			io.Copy(writer, reader)
+			// When done will return an input/output error
+			// (an *fs.PathError wrapping a syscall.Errno)
+			log.Debugf("%s closed", name)
+		}()
+	}
+
+	terminationSequence := self.Termination
+	if terminationSequence == nil {
+		terminationSequence = DefaultTerminationSequence
+	}
+
+	// With a pseudo-terminal the process is always the leader of its own session
+	group := (self.PseudoTerminal != nil) || ((self.ProcessGroup != "") && (self.ProcessGroup != ParentProcessGroup))
+
+	waited := make(chan struct{})
+	var termination *Termination
+	var terminationLock sync.Mutex
+
+	// Whether there's still something to terminate: the process or, if it
+	// already exited, other processes in its group
+	alive := func() bool {
+		select {
+		case <-waited:
+			return group && processGroupExists(command.Process)
+		default:
+			return true
+		}
+	}
+
+	// Returns false if there's nothing left to terminate before the grace period ends
+	waitGrace := func(grace time.Duration) bool {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return true
+		case <-waited:
 		}
 
+		if !group {
+			return false
+		}
+
+		// Members of the group that ignore the signal would still be running
+		ticker := time.NewTicker(processGroupPollInterval)
+		defer ticker.Stop()
+		for {
+			if !processGroupExists(command.Process) {
+				return false
+			}
+
+			select {
+			case <-timer.C:
+				return true
+			case <-ticker.C:
+			}
+		}
+	}
+
+	terminate := func(reason TerminationReason) {
+		defer driver.Terminated()
+
+		for stage, terminationStage := range terminationSequence {
+			terminationLock.Lock()
+			if !alive() {
+				terminationLock.Unlock()
+				return
+			}
+
+			log.Infof("terminating process (%s, stage %d): %s", reason, stage, terminationStage.Signal)
+			termination = &Termination{Reason: reason, Stage: stage, Signal: terminationStage.Signal}
+			if err := signalProcess(command.Process, terminationStage.Signal, group); err != nil {
+				log.Errorf("terminate: %s", err.Error())
+			}
+			terminationLock.Unlock()
+
+			if (terminationStage.Grace > 0) && !waitGrace(terminationStage.Grace) {
+				return
+			}
+		}
+	}
+
+	// Note: ptys are also closed when the command ends
+	start := func(stdinWriter io.WriteCloser, ptys ...*os.File) {
 		// Read stdin, resize, context
 		go func() {
+			// We keep watching the context after stdin is closed
+			stdin := process.stdin
+			resize := process.resize
+
 			for {
 				select {
-				case b, ok := <-process.stdin:
+				case b, ok := <-stdin:
 					if !ok {
 						log.Debug("stdin closed")
-						return
-					}
-					if _, err := stdinWriter.Write(b); err != nil {
+						stdin = nil
+					} else if _, err := stdinWriter.Write(b); err != nil {
 						log.Errorf("stdin: %s", err.Error())
-						return
+						stdin = nil
 					}
 
-				case s, ok := <-process.resize:
+				case s, ok := <-resize:
 					if !ok {
 						log.Debug("resize closed")
-						return
+						resize = nil
+						continue
 					}
-					if tty != nil {
-						winsize := pty.Winsize{Rows: uint16(s.Height), Cols: uint16(s.Width)}
-						if err := pty.Setsize(tty, &winsize); err != nil {
+					winsize := pty.Winsize{Rows: uint16(s.Height), Cols: uint16(s.Width)}
+					for _, pty_ := range ptys {
+						if err := setPseudoTerminalSize(pty_, &winsize); err != nil {
 							log.Errorf("resize: %s", err.Error())
 						}
 					}
 
-				case <-context.Done():
-					if err := context.Err(); err != nil {
-						log.Errorf("done: %s", err.Error())
-					}
-					log.Info("killing process")
-					if err := command.Process.Kill(); err != nil {
-						log.Errorf("kill: %s", err.Error())
+				case <-driver.Context.Done():
+					// Cancelled or timed out
+					if termination := driver.Termination(); termination != nil {
+						go terminate(termination.Reason)
 					}
 					return
+
+				case <-waited:
+					return
 				}
 			}
 		}()
@@ -91,52 +191,165 @@ func (self *Command) Start(context contextpkg.Context) (*Process, error) {
 		// Wait for command to end
 		go func() {
 			var exitError error
+			var waitError error
 
 			if err := command.Wait(); err == nil {
 				log.Info("command exited")
+			} else if errors.Is(err, exec.ErrWaitDelay) {
+				// Descendants are keeping the stderr pipe open
+				log.Info("command exited")
+				log.Debug("stderr is still open")
 			} else if _, ok := err.(*exec.ExitError); ok {
 				exitError = err
 			} else {
 				log.Errorf("command wait: %s", err.Error())
+				waitError = err
+			}
+
+			terminationLock.Lock()
+			close(waited)
+			termination_ := termination
+			terminationLock.Unlock()
+
+			duration := time.Since(startTime)
+
+			// Make sure we've read everything before closing the channels
+			if len(ptys) > 0 {
+				drained := make(chan struct{})
+				go func() {
+					readers.Wait()
+					close(drained)
+				}()
+
+				select {
+				case <-drained:
+				case <-time.After(pseudoTerminalDrainTimeout):
+					// Closing the ptys will end the readers
+					log.Debug("pseudo-terminal is still open")
+				}
 			}
 
 			if err := stdinWriter.Close(); err != nil {
 				log.Errorf("stdin: %s", err.Error())
 			}
 
-			close(process.Stdout)
-			close(process.Stderr)
+			for _, pty_ := range ptys {
+				if pty_ != stdinWriter {
+					if err := pty_.Close(); err != nil {
+						log.Errorf("pty: %s", err.Error())
+					}
+				}
+			}
+
+			readers.Wait()
 
-			self.Stop(exitError)
+			var exitStatus *ExitStatus
+			if command.ProcessState != nil {
+				exitStatus = newExitStatus(command.ProcessState, duration)
+				exitStatus.Termination = termination_
+				log.Debugf("command %s", exitStatus.String())
+			}
+
+			driver.exit(exitStatus, waitError, exitError)
 		}()
 	}
 
 	log.Debugf("%s", command.String())
 
+	if self.PseudoTerminal == nil {
+		setProcessGroup(command, self.ProcessGroup)
+	}
+
 	if self.PseudoTerminal != nil {
-		// Note: Our stderr is not a TTY file, which may cause some shell programs to disable their interactive mode.
-		// In such cases it may be possible to force interactive mode, for example: `bash -i`
 		log.Debugf("creating pseudo-terminal with size %d, %d", self.PseudoTerminal.Width, self.PseudoTerminal.Height)
-		command.Stderr = util.NewChannelWriter(process.Stderr, copy)
 		winsize := pty.Winsize{Rows: uint16(self.PseudoTerminal.Height), Cols: uint16(self.PseudoTerminal.Width)}
+
+		var stderrPty *os.File
+		switch self.StderrMode {
+		case PseudoTerminalStderr:
+			log.Debug("creating pseudo-terminal for stderr")
+			var stderrTty *os.File
+			if stderrPty, stderrTty, err = pty.Open(); err == nil {
+				// The child process will have its own copy
+				defer stderrTty.Close()
+				if err := pty.Setsize(stderrPty, &winsize); err != nil {
+					stderrPty.Close()
+					driver.Abort()
+					return nil, err
+				}
+				command.Stderr = stderrTty
+			} else {
+				driver.Abort()
+				return nil, err
+			}
+
+		case MergedStderr:
+			// pty.StartWithSize will assign the pseudo-terminal to stderr
+
+		default:
+			command.Stderr = driver.Stderr
+			command.WaitDelay = pseudoTerminalDrainTimeout
+		}
+
+		startTime = time.Now()
 		if ptyFile, err := pty.StartWithSize(command, &winsize); err == nil {
-			start(ptyFile, ptyFile, ptyFile)
-			return &process, nil
+			// So that we can stop reading when descendants keep them open
+			if ptyFile, err = newPollablePseudoTerminal(ptyFile); err != nil {
+				log.Errorf("pty: %s", err.Error())
+			}
+			if stderrPty != nil {
+				if stderrPty, err = newPollablePseudoTerminal(stderrPty); err != nil {
+					log.Errorf("pty: %s", err.Error())
+				}
+			}
+
+			read("stdout", driver.Stdout, ptyFile)
+			if stderrPty != nil {
+				read("stderr", driver.Stderr, stderrPty)
+				start(ptyFile, ptyFile, stderrPty)
+			} else {
+				start(ptyFile, ptyFile)
+			}
+			return process, nil
 		} else {
+			if stderrPty != nil {
+				stderrPty.Close()
+			}
+			driver.Abort()
 			return nil, err
 		}
 	} else {
 		if stdinWriter, err := command.StdinPipe(); err == nil {
-			command.Stdout = util.NewChannelWriter(process.Stdout, copy)
-			command.Stderr = util.NewChannelWriter(process.Stderr, copy)
+			command.Stdout = driver.Stdout
+			command.Stderr = driver.Stderr
+			startTime = time.Now()
 			if err := command.Start(); err == nil {
-				start(stdinWriter, nil, nil)
-				return &process, nil
+				start(stdinWriter)
+				return process, nil
 			} else {
+				driver.Abort()
 				return nil, err
 			}
 		} else {
+			driver.Abort()
 			return nil, err
 		}
 	}
 }
+
+func newExitStatus(state *os.ProcessState, duration time.Duration) *ExitStatus {
+	self := ExitStatus{
+		Code:       state.ExitCode(),
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		Usage:      state.SysUsage(),
+		Duration:   duration,
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		self.Signal = status.Signal()
+		self.CoreDumped = status.CoreDump()
+	}
+
+	return &self
+}
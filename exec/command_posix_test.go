@@ -0,0 +1,333 @@
+//go:build !windows && !wasm
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tliron/go-kutil/util"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	command := NewCommand()
+	command.Name = "sh"
+	command.Args = []string{"-c", `trap "" TERM; sleep 100`}
+	command.ProcessGroup = OwnProcessGroup
+	command.Termination = GracefulTerminationSequence(200 * time.Millisecond)
+	command.Timeout = 100 * time.Millisecond
+
+	process, err := command.Start(context.TODO())
+	if err != nil {
+		t.Fatalf("command.Start: %s", err.Error())
+	}
+	// Closing stdin must not disable the timeout
+	process.Close()
+
+	_, _, exitStatus := waitTestProcess(t, process)
+	if exitStatus.Signal != syscall.SIGKILL {
+		t.Errorf("exit status: %s", exitStatus)
+	}
+	expectTestTermination(t, exitStatus, TimeoutTermination, 1, syscall.SIGKILL)
+}
+
+func TestCommandTimeoutNotReading(t *testing.T) {
+	command := NewCommand()
+	command.Name = "yes"
+	command.ChannelSize = 1
+	command.Overflow = util.BlockChannelOverflow
+	command.Timeout = 100 * time.Millisecond
+
+	process, err := command.Start(context.TODO())
+	if err != nil {
+		t.Fatalf("command.Start: %s", err.Error())
+	}
+	process.Close()
+
+	// We never read stdout, but the timeout must still end the process
+	waited := make(chan *ExitStatus, 1)
+	go func() {
+		exitStatus, _ := process.Wait()
+		waited <- exitStatus
+	}()
+
+	select {
+	case exitStatus := <-waited:
+		expectTestTermination(t, exitStatus, TimeoutTermination, 0, syscall.SIGKILL)
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit")
+	}
+}
+
+func TestCommandCancel(t *testing.T) {
+	context_, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	command := NewCommand()
+	command.Name = "sleep"
+	command.Args = []string{"100"}
+
+	process, err := command.Start(context_)
+	if err != nil {
+		t.Fatalf("command.Start: %s", err.Error())
+	}
+	process.Close()
+
+	// Give the stdin goroutine a chance to see the close
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	_, _, exitStatus := waitTestProcess(t, process)
+	if exitStatus.Signal != syscall.SIGKILL {
+		t.Errorf("exit status: %s", exitStatus)
+	}
+	expectTestTermination(t, exitStatus, CancelTermination, 0, syscall.SIGKILL)
+}
+
+func TestCommandProcessGroupMembers(t *testing.T) {
+	context_, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	// The leader will exit on SIGTERM, but the other member of its group
+	// ignores it (and does not hold on to stdout, so the leader's exit will be
+	// reported)
+	command := NewCommand()
+	command.Name = "sh"
+	command.Args = []string{"-c", `(trap "" TERM; exec sleep 100 </dev/null >/dev/null 2>&1) & echo $$; wait`}
+	command.ProcessGroup = OwnProcessGroup
+	command.Termination = GracefulTerminationSequence(200 * time.Millisecond)
+
+	process, err := command.Start(context_)
+	if err != nil {
+		t.Fatalf("command.Start: %s", err.Error())
+	}
+	defer process.Close()
+
+	var pgid int
+	select {
+	case p := <-process.Stdout:
+		if pgid, err = strconv.Atoi(strings.TrimSpace(string(p))); err != nil {
+			t.Fatalf("stdout: %q", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no process group ID")
+	}
+
+	// Make sure the trap is set
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	_, _, exitStatus := waitTestProcess(t, process)
+	if exitStatus.Signal != syscall.SIGTERM {
+		t.Errorf("exit status: %s", exitStatus)
+	}
+	expectTestTermination(t, exitStatus, CancelTermination, 0, syscall.SIGTERM)
+
+	// The rest of the group will get SIGKILL after the grace period
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(-pgid, 0) != syscall.ESRCH {
+		if time.Now().After(deadline) {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			t.Fatal("process group was not killed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCommandStop(t *testing.T) {
+	command := NewCommand()
+	command.Name = "sh"
+	command.Args = []string{"-c", "exit 3"}
+
+	process, err := command.Start(context.TODO())
+	if err != nil {
+		t.Fatalf("command.Start: %s", err.Error())
+	}
+	process.Close()
+	waitTestProcess(t, process)
+
+	// Nobody called Command.Wait, but Stop must not block
+	stopped := make(chan struct{})
+	go func() {
+		command.Stop(nil)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked")
+	}
+
+	// The exit error was kept
+	var exitError interface{ ExitCode() int }
+	if err := command.Wait(); !errors.As(err, &exitError) || (exitError.ExitCode() != 3) {
+		t.Errorf("command.Wait: %v", err)
+	}
+}
+
+func TestCommandExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		code   int
+		signal syscall.Signal
+	}{
+		{"success", "exit 0", 0, 0},
+		{"code", "exit 3", 3, 0},
+		{"signal", "kill -KILL $$", -1, syscall.SIGKILL},
+	}
+
+	for _, test := range tests {
+		command := NewCommand()
+		command.Name = "sh"
+		command.Args = []string{"-c", test.script}
+
+		process, err := command.Start(context.TODO())
+		if err != nil {
+			t.Fatalf("%s: command.Start: %s", test.name, err.Error())
+		}
+		process.Close()
+
+		_, _, exitStatus := waitTestProcess(t, process)
+		if exitStatus.Code != test.code {
+			t.Errorf("%s: code %d", test.name, exitStatus.Code)
+		}
+		if test.signal != 0 {
+			if exitStatus.Signal != test.signal {
+				t.Errorf("%s: signal %v", test.name, exitStatus.Signal)
+			}
+		} else if exitStatus.Signal != nil {
+			t.Errorf("%s: signal %v", test.name, exitStatus.Signal)
+		}
+		if exitStatus.Success() != (test.code == 0) {
+			t.Errorf("%s: %s", test.name, exitStatus)
+		}
+		if exitStatus.Termination != nil {
+			t.Errorf("%s: termination: %+v", test.name, exitStatus.Termination)
+		}
+	}
+}
+
+func TestCommandStderrMode(t *testing.T) {
+	tests := []struct {
+		mode   StderrMode
+		stdout []string
+		stderr []string
+	}{
+		{PipeStderr, []string{"out", "stdout-tty"}, []string{"err"}},
+		{PseudoTerminalStderr, []string{"out", "stdout-tty"}, []string{"err", "stderr-tty"}},
+		{MergedStderr, []string{"out", "stdout-tty", "err", "stderr-tty"}, nil},
+	}
+
+	for _, test := range tests {
+		command := NewCommand()
+		command.Name = "sh"
+		command.Args = []string{"-c", `echo out; [ -t 1 ] && echo stdout-tty; echo err >&2; [ -t 2 ] && echo stderr-tty >&2; exit 0`}
+		command.PseudoTerminal = &Size{Width: 80, Height: 24}
+		command.StderrMode = test.mode
+
+		process, err := command.Start(context.TODO())
+		if err != nil {
+			t.Fatalf("%s: command.Start: %s", test.mode, err.Error())
+		}
+
+		stdout, stderr, exitStatus := waitTestProcess(t, process)
+		if !exitStatus.Success() {
+			t.Errorf("%s: %s", test.mode, exitStatus)
+		}
+		expectTestLines(t, string(test.mode)+" stdout", stdout, test.stdout)
+		expectTestLines(t, string(test.mode)+" stderr", stderr, test.stderr)
+	}
+}
+
+func TestCommandPseudoTerminalBackgroundChild(t *testing.T) {
+	for _, mode := range []StderrMode{PipeStderr, PseudoTerminalStderr, MergedStderr} {
+		command := NewCommand()
+		command.Name = "sh"
+		// The child ignores the SIGHUP sent when the session leader exits
+		command.Args = []string{"-c", `trap "" HUP; sleep 100 & echo $!`}
+		command.PseudoTerminal = &Size{Width: 80, Height: 24}
+		command.StderrMode = mode
+
+		process, err := command.Start(context.TODO())
+		if err != nil {
+			t.Fatalf("%s: command.Start: %s", mode, err.Error())
+		}
+
+		// The child keeps the pseudo-terminal open, but that must not block us
+		stdout, _, exitStatus := waitTestProcess(t, process)
+		if pid, err := strconv.Atoi(strings.TrimSpace(stdout)); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		} else {
+			t.Errorf("%s: stdout: %q", mode, stdout)
+		}
+		if !exitStatus.Success() {
+			t.Errorf("%s: exit status: %s", mode, exitStatus)
+		}
+	}
+}
+
+func waitTestProcess(t *testing.T, process *Process) (string, string, *ExitStatus) {
+	t.Helper()
+
+	var stdout, stderr strings.Builder
+	done := make(chan struct{}, 2)
+	go func() {
+		for p := range process.Stdout {
+			stdout.Write(p)
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		for p := range process.Stderr {
+			stderr.Write(p)
+		}
+		done <- struct{}{}
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for range 2 {
+		select {
+		case <-done:
+		case <-timeout:
+			t.Fatal("process did not exit")
+		}
+	}
+
+	exitStatus, err := process.Wait()
+	if err != nil {
+		t.Fatalf("process.Wait: %s", err.Error())
+	}
+	if exitStatus == nil {
+		t.Fatal("no exit status")
+	}
+
+	return stdout.String(), stderr.String(), exitStatus
+}
+
+func expectTestTermination(t *testing.T, exitStatus *ExitStatus, reason TerminationReason, stage int, signal syscall.Signal) {
+	t.Helper()
+
+	if termination := exitStatus.Termination; termination != nil {
+		if (termination.Reason != reason) || (termination.Stage != stage) || (termination.Signal != signal) {
+			t.Errorf("termination: %+v", termination)
+		}
+	} else {
+		t.Error("no termination")
+	}
+}
+
+// Compares the lines regardless of line endings (pseudo-terminals use "\r\n").
+func expectTestLines(t *testing.T, name string, output string, lines []string) {
+	t.Helper()
+
+	output = strings.TrimSpace(strings.ReplaceAll(output, "\r\n", "\n"))
+	if output_ := strings.Join(lines, "\n"); output != output_ {
+		t.Errorf("%s: %q, expected %q", name, output, output_)
+	}
+}
@@ -0,0 +1,71 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tliron/go-kutil/util"
+)
+
+func TestCommandOverflow(t *testing.T) {
+	command := NewCommand()
+	command.Name = "true"
+
+	command.Overflow = "bogus"
+	if _, err := command.Start(context.TODO()); err == nil {
+		t.Error("invalid Overflow: no error")
+	}
+
+	command.Overflow = ""
+	command.SpillSize = -1
+	if _, err := command.Start(context.TODO()); err == nil {
+		t.Error("negative SpillSize: no error")
+	}
+}
+
+func TestProcessDriverDefaultOverflow(t *testing.T) {
+	command := NewCommand()
+	command.Name = "test"
+	command.ChannelSize = 1
+
+	driver, err := NewProcessDriver(context.TODO(), command)
+	if err != nil {
+		t.Fatalf("NewProcessDriver: %s", err.Error())
+	}
+	defer driver.Abort()
+
+	// Blocking is opt-in
+	driver.Stdout.Write([]byte("first"))
+	if _, err := driver.Stdout.Write([]byte("second")); !errors.Is(err, util.ErrChannelFull) {
+		t.Errorf("Write: %v", err)
+	}
+}
+
+func TestProcessDriverRecorderOverflow(t *testing.T) {
+	var buffer bytes.Buffer
+
+	command := NewCommand()
+	command.Name = "test"
+	command.ChannelSize = 1
+	command.Overflow = util.FailChannelOverflow
+	command.Recorder = NewRecorder(&buffer)
+
+	driver, err := NewProcessDriver(context.TODO(), command)
+	if err != nil {
+		t.Fatalf("NewProcessDriver: %s", err.Error())
+	}
+	defer driver.Abort()
+
+	driver.Stdout.Write([]byte("first"))
+	if _, err := driver.Stdout.Write([]byte("second")); !errors.Is(err, util.ErrChannelFull) {
+		t.Errorf("Write: %v", err)
+	}
+
+	// The overflow must not affect the recording
+	if !strings.Contains(buffer.String(), "second") {
+		t.Errorf("recording: %s", buffer.String())
+	}
+}
@@ -0,0 +1,55 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+//
+// ExitStatus
+//
+
+type ExitStatus struct {
+	Code       int           // -1 if the process was terminated by a signal
+	Signal     os.Signal     // nil if the process was not terminated by a signal
+	CoreDumped bool          // only meaningful when Signal is not nil
+	UserTime   time.Duration // CPU time spent in user mode
+	SystemTime time.Duration // CPU time spent in kernel mode
+	Usage      any           // platform-specific, e.g. *syscall.Rusage on POSIX
+	Duration   time.Duration // wall time from start to exit
+
+	// Not nil if we terminated the process
+	Termination *Termination
+}
+
+// Returns true if the process exited normally with a code of 0.
+func (self *ExitStatus) Success() bool {
+	return (self.Code == 0) && (self.Signal == nil)
+}
+
+// ([fmt.Stringer] interface)
+func (self *ExitStatus) String() string {
+	if self.Signal != nil {
+		if self.CoreDumped {
+			return fmt.Sprintf("signal: %s (core dumped)", self.Signal)
+		} else {
+			return fmt.Sprintf("signal: %s", self.Signal)
+		}
+	} else {
+		return fmt.Sprintf("exit status %d", self.Code)
+	}
+}
+
+//
+// ExitError
+//
+
+type ExitError struct {
+	ExitStatus *ExitStatus
+}
+
+// ([error] interface)
+func (self *ExitError) Error() string {
+	return self.ExitStatus.String()
+}
@@ -0,0 +1,153 @@
+package exec
+
+import (
+	contextpkg "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+//
+// Player
+//
+
+type Player struct {
+	Header      AsciicastHeader
+	Speed       float64       // 1.0 is real time; zero or negative means no delays at all
+	MaxIdle     time.Duration // when non-zero, longer pauses between events will be shortened to this
+	ChannelSize int
+
+	// Receive from this. The recorded resize events are sent to it (or
+	// dropped if it is full) and it is closed when the playback ends.
+	Resize chan Size
+
+	decoder *json.Decoder
+}
+
+// Creates a player for an asciicast v2 recording (JSON lines), such as one
+// written by [Recorder]. The header is read immediately.
+func NewPlayer(reader io.Reader) (*Player, error) {
+	self := Player{
+		Speed:       1.0,
+		ChannelSize: DEFAULT_CHANNEL_SIZE,
+		Resize:      make(chan Size, DEFAULT_CHANNEL_SIZE),
+		decoder:     json.NewDecoder(reader),
+	}
+
+	if err := self.decoder.Decode(&self.Header); err == nil {
+		if self.Header.Version == ASCIICAST_VERSION {
+			return &self, nil
+		} else {
+			return nil, fmt.Errorf("unsupported asciicast version: %d", self.Header.Version)
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Plays the recording by sending its output events to [Process.Stdout], its
+// error events to [Process.Stderr], and its resize events to [Player.Resize]
+// with the recorded timing. Other events are ignored, as are calls to
+// [Process.Stdin] and [Process.Resize].
+//
+// Cancelling the context stops the playback, which will be reported as a
+// [Termination] in the [ExitStatus]. A malformed recording will be reported
+// as an error by [Process.Wait].
+func (self *Player) Start(context contextpkg.Context) (*Process, error) {
+	process := newProcess(self.ChannelSize)
+
+	// Ignore stdin and resize
+	go func() {
+		for {
+			select {
+			case _, ok := <-process.stdin:
+				if !ok {
+					log.Debug("stdin closed")
+					return
+				}
+
+			case <-process.resize:
+			}
+		}
+	}()
+
+	go func() {
+		startTime := time.Now()
+		termination, err := self.play(context, &process)
+
+		close(process.Stdout)
+		close(process.Stderr)
+		close(self.Resize)
+
+		process.exit(&ExitStatus{
+			Duration:    time.Since(startTime),
+			Termination: termination,
+		}, err)
+	}()
+
+	return &process, nil
+}
+
+func (self *Player) play(context contextpkg.Context, process *Process) (*Termination, error) {
+	var last float64
+
+	for {
+		var event AsciicastEvent
+		if err := self.decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			} else {
+				return nil, err
+			}
+		}
+
+		var ch chan []byte
+		var size Size
+		switch event.Code {
+		case OutputAsciicastEvent:
+			ch = process.Stdout
+		case ErrorAsciicastEvent:
+			ch = process.Stderr
+		case ResizeAsciicastEvent:
+			var err error
+			if size, err = ParseAsciicastResize(event.Data); err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+
+		delay := time.Duration((event.Time - last) * float64(time.Second))
+		last = event.Time
+
+		if (self.MaxIdle > 0) && (delay > self.MaxIdle) {
+			delay = self.MaxIdle
+		}
+
+		if (self.Speed > 0) && (delay > 0) {
+			timer := time.NewTimer(time.Duration(float64(delay) / self.Speed))
+			select {
+			case <-timer.C:
+			case <-context.Done():
+				timer.Stop()
+				return &Termination{Reason: CancelTermination}, nil
+			}
+		}
+
+		if ch == nil {
+			select {
+			case self.Resize <- size:
+			default:
+				log.Debug("resize dropped")
+			}
+			continue
+		}
+
+		select {
+		case ch <- []byte(event.Data):
+		case <-context.Done():
+			return &Termination{Reason: CancelTermination}, nil
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package exec
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tliron/go-kutil/util"
+)
+
+//
+// ProcessDriver
+//
+
+// The producing side of a [Process]. Backends (such as [Command.Start]) use it
+// to feed the output channels, receive stdin and resize requests, and report
+// the exit.
+type ProcessDriver struct {
+	Process *Process
+	Stdout  io.Writer // sends to Process.Stdout (and to the recorder, if there is one)
+	Stderr  io.Writer // sends to Process.Stderr (and to the recorder, if there is one)
+
+	// Derived from the context provided to [NewProcessDriver], and will
+	// be done after [Command.Timeout], if specified
+	Context contextpkg.Context
+
+	command       *Command
+	parent        contextpkg.Context
+	cancel        contextpkg.CancelFunc
+	cancelWriters contextpkg.CancelFunc
+}
+
+// Creates a [Process] according to the command's channel, overflow, and recorder
+// settings. It is up to the backend to interpret the rest of the command.
+func NewProcessDriver(context contextpkg.Context, command *Command) (*ProcessDriver, error) {
+	overflow := command.Overflow
+	if overflow == "" {
+		overflow = util.FailChannelOverflow
+	} else if err := overflow.Validate("Overflow"); err != nil {
+		return nil, err
+	}
+
+	if command.SpillSize < 0 {
+		return nil, fmt.Errorf("SpillSize is negative: %d", command.SpillSize)
+	}
+
+	process := newProcess(command.ChannelSize)
+
+	self := ProcessDriver{
+		Process: &process,
+		command: command,
+		parent:  context,
+	}
+
+	if command.Timeout > 0 {
+		self.Context, self.cancel = contextpkg.WithTimeout(context, command.Timeout)
+	} else {
+		self.Context, self.cancel = contextpkg.WithCancel(context)
+	}
+
+	// Note: we are not using self.Context so that output will not be cut off by
+	// the timeout, only once the process has been terminated
+	var writersContext contextpkg.Context
+	writersContext, self.cancelWriters = contextpkg.WithCancel(context)
+	process.stdoutWriter = util.NewChannelWriterFor(overflow, writersContext, process.Stdout, command.CopyBytes, command.SpillSize)
+	process.stderrWriter = util.NewChannelWriterFor(overflow, writersContext, process.Stderr, command.CopyBytes, command.SpillSize)
+	self.Stdout = process.stdoutWriter
+	self.Stderr = process.stderrWriter
+
+	if recorder := command.Recorder; recorder != nil {
+		size := DefaultAsciicastSize
+		if command.PseudoTerminal != nil {
+			size = *command.PseudoTerminal
+		}
+		if recorder.Header.Command == "" {
+			recorder.Header.Command = strings.Join(append([]string{command.Name}, command.Args...), " ")
+		}
+		if err := recorder.Start(size); err != nil {
+			self.cancelWriters()
+			self.cancel()
+			return nil, err
+		}
+
+		process.recorder = recorder
+		// Note: the recorder comes first because io.MultiWriter stops at the first
+		// error, and the channel writers may fail on overflow
+		stderrCode := OutputAsciicastEvent
+		if recorder.SeparateStderr {
+			stderrCode = ErrorAsciicastEvent
+		}
+		self.Stdout = io.MultiWriter(recorder.Writer(OutputAsciicastEvent), process.stdoutWriter)
+		self.Stderr = io.MultiWriter(recorder.Writer(stderrCode), process.stderrWriter)
+	}
+
+	return &self, nil
+}
+
+// Receives the bytes sent via [Process.Stdin]. Will be closed by [Process.Close].
+func (self *ProcessDriver) Stdin() <-chan []byte {
+	return self.Process.stdin
+}
+
+// An alternative to [ProcessDriver.Stdin] for backends that want an [io.Reader].
+// Do not use both.
+func (self *ProcessDriver) StdinReader() io.Reader {
+	return util.NewChannelReader(self.Process.stdin)
+}
+
+// Receives the sizes sent via [Process.Resize].
+func (self *ProcessDriver) Resize() <-chan Size {
+	return self.Process.resize
+}
+
+// Returns nil if [ProcessDriver.Context] is not done. Otherwise the reason
+// would be [TimeoutTermination] if [Command.Timeout] has passed, or
+// [CancelTermination] if the parent context is done.
+func (self *ProcessDriver) Termination() *Termination {
+	if self.Context.Err() == nil {
+		return nil
+	} else if (self.parent.Err() == nil) && errors.Is(self.Context.Err(), contextpkg.DeadlineExceeded) {
+		return &Termination{Reason: TimeoutTermination}
+	} else {
+		return &Termination{Reason: CancelTermination}
+	}
+}
+
+// Should be called by the backend after it terminated the process because
+// [ProcessDriver.Context] is done. From then on [ProcessDriver.Stdout] and
+// [ProcessDriver.Stderr] will no longer block (or spill) when the consumer is
+// not reading, so that the backend can exit.
+func (self *ProcessDriver) Terminated() {
+	self.cancelWriters()
+}
+
+// Must be called by the backend when the process has exited and all its output
+// has been written. Closes [Process.Stdout] and [Process.Stderr] and releases
+// [Process.Wait].
+//
+// The error is for failures other than the exit itself, in which case the
+// exit status may be nil.
+func (self *ProcessDriver) Exit(exitStatus *ExitStatus, err error) {
+	var exitError error
+	if err != nil {
+		exitError = err
+	} else if (exitStatus != nil) && !exitStatus.Success() {
+		exitError = &ExitError{exitStatus}
+	}
+
+	self.exit(exitStatus, err, exitError)
+}
+
+// Must be called by the backend if it failed to start the process, instead of
+// [ProcessDriver.Exit]. The [Process] should then be discarded.
+func (self *ProcessDriver) Abort() {
+	self.closeWriters()
+	self.cancelWriters()
+	self.cancel()
+}
+
+func (self *ProcessDriver) exit(exitStatus *ExitStatus, waitError error, exitError error) {
+	if recorder := self.Process.recorder; recorder != nil {
+		if err := recorder.Flush(); err != nil {
+			log.Errorf("recorder: %s", err.Error())
+		}
+	}
+
+	self.closeWriters()
+	self.cancelWriters()
+	self.cancel()
+
+	close(self.Process.Stdout)
+	close(self.Process.Stderr)
+
+	self.Process.exit(exitStatus, waitError)
+
+	self.command.Stop(exitError)
+}
+
+func (self *ProcessDriver) closeWriters() {
+	for _, writer := range []*util.ChannelWriter{self.Process.stdoutWriter, self.Process.stderrWriter} {
+		if err := writer.Close(); err != nil {
+			log.Errorf("channel writer: %s", err.Error())
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package exec
 
+import (
+	"github.com/tliron/go-kutil/util"
+)
+
 //
 // Process
 //
@@ -8,8 +12,15 @@ type Process struct {
 	Stdout chan []byte // receive from this
 	Stderr chan []byte // receive from this
 
-	stdin  chan []byte // send to this
-	resize chan Size   // send to this
+	stdin      chan []byte // send to this
+	resize     chan Size   // send to this
+	exited     chan struct{}
+	exitStatus *ExitStatus
+	exitError  error
+
+	stdoutWriter *util.ChannelWriter
+	stderrWriter *util.ChannelWriter
+	recorder     *Recorder
 }
 
 func newProcess(channelSize int) Process {
@@ -18,6 +29,7 @@ func newProcess(channelSize int) Process {
 		Stderr: make(chan []byte, channelSize),
 		stdin:  make(chan []byte, channelSize),
 		resize: make(chan Size, channelSize),
+		exited: make(chan struct{}),
 	}
 }
 
@@ -29,10 +41,57 @@ func (self *Process) Close() {
 
 func (self *Process) Stdin(p []byte) {
 	if p != nil {
+		if self.recorder != nil {
+			if err := self.recorder.Record(InputAsciicastEvent, p); err != nil {
+				log.Errorf("recorder: %s", err.Error())
+			}
+		}
+
 		self.stdin <- p
 	}
 }
 
 func (self *Process) Resize(width uint, height uint) {
-	self.resize <- Size{Width: width, Height: height}
+	size := Size{Width: width, Height: height}
+
+	if self.recorder != nil {
+		if err := self.recorder.RecordResize(size); err != nil {
+			log.Errorf("recorder: %s", err.Error())
+		}
+	}
+
+	self.resize <- size
+}
+
+// The number of stdout bytes that were dropped due to overflow.
+func (self *Process) StdoutDropped() uint64 {
+	if self.stdoutWriter != nil {
+		return self.stdoutWriter.Dropped()
+	} else {
+		return 0
+	}
+}
+
+// The number of stderr bytes that were dropped due to overflow.
+func (self *Process) StderrDropped() uint64 {
+	if self.stderrWriter != nil {
+		return self.stderrWriter.Dropped()
+	} else {
+		return 0
+	}
+}
+
+// Blocks until the process exits. Note that a non-zero exit code is not
+// considered an error, rather it is reported in the [ExitStatus]. The returned
+// error is for failures in waiting for the process, in which case the
+// [ExitStatus] may be nil.
+func (self *Process) Wait() (*ExitStatus, error) {
+	<-self.exited
+	return self.exitStatus, self.exitError
+}
+
+func (self *Process) exit(exitStatus *ExitStatus, err error) {
+	self.exitStatus = exitStatus
+	self.exitError = err
+	close(self.exited)
 }
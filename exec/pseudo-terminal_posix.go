@@ -0,0 +1,47 @@
+//go:build !windows && !wasm
+
+package exec
+
+import (
+	"os"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// Returns a non-blocking replacement for the pseudo-terminal file and closes
+// the original. The files returned by the pty package are in blocking mode, in
+// which closing them would not interrupt a pending read.
+//
+// On error the original is returned as is.
+func newPollablePseudoTerminal(file *os.File) (*os.File, error) {
+	fd, err := unix.Dup(int(file.Fd()))
+	if err != nil {
+		return file, err
+	}
+
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return file, err
+	}
+
+	pollable := os.NewFile(uintptr(fd), file.Name())
+	file.Close()
+	return pollable, nil
+}
+
+// Unlike [pty.Setsize] does not switch the file to blocking mode.
+func setPseudoTerminalSize(file *os.File, winsize *pty.Winsize) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var err_ error
+	if err := conn.Control(func(fd uintptr) {
+		err_ = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: winsize.Rows, Col: winsize.Cols, Xpixel: winsize.X, Ypixel: winsize.Y})
+	}); err != nil {
+		return err
+	}
+	return err_
+}
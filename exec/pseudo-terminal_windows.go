@@ -0,0 +1,17 @@
+//go:build windows
+
+package exec
+
+import (
+	"os"
+
+	"github.com/creack/pty"
+)
+
+func newPollablePseudoTerminal(file *os.File) (*os.File, error) {
+	return file, nil
+}
+
+func setPseudoTerminalSize(file *os.File, winsize *pty.Winsize) error {
+	return pty.Setsize(file, winsize)
+}
@@ -0,0 +1,187 @@
+package exec
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//
+// Recorder
+//
+
+type Recorder struct {
+	Header AsciicastHeader
+
+	// When true stderr is recorded as [ErrorAsciicastEvent] instead of
+	// [OutputAsciicastEvent]
+	SeparateStderr bool
+
+	writer  io.Writer
+	start   time.Time
+	pending map[any]recorderPending // keyed by code or by writer
+	lock    sync.Mutex
+}
+
+type recorderPending struct {
+	code AsciicastEventCode
+	p    []byte
+}
+
+// Creates a recorder that writes an asciicast v2 recording (JSON lines) to
+// the writer. [Recorder.Start] must be called before recording events.
+//
+// Note that the recorder does not close the writer.
+func NewRecorder(writer io.Writer) *Recorder {
+	return &Recorder{
+		Header: AsciicastHeader{
+			Version: ASCIICAST_VERSION,
+		},
+		writer:  writer,
+		pending: make(map[any]recorderPending),
+	}
+}
+
+// Writes the header and starts the clock.
+func (self *Recorder) Start(size Size) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.start = time.Now()
+	self.Header.Width = size.Width
+	self.Header.Height = size.Height
+	self.Header.Timestamp = self.start.Unix()
+
+	return self.writeLine(self.Header)
+}
+
+// Bytes are recorded only up to the last complete UTF-8 character. The rest
+// will be recorded with the next call for the same code, or with
+// [Recorder.Flush].
+func (self *Recorder) Record(code AsciicastEventCode, p []byte) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.recordComplete(code, code, p)
+}
+
+func (self *Recorder) RecordResize(size Size) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.record(ResizeAsciicastEvent, FormatAsciicastResize(size))
+}
+
+func (self *Recorder) RecordMarker(label string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.record(MarkerAsciicastEvent, label)
+}
+
+// Records all pending incomplete UTF-8 characters. Invalid UTF-8 will be
+// replaced with the Unicode replacement character.
+func (self *Recorder) Flush() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for key, pending := range self.pending {
+		delete(self.pending, key)
+		if err := self.record(pending.code, string(pending.p)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns an [io.Writer] that records all writes with the code. Incomplete
+// UTF-8 characters are kept separately for each writer, so that several
+// writers may share a code.
+func (self *Recorder) Writer(code AsciicastEventCode) io.Writer {
+	return &recorderWriter{self, code}
+}
+
+// Call while holding lock
+func (self *Recorder) recordComplete(key any, code AsciicastEventCode, p []byte) error {
+	if pending, ok := self.pending[key]; ok {
+		p = append(pending.p, p...)
+	}
+
+	complete := completeUTF8(p)
+
+	var err error
+	if complete > 0 {
+		err = self.record(code, string(p[:complete]))
+	}
+
+	if complete < len(p) {
+		self.pending[key] = recorderPending{code, append([]byte(nil), p[complete:]...)}
+	} else {
+		delete(self.pending, key)
+	}
+
+	return err
+}
+
+// Call while holding lock
+func (self *Recorder) record(code AsciicastEventCode, data string) error {
+	return self.writeLine(AsciicastEvent{
+		Time: time.Since(self.start).Seconds(),
+		Code: code,
+		Data: data,
+	})
+}
+
+// Call while holding lock
+func (self *Recorder) writeLine(value any) error {
+	if line, err := json.Marshal(value); err == nil {
+		line = append(line, '\n')
+		_, err = self.writer.Write(line)
+		return err
+	} else {
+		return err
+	}
+}
+
+// Returns the length of the prefix of p that does not end with an incomplete
+// UTF-8 character.
+func completeUTF8(p []byte) int {
+	length := len(p)
+
+	// A UTF-8 character is at most utf8.UTFMax bytes
+	for index := length - 1; (index >= 0) && (index >= length-utf8.UTFMax); index-- {
+		if utf8.RuneStart(p[index]) {
+			if utf8.FullRune(p[index:]) {
+				return length
+			} else {
+				return index
+			}
+		}
+	}
+
+	return length
+}
+
+//
+// recorderWriter
+//
+
+type recorderWriter struct {
+	recorder *Recorder
+	code     AsciicastEventCode
+}
+
+// ([io.Writer] interface)
+func (self *recorderWriter) Write(p []byte) (int, error) {
+	self.recorder.lock.Lock()
+	defer self.recorder.lock.Unlock()
+
+	if err := self.recorder.recordComplete(self, self.code, p); err == nil {
+		return len(p), nil
+	} else {
+		return 0, err
+	}
+}
@@ -0,0 +1,24 @@
+//go:build windows || wasm
+
+package exec
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(command *exec.Cmd, mode ProcessGroupMode) {
+	// Not supported on this platform
+}
+
+func signalProcess(process *os.Process, signal os.Signal, group bool) error {
+	if signal == os.Kill {
+		return process.Kill()
+	} else {
+		return process.Signal(signal)
+	}
+}
+
+func processGroupExists(process *os.Process) bool {
+	return false
+}
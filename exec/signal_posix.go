@@ -0,0 +1,44 @@
+//go:build !windows && !wasm
+
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(command *exec.Cmd, mode ProcessGroupMode) {
+	switch mode {
+	case OwnProcessGroup:
+		if command.SysProcAttr == nil {
+			command.SysProcAttr = new(syscall.SysProcAttr)
+		}
+		command.SysProcAttr.Setpgid = true
+
+	case OwnSession:
+		if command.SysProcAttr == nil {
+			command.SysProcAttr = new(syscall.SysProcAttr)
+		}
+		command.SysProcAttr.Setsid = true
+	}
+}
+
+func signalProcess(process *os.Process, signal os.Signal, group bool) error {
+	if group {
+		if signal_, ok := signal.(syscall.Signal); ok {
+			// The process is the leader of its group, so the group ID is the same as its PID
+			// and a negative PID means the whole group
+			return syscall.Kill(-process.Pid, signal_)
+		}
+	}
+
+	return process.Signal(signal)
+}
+
+// Whether any process is still in the group led by the process (which may
+// itself have exited).
+func processGroupExists(process *os.Process) bool {
+	// Signal 0 only checks for existence; EPERM means that it exists
+	return syscall.Kill(-process.Pid, 0) != syscall.ESRCH
+}
@@ -0,0 +1,245 @@
+package exec
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tliron/go-kutil/util"
+	"golang.org/x/crypto/ssh"
+)
+
+// Used for the pseudo-terminal when the command's Environment does not have "TERM"
+var DefaultSSHTerm = "xterm-256color"
+
+// Starts the command on the remote host and returns it as a [Process]. The
+// client is not closed.
+//
+// Name and Args are quoted for the remote shell, and Dir is entered with "cd".
+// Environment is sent as "env" requests, which many servers reject unless the
+// variables are allowed by their configuration (e.g. AcceptEnv in OpenSSH).
+// With a PseudoTerminal the server will usually merge stderr into stdout.
+//
+// When the context is cancelled (or the timeout passes) the termination
+// sequence is sent as SSH signals, after which the session is closed. Note that
+// not all servers support signals.
+func StartSSHProcess(context contextpkg.Context, client *ssh.Client, command *Command) (*Process, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := NewProcessDriver(context, command)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	abort := func(err error) (*Process, error) {
+		session.Close()
+		driver.Abort()
+		return nil, err
+	}
+
+	for key, value := range command.Environment {
+		if err := session.Setenv(key, value); err != nil {
+			return abort(fmt.Errorf("environment variable %q: %w", key, err))
+		}
+	}
+
+	if size := command.PseudoTerminal; size != nil {
+		term := command.Environment["TERM"]
+		if term == "" {
+			term = DefaultSSHTerm
+		}
+
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+
+		if err := session.RequestPty(term, int(size.Height), int(size.Width), modes); err != nil {
+			return abort(err)
+		}
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return abort(err)
+	}
+
+	session.Stdout = driver.Stdout
+	session.Stderr = driver.Stderr
+
+	commandLine := util.JoinShellQuote(append([]string{command.Name}, command.Args...)...)
+	if command.Dir != "" {
+		commandLine = "cd " + util.ShellQuote(command.Dir) + " && " + commandLine
+	}
+
+	startTime := time.Now()
+	if err := session.Start(commandLine); err != nil {
+		return abort(err)
+	}
+
+	waited := make(chan struct{})
+	var termination *Termination
+	var terminationLock sync.Mutex
+
+	terminate := func(reason TerminationReason) {
+		defer driver.Terminated()
+
+		sequence := command.Termination
+		if sequence == nil {
+			sequence = DefaultTerminationSequence
+		}
+
+		for index, stage := range sequence {
+			terminationLock.Lock()
+			select {
+			case <-waited:
+				terminationLock.Unlock()
+				return
+			default:
+			}
+			termination = &Termination{Reason: reason, Stage: index, Signal: stage.Signal}
+			terminationLock.Unlock()
+
+			log.Infof("sending %s to SSH session (%s)", stage.Signal, reason)
+			if signal, ok := ToSSHSignal(stage.Signal); ok {
+				if err := session.Signal(signal); err != nil {
+					log.Warningf("SSH signal: %s", err.Error())
+				}
+			} else {
+				log.Warningf("unsupported SSH signal: %s", stage.Signal)
+			}
+
+			if stage.Grace > 0 {
+				timer := time.NewTimer(stage.Grace)
+				select {
+				case <-timer.C:
+				case <-waited:
+					timer.Stop()
+					return
+				}
+			}
+		}
+
+		// Closing the channel will end the command on most servers (with a
+		// pseudo-terminal it would get SIGHUP)
+		session.Close()
+	}
+
+	// Stdin
+	go func() {
+		defer stdin.Close()
+		for p := range driver.Stdin() {
+			if _, err := stdin.Write(p); err != nil {
+				log.Warningf("SSH stdin: %s", err.Error())
+				return
+			}
+		}
+		log.Debug("stdin closed")
+	}()
+
+	// Control
+	go func() {
+		for {
+			select {
+			case size := <-driver.Resize():
+				if command.PseudoTerminal != nil {
+					if err := session.WindowChange(int(size.Height), int(size.Width)); err != nil {
+						log.Warningf("SSH window change: %s", err.Error())
+					}
+				}
+
+			case <-driver.Context.Done():
+				go terminate(driver.Termination().Reason)
+				return
+
+			case <-waited:
+				return
+			}
+		}
+	}()
+
+	// Wait
+	go func() {
+		err := session.Wait()
+
+		terminationLock.Lock()
+		close(waited)
+		termination_ := termination
+		terminationLock.Unlock()
+
+		session.Close()
+
+		exitStatus := ExitStatus{
+			Duration:    time.Since(startTime),
+			Termination: termination_,
+		}
+
+		if err != nil {
+			var exitError *ssh.ExitError
+			var exitMissingError *ssh.ExitMissingError
+			if errors.As(err, &exitError) {
+				exitStatus.Code = exitError.ExitStatus()
+				if signal := exitError.Signal(); signal != "" {
+					exitStatus.Signal = SSHSignal(signal)
+				}
+			} else if (termination_ != nil) && (errors.As(err, &exitMissingError) || errors.Is(err, io.EOF)) {
+				// We closed the session
+				exitStatus.Code = -1
+			} else {
+				driver.Exit(nil, fmt.Errorf("SSH command: %w", err))
+				return
+			}
+		}
+
+		driver.Exit(&exitStatus, nil)
+	}()
+
+	return driver.Process, nil
+}
+
+//
+// SSHSignal
+//
+
+// An [os.Signal] reported by an SSH server, by name without the "SIG" prefix
+// (e.g. "TERM").
+type SSHSignal ssh.Signal
+
+// ([os.Signal] interface)
+func (self SSHSignal) Signal() {}
+
+// ([fmt.Stringer] interface)
+func (self SSHSignal) String() string {
+	return "SIG" + string(self)
+}
+
+// Converts the signal for sending to an SSH server. Supported are [SSHSignal]
+// and the common signals defined on all platforms.
+func ToSSHSignal(signal os.Signal) (ssh.Signal, bool) {
+	switch signal_ := signal.(type) {
+	case SSHSignal:
+		return ssh.Signal(signal_), true
+	case syscall.Signal:
+		switch signal_ {
+		case syscall.SIGINT:
+			return ssh.SIGINT, true
+		case syscall.SIGKILL:
+			return ssh.SIGKILL, true
+		case syscall.SIGQUIT:
+			return ssh.SIGQUIT, true
+		case syscall.SIGTERM:
+			return ssh.SIGTERM, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,81 @@
+package exec
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/tliron/go-kutil/internal/sshtest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHProcess(t *testing.T) {
+	server, err := sshtest.NewServer(func(session *sshtest.Session) uint32 {
+		fmt.Fprintf(session.Stdout, "%s\n", session.Command)
+		if session.PseudoTerminal != nil {
+			fmt.Fprintf(session.Stdout, "%s %dx%d\n", session.Term, session.PseudoTerminal.Width, session.PseudoTerminal.Height)
+		}
+
+		line, _ := bufio.NewReader(session.Stdin).ReadString('\n')
+		fmt.Fprintf(session.Stdout, "stdin: %s", line)
+
+		window := <-session.WindowChanges
+		fmt.Fprintf(session.Stdout, "resize: %dx%d\n", window.Width, window.Height)
+
+		return 5
+	})
+	if err != nil {
+		t.Errorf("sshtest.NewServer: %s", err.Error())
+		return
+	}
+	server.Password = "test"
+	if err := server.Start(); err != nil {
+		t.Errorf("server.Start: %s", err.Error())
+		return
+	}
+	defer server.Close()
+
+	client, err := ssh.Dial("tcp", server.Address, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("test")},
+		HostKeyCallback: ssh.FixedHostKey(server.HostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Errorf("ssh.Dial: %s", err.Error())
+		return
+	}
+	defer client.Close()
+
+	command := NewCommand()
+	command.Name = "echo"
+	command.Args = []string{"hello world"}
+	command.PseudoTerminal = &Size{Width: 100, Height: 30}
+
+	process, err := StartSSHProcess(context.TODO(), client, command)
+	if err != nil {
+		t.Errorf("StartSSHProcess: %s", err.Error())
+		return
+	}
+
+	process.Stdin([]byte("ping\n"))
+	process.Resize(120, 40)
+	process.Close()
+
+	var stdout string
+	for p := range process.Stdout {
+		stdout += string(p)
+	}
+
+	if expected := "echo 'hello world'\nxterm-256color 100x30\nstdin: ping\nresize: 120x40\n"; stdout != expected {
+		t.Errorf("stdout: %q", stdout)
+	}
+
+	if exitStatus, err := process.Wait(); err == nil {
+		if exitStatus.Code != 5 {
+			t.Errorf("exit status: %s", exitStatus)
+		}
+	} else {
+		t.Errorf("process.Wait: %s", err.Error())
+	}
+}
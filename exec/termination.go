@@ -0,0 +1,54 @@
+package exec
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Kills the process immediately. This is the default.
+var DefaultTerminationSequence = []TerminationStage{
+	{Signal: os.Kill},
+}
+
+// Sends SIGTERM and then, if the process did not exit within the grace period,
+// sends SIGKILL.
+func GracefulTerminationSequence(grace time.Duration) []TerminationStage {
+	return []TerminationStage{
+		{Signal: syscall.SIGTERM, Grace: grace},
+		{Signal: os.Kill},
+	}
+}
+
+//
+// TerminationStage
+//
+
+type TerminationStage struct {
+	Signal os.Signal
+	Grace  time.Duration // how long to wait for the process to exit before moving on to the next stage
+}
+
+//
+// TerminationReason
+//
+
+type TerminationReason string
+
+const (
+	// The context was cancelled.
+	CancelTermination TerminationReason = "cancel"
+
+	// [Command.Timeout] has passed.
+	TimeoutTermination TerminationReason = "timeout"
+)
+
+//
+// Termination
+//
+
+type Termination struct {
+	Reason TerminationReason
+	Stage  int       // index in the termination sequence of the last stage that was reached
+	Signal os.Signal // the signal sent in that stage
+}
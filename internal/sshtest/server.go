@@ -0,0 +1,284 @@
+package sshtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+//
+// Server
+//
+
+// A minimal in-process SSH server for tests. Instead of running commands in
+// a shell it calls a handler. Supports "exec" sessions with environment
+// variables, pseudo-terminals, window changes, and signals, as well as the
+// "sftp" subsystem when SFTPRoot is set.
+type Server struct {
+	Address        string // available after Server.Start
+	HostKey        ssh.Signer
+	Password       string          // when not empty will be allowed
+	AuthorizedKeys []ssh.PublicKey // when not empty will be allowed
+	Handler        Handler
+	SFTPRoot       string // when not empty the "sftp" subsystem will serve the files under it
+
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+// Returns the exit status of the command.
+type Handler func(session *Session) uint32
+
+// Creates a server with a random ed25519 host key.
+func NewServer(handler Handler) (*Server, error) {
+	if _, key, err := ed25519.GenerateKey(rand.Reader); err == nil {
+		if hostKey, err := ssh.NewSignerFromKey(key); err == nil {
+			return &Server{
+				HostKey: hostKey,
+				Handler: handler,
+			}, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Listens on a random port on the loopback interface. Either Password or
+// AuthorizedKeys must be set.
+func (self *Server) Start() error {
+	if (self.Password == "") && (len(self.AuthorizedKeys) == 0) {
+		return errors.New("no password or authorized keys")
+	}
+
+	var config ssh.ServerConfig
+
+	if self.Password != "" {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == self.Password {
+				return nil, nil
+			} else {
+				return nil, errors.New("wrong password")
+			}
+		}
+	}
+
+	if len(self.AuthorizedKeys) > 0 {
+		config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			marshalled := key.Marshal()
+			for _, authorizedKey := range self.AuthorizedKeys {
+				if bytes.Equal(authorizedKey.Marshal(), marshalled) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unauthorized key")
+		}
+	}
+
+	config.AddHostKey(self.HostKey)
+
+	var err error
+	if self.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
+	}
+	self.Address = self.listener.Addr().String()
+
+	go func() {
+		for {
+			if conn, err := self.listener.Accept(); err == nil {
+				self.lock.Lock()
+				self.conns = append(self.conns, conn)
+				self.lock.Unlock()
+
+				go self.serve(conn, &config)
+			} else {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stops listening and closes all connections.
+func (self *Server) Close() error {
+	var err error
+	if self.listener != nil {
+		err = self.listener.Close()
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, conn := range self.conns {
+		conn.Close()
+	}
+	self.conns = nil
+
+	return err
+}
+
+func (self *Server) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+
+	// Includes keepalives, which will be replied to with false
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		if channel, requests, err := newChannel.Accept(); err == nil {
+			go self.serveSession(channel, requests)
+		}
+	}
+}
+
+func (self *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	session := Session{
+		Stdin:         channel,
+		Stdout:        channel,
+		Stderr:        channel.Stderr(),
+		Environment:   make(map[string]string),
+		WindowChanges: make(chan Window, 10),
+		Signals:       make(chan ssh.Signal, 10),
+	}
+
+	for request := range requests {
+		switch request.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(request.Payload, &payload) == nil {
+				session.Environment[payload.Name] = payload.Value
+				request.Reply(true, nil)
+			} else {
+				request.Reply(false, nil)
+			}
+
+		case "pty-req":
+			var payload struct {
+				Term                                     string
+				Columns, Rows, WidthPixels, HeightPixels uint32
+				Modes                                    string
+			}
+			if ssh.Unmarshal(request.Payload, &payload) == nil {
+				session.Term = payload.Term
+				session.PseudoTerminal = &Window{Width: payload.Columns, Height: payload.Rows}
+				request.Reply(true, nil)
+			} else {
+				request.Reply(false, nil)
+			}
+
+		case "window-change":
+			var payload struct{ Columns, Rows, WidthPixels, HeightPixels uint32 }
+			if ssh.Unmarshal(request.Payload, &payload) == nil {
+				select {
+				case session.WindowChanges <- Window{Width: payload.Columns, Height: payload.Rows}:
+				default:
+				}
+			}
+
+		case "signal":
+			var payload struct{ Signal string }
+			if ssh.Unmarshal(request.Payload, &payload) == nil {
+				select {
+				case session.Signals <- ssh.Signal(payload.Signal):
+				default:
+				}
+			}
+
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(request.Payload, &payload) == nil {
+				session.Command = payload.Command
+				request.Reply(true, nil)
+				go self.run(channel, &session)
+			} else {
+				request.Reply(false, nil)
+			}
+
+		case "subsystem":
+			var payload struct{ Name string }
+			if (ssh.Unmarshal(request.Payload, &payload) == nil) && (payload.Name == "sftp") && (self.SFTPRoot != "") {
+				request.Reply(true, nil)
+				go self.serveSFTP(channel)
+			} else {
+				request.Reply(false, nil)
+			}
+
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (self *Server) serveSFTP(channel ssh.Channel) {
+	var status uint32
+	server := sftp.NewRequestServer(channel, newSFTPHandlers(self.SFTPRoot))
+	if err := server.Serve(); (err != nil) && (err != io.EOF) {
+		status = 1
+	}
+
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	channel.Close()
+}
+
+func (self *Server) run(channel ssh.Channel, session *Session) {
+	status := self.Handler(session)
+
+	if session.ExitSignal != "" {
+		channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+			Signal     string
+			CoreDumped bool
+			Message    string
+			Lang       string
+		}{Signal: string(session.ExitSignal)}))
+	} else {
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	}
+
+	channel.Close()
+}
+
+//
+// Session
+//
+
+type Session struct {
+	Command        string
+	Environment    map[string]string
+	Term           string
+	PseudoTerminal *Window // nil if not requested
+	Stdin          io.Reader
+	Stdout         io.Writer
+	Stderr         io.Writer
+	WindowChanges  chan Window
+	Signals        chan ssh.Signal
+	ExitSignal     ssh.Signal // when set by the handler will be reported instead of the exit status
+}
+
+//
+// Window
+//
+
+type Window struct {
+	Width  uint32
+	Height uint32
+}
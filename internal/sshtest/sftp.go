@@ -0,0 +1,180 @@
+package sshtest
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+//
+// sftpHandlers
+//
+
+// Serves the files under a root directory. Paths are cleaned and joined to the
+// root, so they cannot refer to files outside of it. Symbolic links cannot be
+// created, but existing ones are followed as is.
+type sftpHandlers struct {
+	root string
+}
+
+func newSFTPHandlers(root string) sftp.Handlers {
+	handlers := sftpHandlers{root}
+	return sftp.Handlers{
+		FileGet:  handlers,
+		FilePut:  handlers,
+		FileCmd:  handlers,
+		FileList: handlers,
+	}
+}
+
+// ([sftp.FileReader] interface)
+func (self sftpHandlers) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(self.path(request.Filepath))
+}
+
+// ([sftp.FileWriter] interface)
+func (self sftpHandlers) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	flags := request.Pflags()
+
+	osFlags := os.O_WRONLY
+	if flags.Read {
+		osFlags = os.O_RDWR
+	}
+	if flags.Creat {
+		osFlags |= os.O_CREATE
+	}
+	if flags.Trunc {
+		osFlags |= os.O_TRUNC
+	}
+	if flags.Excl {
+		osFlags |= os.O_EXCL
+	}
+
+	mode := fs.FileMode(0644)
+	if request.AttrFlags().Permissions {
+		mode = request.Attributes().FileMode().Perm()
+	}
+
+	return os.OpenFile(self.path(request.Filepath), osFlags, mode)
+}
+
+// ([sftp.FileCmder] interface)
+func (self sftpHandlers) Filecmd(request *sftp.Request) error {
+	path_ := self.path(request.Filepath)
+
+	switch request.Method {
+	case "Setstat":
+		attrFlags := request.AttrFlags()
+		attributes := request.Attributes()
+		if attrFlags.Size {
+			if err := os.Truncate(path_, int64(attributes.Size)); err != nil {
+				return err
+			}
+		}
+		if attrFlags.Permissions {
+			if err := os.Chmod(path_, attributes.FileMode().Perm()); err != nil {
+				return err
+			}
+		}
+		if attrFlags.Acmodtime {
+			if err := os.Chtimes(path_, attributes.AccessTime(), attributes.ModTime()); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "Rename":
+		return os.Rename(path_, self.path(request.Target))
+
+	case "Rmdir", "Remove":
+		return os.Remove(path_)
+
+	case "Mkdir":
+		return os.Mkdir(path_, 0755)
+
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// ([sftp.FileLister] interface)
+func (self sftpHandlers) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	path_ := self.path(request.Filepath)
+
+	switch request.Method {
+	case "List":
+		if entries, err := os.ReadDir(path_); err == nil {
+			infos := make(sftpListerAt, 0, len(entries))
+			for _, entry := range entries {
+				if info, err := entry.Info(); err == nil {
+					infos = append(infos, info)
+				} else {
+					return nil, err
+				}
+			}
+			return infos, nil
+		} else {
+			return nil, err
+		}
+
+	case "Stat":
+		if info, err := os.Stat(path_); err == nil {
+			return sftpListerAt{info}, nil
+		} else {
+			return nil, err
+		}
+
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// ([sftp.LstatFileLister] interface)
+func (self sftpHandlers) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+	if info, err := os.Lstat(self.path(request.Filepath)); err == nil {
+		return sftpListerAt{info}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// ([sftp.ReadlinkFileLister] interface)
+func (self sftpHandlers) Readlink(path_ string) (string, error) {
+	if target, err := os.Readlink(self.path(path_)); err == nil {
+		// Relative targets only, so as not to expose the root
+		if filepath.IsAbs(target) {
+			return "", errors.New("absolute symbolic link")
+		}
+		return filepath.ToSlash(target), nil
+	} else {
+		return "", err
+	}
+}
+
+func (self sftpHandlers) path(path_ string) string {
+	return filepath.Join(self.root, filepath.FromSlash(path.Clean("/"+path_)))
+}
+
+//
+// sftpListerAt
+//
+
+type sftpListerAt []fs.FileInfo
+
+// ([sftp.ListerAt] interface)
+func (self sftpListerAt) ListAt(infos []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(self)) {
+		return 0, io.EOF
+	}
+
+	n := copy(infos, self[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
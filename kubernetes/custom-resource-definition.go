@@ -6,6 +6,8 @@ import (
 	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 )
 
+var customResourceDefinitionGVK = apiextensions.SchemeGroupVersion.WithKind("CustomResourceDefinition")
+
 func JSONString(value any) apiextensions.JSON {
 	return apiextensions.JSON{
 		Raw: []byte(fmt.Sprintf("%q", value)),
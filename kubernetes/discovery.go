@@ -2,85 +2,147 @@ package kubernetes
 
 import (
 	contextpkg "context"
-	"time"
+	"sync"
 
 	"github.com/tliron/commonlog"
 	core "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/labels"
+	kubernetespkg "k8s.io/client-go/kubernetes"
 )
 
 //
 // PodDiscovery
 //
 
-type PodsDiscoveredFunc func([]*core.Pod)
+// A pod that changed its IP is both removed (with the old IP) and added (with
+// the new IP) in the same call.
+type PodsChangedFunc func(added []*core.Pod, removed []*core.Pod)
 
+// Discovers the pods matching a label selector via an informer.
+//
+// Only pods that are Ready and have an IP are considered members. Pods that
+// are being deleted are no longer members.
 type PodDiscovery struct {
-	selector       string
-	context        contextpkg.Context
-	podsDiscovered PodsDiscoveredFunc
-	log            commonlog.Logger
+	Namespace string
+	Selector  string
 
-	pods    typedcore.PodInterface
-	started chan struct{}
+	selector    labels.Selector
+	podsChanged PodsChangedFunc
+	log         commonlog.Logger
+	cancel      contextpkg.CancelFunc
+	members     map[string]*core.Pod
+	lock        sync.Mutex
 }
 
-func StartPodDiscovery(namespace string, selector string, frequency float64, podsDiscovered PodsDiscoveredFunc, log commonlog.Logger) (*PodDiscovery, error) {
+// Starts discovering pods matching the label selector. An empty selector
+// selects all pods in the namespace. The current members are reported
+// (as added) before this function returns.
+//
+// Cancelling the context is equivalent to calling [PodDiscovery.Stop].
+func StartPodDiscovery(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, selector string, podsChanged PodsChangedFunc, log commonlog.Logger) (*PodDiscovery, error) {
+	selector_, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+
 	self := PodDiscovery{
-		selector:       selector,
-		context:        contextpkg.TODO(),
-		podsDiscovered: podsDiscovered,
-		log:            log,
+		Namespace:   namespace,
+		Selector:    selector,
+		selector:    selector_,
+		podsChanged: podsChanged,
+		log:         log,
+		members:     make(map[string]*core.Pod),
 	}
 
-	if config, err := rest.InClusterConfig(); err == nil {
-		if client, err := kubernetes.NewForConfig(config); err == nil {
-			self.pods = client.CoreV1().Pods(namespace)
-			self.start(frequency)
-			return &self, nil
-		} else {
-			return nil, err
-		}
-	} else {
+	var informerContext contextpkg.Context
+	informerContext, self.cancel = contextpkg.WithCancel(context)
+	if _, _, err := startInformer(informerContext, kubernetes, namespace, selector, getPodInformer, newPodEventHandler(self.podUpdated, self.podDeleted)); err != nil {
+		self.cancel()
 		return nil, err
 	}
+
+	return &self, nil
 }
 
+// Stops the informer. No more changes will be reported after it has shut down.
 func (self *PodDiscovery) Stop() {
-	close(self.started)
+	self.cancel()
 }
 
-func (self *PodDiscovery) start(frequency float64) {
-	ticker := time.NewTicker(time.Duration(frequency * 1000000000.0)) // seconds to nanoseconds
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				self.list()
-
-			case <-self.started:
-				ticker.Stop()
-				return
-			}
+// The current members.
+func (self *PodDiscovery) Pods() []*core.Pod {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	pods := make([]*core.Pod, 0, len(self.members))
+	for _, pod := range self.members {
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func (self *PodDiscovery) podUpdated(pod *core.Pod) {
+	var added, removed []*core.Pod
+
+	key := pod.Namespace + "/" + pod.Name
+	// The selector is also enforced by the server, but we make sure
+	isMember := self.selector.Matches(labels.Set(pod.Labels)) && isPodDiscoverable(pod)
+
+	self.lock.Lock()
+	member, wasMember := self.members[key]
+	if isMember {
+		if !wasMember {
+			added = append(added, pod)
+		} else if (member.UID != pod.UID) || (member.Status.PodIP != pod.Status.PodIP) {
+			removed = append(removed, member)
+			added = append(added, pod)
 		}
-	}()
+		self.members[key] = pod
+	} else if wasMember {
+		removed = append(removed, member)
+		delete(self.members, key)
+	}
+	self.lock.Unlock()
+
+	self.notify(added, removed)
 }
 
-func (self *PodDiscovery) list() {
-	//self.log.Debugf("listing nodes: %s", self.selector)
-	if pods, err := self.pods.List(self.context, meta.ListOptions{LabelSelector: self.selector}); err == nil {
-		var list []*core.Pod
-		for _, pod := range pods.Items {
-			list = append(list, &pod)
+func (self *PodDiscovery) podDeleted(pod *core.Pod) {
+	key := pod.Namespace + "/" + pod.Name
+
+	self.lock.Lock()
+	member, wasMember := self.members[key]
+	delete(self.members, key)
+	self.lock.Unlock()
+
+	if wasMember {
+		self.notify(nil, []*core.Pod{member})
+	}
+}
+
+// Informer handlers are called sequentially, so the changes are reported in
+// order.
+func (self *PodDiscovery) notify(added []*core.Pod, removed []*core.Pod) {
+	if (len(added) == 0) && (len(removed) == 0) {
+		return
+	}
+
+	if self.log != nil {
+		for _, pod := range removed {
+			self.log.Infof("pod removed: %s/%s %s", pod.Namespace, pod.Name, pod.Status.PodIP)
 		}
-		self.podsDiscovered(list)
-	} else if !errors.IsNotFound(err) {
-		if self.log != nil {
-			self.log.Errorf("%s", err.Error())
+		for _, pod := range added {
+			self.log.Infof("pod added: %s/%s %s", pod.Namespace, pod.Name, pod.Status.PodIP)
 		}
 	}
+
+	if self.podsChanged != nil {
+		self.podsChanged(added, removed)
+	}
+}
+
+// Utils
+
+func isPodDiscoverable(pod *core.Pod) bool {
+	return IsPodReady(pod) && (pod.Status.PodIP != "")
 }
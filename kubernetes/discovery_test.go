@@ -0,0 +1,109 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testPodsChange struct {
+	added   []string
+	removed []string
+}
+
+func TestPodDiscovery(t *testing.T) {
+	kubernetes := fake.NewClientset(
+		newTestDiscoveryPod("a", "10.0.0.1", true),
+		newTestDiscoveryPod("b", "", false),
+	)
+
+	changes := make(chan testPodsChange, 10)
+	discovery, err := StartPodDiscovery(context.TODO(), kubernetes, "default", "app=test", func(added []*core.Pod, removed []*core.Pod) {
+		var change testPodsChange
+		for _, pod := range added {
+			change.added = append(change.added, pod.Status.PodIP)
+		}
+		for _, pod := range removed {
+			change.removed = append(change.removed, pod.Status.PodIP)
+		}
+		changes <- change
+	}, nil)
+	if err != nil {
+		t.Fatalf("StartPodDiscovery: %s", err.Error())
+	}
+	defer discovery.Stop()
+
+	// Only the ready pod
+	expectPodsChange(t, changes, []string{"10.0.0.1"}, nil)
+	if pods := discovery.Pods(); len(pods) != 1 {
+		t.Errorf("Pods: %d", len(pods))
+	}
+
+	pods := kubernetes.CoreV1().Pods("default")
+
+	// Becomes ready
+	pods.Update(context.TODO(), newTestDiscoveryPod("b", "10.0.0.2", true), meta.UpdateOptions{})
+	expectPodsChange(t, changes, []string{"10.0.0.2"}, nil)
+
+	// Changes IP
+	pods.Update(context.TODO(), newTestDiscoveryPod("b", "10.0.0.3", true), meta.UpdateOptions{})
+	expectPodsChange(t, changes, []string{"10.0.0.3"}, []string{"10.0.0.2"})
+
+	// Not ready anymore
+	pods.Update(context.TODO(), newTestDiscoveryPod("a", "10.0.0.1", false), meta.UpdateOptions{})
+	expectPodsChange(t, changes, nil, []string{"10.0.0.1"})
+
+	// Deleted
+	pods.Delete(context.TODO(), "b", meta.DeleteOptions{})
+	expectPodsChange(t, changes, nil, []string{"10.0.0.3"})
+
+	// Not selected
+	pod := newTestDiscoveryPod("c", "10.0.0.4", true)
+	pod.Labels = nil
+	pods.Create(context.TODO(), pod, meta.CreateOptions{})
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change: %v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if pods := discovery.Pods(); len(pods) != 0 {
+		t.Errorf("Pods: %d", len(pods))
+	}
+}
+
+func expectPodsChange(t *testing.T, changes <-chan testPodsChange, added []string, removed []string) {
+	t.Helper()
+
+	select {
+	case change := <-changes:
+		if !equalStrings(change.added, added) || !equalStrings(change.removed, removed) {
+			t.Errorf("change: %v, expected added %v and removed %v", change, added, removed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change, expected added %v and removed %v", added, removed)
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index, s := range a {
+		if s != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestDiscoveryPod(name string, ip string, ready bool) *core.Pod {
+	pod := newTestPod(name, map[string]string{"app": "test"}, ready)
+	pod.Status.PodIP = ip
+	pod.Status.PodIPs = []core.PodIP{{IP: ip}}
+	return pod
+}
@@ -0,0 +1,330 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	namepkg "github.com/google/go-containerregistry/pkg/name"
+	"github.com/tliron/go-ard"
+	core "k8s.io/api/core/v1"
+)
+
+// Credential helpers are executables named with this prefix followed by the
+// helper name, e.g. "docker-credential-pass".
+var DockerCredentialHelperPrefix = "docker-credential-"
+
+// Docker Hub's key in Docker's config.json and for credential helpers.
+const DockerHubServer = "https://index.docker.io/v1/"
+
+//
+// DockerConfig
+//
+
+// Docker's config.json (also used by Podman as auth.json), or the legacy
+// .dockercfg.
+type DockerConfig struct {
+	Auths RegistryCredentialsTable
+
+	// Registry host to credential helper name
+	CredHelpers map[string]string
+
+	// Credential helper name for all registries not in CredHelpers
+	CredsStore string
+
+	// The configs as they were before [DockerConfig.Merge], in order of
+	// precedence; nil if not merged
+	sources []*DockerConfig
+}
+
+func NewDockerConfig() *DockerConfig {
+	return &DockerConfig{
+		Auths:       make(RegistryCredentialsTable),
+		CredHelpers: make(map[string]string),
+	}
+}
+
+// Supports both config.json and the legacy .dockercfg, in which the registry
+// hosts are at the top level.
+func NewDockerConfigFromJSON(data []byte) (*DockerConfig, error) {
+	value, err := ard.DecodeJSON(data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	map_, ok := ard.With(value).Map()
+	if !ok {
+		return nil, fmt.Errorf("malformed Docker config: %s", value)
+	}
+
+	self := NewDockerConfig()
+
+	_, hasAuths := map_["auths"]
+	_, hasCredHelpers := map_["credHelpers"]
+	_, hasCredsStore := map_["credsStore"]
+	if !hasAuths && !hasCredHelpers && !hasCredsStore {
+		// Legacy .dockercfg
+		if self.Auths, err = NewRegistryCredentialsTableFromARD(map_); err == nil {
+			return self, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	if auths := ard.With(map_).Get("auths").Value; auths != nil {
+		if self.Auths, err = NewRegistryCredentialsTableFromARD(auths); err != nil {
+			return nil, err
+		}
+	}
+
+	if credHelpers, ok := ard.With(map_).Get("credHelpers").Map(); ok {
+		for host, helper := range credHelpers {
+			host_, ok := host.(string)
+			helper_, ok_ := helper.(string)
+			if !ok || !ok_ {
+				return nil, fmt.Errorf("malformed Docker config \"credHelpers\": %s", credHelpers)
+			}
+			self.CredHelpers[host_] = helper_
+		}
+	}
+
+	self.CredsStore, _ = ard.With(map_).Get("credsStore").String()
+
+	return self, nil
+}
+
+// Supports the "kubernetes.io/dockerconfigjson" and "kubernetes.io/dockercfg"
+// secret types. Returns nil for other types.
+func NewDockerConfigFromSecret(secret *core.Secret) (*DockerConfig, error) {
+	var key string
+	switch secret.Type {
+	case core.SecretTypeDockerConfigJson:
+		key = core.DockerConfigJsonKey
+	case core.SecretTypeDockercfg:
+		key = core.DockerConfigKey
+	default:
+		return nil, nil
+	}
+
+	if data, ok := secret.Data[key]; ok {
+		if self, err := NewDockerConfigFromJSON(data); err == nil {
+			return self, nil
+		} else {
+			return nil, fmt.Errorf("malformed %q secret: %w", secret.Type, err)
+		}
+	} else {
+		return nil, fmt.Errorf("malformed %q secret: no %q", secret.Type, key)
+	}
+}
+
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if self, err := NewDockerConfigFromJSON(data); err == nil {
+			return self, nil
+		} else {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Loads and merges all the files in [DefaultDockerConfigPaths] that exist,
+// with earlier files taking precedence.
+func LoadDefaultDockerConfig() (*DockerConfig, error) {
+	self := NewDockerConfig()
+	for _, path := range DefaultDockerConfigPaths() {
+		if dockerConfig, err := LoadDockerConfig(path); err == nil {
+			self.Merge(dockerConfig)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return self, nil
+}
+
+// The paths in the order in which Podman looks for them (Docker only uses
+// config.json):
+//
+//   - $REGISTRY_AUTH_FILE
+//   - $XDG_RUNTIME_DIR/containers/auth.json
+//   - ~/.config/containers/auth.json
+//   - $DOCKER_CONFIG/config.json or ~/.docker/config.json
+//   - ~/.dockercfg
+func DefaultDockerConfigPaths() []string {
+	var paths []string
+
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		paths = append(paths, path)
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		paths = append(paths, filepath.Join(runtimeDir, "containers", "auth.json"))
+	}
+
+	home, _ := os.UserHomeDir()
+
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "containers", "auth.json"))
+	}
+
+	if dockerConfig := os.Getenv("DOCKER_CONFIG"); dockerConfig != "" {
+		paths = append(paths, filepath.Join(dockerConfig, "config.json"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".docker", "config.json"))
+	}
+
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".dockercfg"))
+	}
+
+	return paths
+}
+
+// Adds the entries of the other config that we don't already have, so that
+// our entries take precedence.
+//
+// Because each config has its own precedence rules (a credential helper is
+// preferred over stored credentials), [DockerConfig.GetCredentials] will
+// still resolve each of the merged configs separately and in order. Thus the
+// other config's credential helpers and credentials store will not override
+// our stored credentials for the same registry.
+func (self *DockerConfig) Merge(other *DockerConfig) {
+	if self.sources == nil {
+		self.sources = []*DockerConfig{self.clone()}
+	}
+	if other.sources != nil {
+		self.sources = append(self.sources, other.sources...)
+	} else {
+		self.sources = append(self.sources, other.clone())
+	}
+
+	for server, credentials := range other.Auths {
+		if self.Auths.Get(server) == nil {
+			self.Auths[server] = credentials
+		}
+	}
+
+	for host, helper := range other.CredHelpers {
+		if _, ok := self.CredHelpers[host]; !ok {
+			self.CredHelpers[host] = helper
+		}
+	}
+
+	if self.CredsStore == "" {
+		self.CredsStore = other.CredsStore
+	}
+}
+
+// Returns the credentials for the registry host (which may include a
+// repository path) with the same precedence as Docker: a credential helper
+// for the host, then the credentials store, then the stored credentials. For
+// merged configs this is done for each config in order (see
+// [DockerConfig.Merge]).
+//
+// Returns nil if not found.
+func (self *DockerConfig) GetCredentials(host string) (*RegistryCredentials, error) {
+	if self.sources == nil {
+		return self.getCredentials(host)
+	}
+
+	for _, source := range self.sources {
+		if credentials, err := source.getCredentials(host); err == nil {
+			if credentials != nil {
+				return credentials, nil
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns the credentials for the registry of the image reference, e.g.
+// "nginx:latest" (Docker Hub) or "quay.io/organization/image@sha256:...".
+//
+// Returns nil if not found.
+func (self *DockerConfig) GetCredentialsForImage(image string) (*RegistryCredentials, error) {
+	if reference, err := namepkg.ParseReference(image); err == nil {
+		repository := reference.Context()
+		return self.GetCredentials(repository.RegistryStr() + "/" + repository.RepositoryStr())
+	} else {
+		return nil, err
+	}
+}
+
+func (self *DockerConfig) getCredentials(host string) (*RegistryCredentials, error) {
+	normalizedHost := NormalizeRegistryHost(host)
+	registryHost, _, _ := strings.Cut(normalizedHost, "/")
+
+	for host_, helper := range self.CredHelpers {
+		if NormalizeRegistryHost(host_) == registryHost {
+			return GetRegistryCredentialsFromHelper(helper, registryHost)
+		}
+	}
+
+	if self.CredsStore != "" {
+		if credentials, err := GetRegistryCredentialsFromHelper(self.CredsStore, registryHost); err == nil {
+			if credentials != nil {
+				return credentials, nil
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return self.Auths.Get(normalizedHost), nil
+}
+
+func (self *DockerConfig) clone() *DockerConfig {
+	return &DockerConfig{
+		Auths:       maps.Clone(self.Auths),
+		CredHelpers: maps.Clone(self.CredHelpers),
+		CredsStore:  self.CredsStore,
+	}
+}
+
+// Executes the credential helper's "get" command. Returns nil if it does not
+// have credentials for the host.
+func GetRegistryCredentialsFromHelper(helper string, host string) (*RegistryCredentials, error) {
+	server := host
+	if NormalizeRegistryHost(host) == dockerHubHosts[0] {
+		server = DockerHubServer
+	}
+
+	command := exec.Command(DockerCredentialHelperPrefix+helper, "get")
+	command.Stdin = strings.NewReader(server)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %q: %w: %s", helper, err, output)
+	}
+
+	var response struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("credential helper %q: %w", helper, err)
+	}
+
+	if response.Username == "<token>" {
+		// See: https://github.com/docker/docker-credential-helpers#development
+		return &RegistryCredentials{IdentityToken: response.Secret}, nil
+	} else {
+		return NewRegistryCredentials(response.Username, response.Secret), nil
+	}
+}
@@ -0,0 +1,219 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	labelspkg "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+//
+// DeleteOptions
+//
+
+type DeleteOptions struct {
+	// meta.DeletePropagationForeground, meta.DeletePropagationBackground, or
+	// meta.DeletePropagationOrphan; empty for the resource's default
+	PropagationPolicy meta.DeletionPropagation
+
+	// Preconditions; empty to ignore
+	UID             string
+	ResourceVersion string
+
+	// Nil for the resource's default
+	GracePeriodSeconds *int64
+
+	// Block until the objects are gone, which with foreground propagation also
+	// means that their dependents are gone
+	Wait bool
+
+	// For Wait; zero for no timeout
+	Timeout time.Duration
+}
+
+// Deleting an object that doesn't exist is not an error.
+func (self *Dynamic) DeleteResource(gvk schema.GroupVersionKind, name string, namespace string, options *DeleteOptions) error {
+	gvr, err := FindResourceForKind(self.Discovery, gvk, "delete")
+	if err != nil {
+		return err
+	}
+
+	client := self.Dynamic.Resource(gvr).Namespace(namespace)
+
+	if err := client.Delete(self.context, name, toMetaDeleteOptions(options)); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if (options != nil) && options.Wait {
+		return self.waitForDeletion(client, meta.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}, name, options.Timeout)
+	} else {
+		return nil
+	}
+}
+
+// Deletes all objects matching the labels. Preconditions apply to each object.
+func (self *Dynamic) DeleteCollection(gvk schema.GroupVersionKind, namespace string, labels map[string]string, options *DeleteOptions) error {
+	gvr, err := FindResourceForKind(self.Discovery, gvk, "deletecollection", "list")
+	if err != nil {
+		return err
+	}
+
+	client := self.Dynamic.Resource(gvr).Namespace(namespace)
+	listOptions := meta.ListOptions{LabelSelector: labelspkg.SelectorFromSet(labels).String()}
+
+	if err := client.DeleteCollection(self.context, toMetaDeleteOptions(options), listOptions); err != nil {
+		return err
+	}
+
+	if (options != nil) && options.Wait {
+		return self.waitForDeletion(client, listOptions, "", options.Timeout)
+	} else {
+		return nil
+	}
+}
+
+// Waits until all objects that currently match the list options (and the name,
+// if not empty) are gone. We track objects by UID, so objects that are
+// recreated with the same name will not be waited for.
+func (self *Dynamic) waitForDeletion(client dynamic.ResourceInterface, listOptions meta.ListOptions, name string, timeout time.Duration) error {
+	context := self.context
+	if timeout > 0 {
+		var cancel contextpkg.CancelFunc
+		context, cancel = contextpkg.WithTimeout(context, timeout)
+		defer cancel()
+	}
+
+	var remaining map[types.UID]string
+
+	for {
+		list, err := client.List(context, listOptions)
+		if err != nil {
+			return err
+		}
+
+		if remaining == nil {
+			remaining = make(map[types.UID]string)
+			for _, object := range list.Items {
+				if (name == "") || (object.GetName() == name) {
+					remaining[object.GetUID()] = object.GetName()
+				}
+			}
+		} else {
+			// Objects may have been deleted while we were not watching
+			present := make(map[types.UID]struct{})
+			for _, object := range list.Items {
+				present[object.GetUID()] = struct{}{}
+			}
+			for uid := range remaining {
+				if _, ok := present[uid]; !ok {
+					delete(remaining, uid)
+				}
+			}
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		watchOptions := listOptions
+		watchOptions.ResourceVersion = list.GetResourceVersion()
+		watcher, err := client.Watch(context, watchOptions)
+		if err != nil {
+			return err
+		}
+
+		if done, err := waitForWatchDeletion(context, watcher, remaining); done || (err != nil) {
+			watcher.Stop()
+			if (err != nil) && (context.Err() != nil) {
+				return fmt.Errorf("waiting for deletion of %s: %w", remainingNames(remaining), err)
+			}
+			return err
+		}
+
+		// The watch expired, so list again and start another one
+		watcher.Stop()
+	}
+}
+
+// Returns true if all remaining objects are gone, false if the watch expired.
+func waitForWatchDeletion(context contextpkg.Context, watcher watch.Interface, remaining map[types.UID]string) (bool, error) {
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+
+			switch event.Type {
+			case watch.Deleted:
+				if object, ok := event.Object.(*unstructured.Unstructured); ok {
+					delete(remaining, object.GetUID())
+					if len(remaining) == 0 {
+						return true, nil
+					}
+				}
+
+			case watch.Error:
+				// Note: the dynamic client decodes the status as unstructured
+				err := errors.FromObject(event.Object)
+				if errors.IsResourceExpired(err) || errors.IsGone(err) {
+					// Our resource version is too old
+					return false, nil
+				}
+				return false, err
+			}
+
+		case <-context.Done():
+			return false, context.Err()
+		}
+	}
+}
+
+// Utils
+
+func toMetaDeleteOptions(options *DeleteOptions) meta.DeleteOptions {
+	var deleteOptions meta.DeleteOptions
+
+	if options != nil {
+		if options.PropagationPolicy != "" {
+			propagationPolicy := options.PropagationPolicy
+			deleteOptions.PropagationPolicy = &propagationPolicy
+		}
+
+		if (options.UID != "") || (options.ResourceVersion != "") {
+			deleteOptions.Preconditions = new(meta.Preconditions)
+			if options.UID != "" {
+				uid := types.UID(options.UID)
+				deleteOptions.Preconditions.UID = &uid
+			}
+			if options.ResourceVersion != "" {
+				resourceVersion := options.ResourceVersion
+				deleteOptions.Preconditions.ResourceVersion = &resourceVersion
+			}
+		}
+
+		deleteOptions.GracePeriodSeconds = options.GracePeriodSeconds
+	}
+
+	return deleteOptions
+}
+
+func remainingNames(remaining map[types.UID]string) []string {
+	names := make([]string, 0, len(remaining))
+	for _, name := range remaining {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDynamicDelete(t *testing.T) {
+	labeled := newTestConfigMap("b", nil)
+	labeled.SetLabels(map[string]string{"app": "test"})
+	labeled.SetUID("b")
+	a := newTestConfigMap("a", nil)
+	a.SetUID("a")
+	dynamic := newTestDynamic(a, labeled, newTestConfigMap("c", nil))
+	fake := dynamic.Dynamic.(*dynamicfake.FakeDynamicClient)
+	gvr := testConfigMapGVK.GroupVersion().WithResource("configmaps")
+
+	// Simulate a finalizer by delaying the actual deletion
+	fake.PrependReactor("delete", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		name := action.(clienttesting.DeleteAction).GetName()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			fake.Tracker().Delete(gvr, "default", name)
+		}()
+		return true, nil, nil
+	})
+
+	// The fake dynamic client doesn't support delete collection
+	fake.PrependReactor("delete-collection", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		selector := action.(clienttesting.DeleteCollectionAction).GetListRestrictions().Labels
+		if list, err := fake.Tracker().List(gvr, testConfigMapGVK, "default"); err == nil {
+			for _, object := range list.(*unstructured.UnstructuredList).Items {
+				if selector.Matches(labels.Set(object.GetLabels())) {
+					fake.Tracker().Delete(gvr, "default", object.GetName())
+				}
+			}
+		}
+		return true, nil, nil
+	})
+
+	start := time.Now()
+	if err := dynamic.DeleteResource(testConfigMapGVK, "a", "default", &DeleteOptions{Wait: true, Timeout: 5 * time.Second}); err != nil {
+		t.Fatalf("DeleteResource: %s", err.Error())
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("DeleteResource did not wait")
+	}
+	if _, err := dynamic.GetResource(testConfigMapGVK, "a", "default"); err == nil {
+		t.Error("DeleteResource: not deleted")
+	}
+
+	if err := dynamic.DeleteCollection(testConfigMapGVK, "default", map[string]string{"app": "test"}, &DeleteOptions{Wait: true}); err != nil {
+		t.Fatalf("DeleteCollection: %s", err.Error())
+	}
+	if objects, err := dynamic.ListResources(testConfigMapGVK, "default", nil); (err != nil) || (len(objects) != 1) || (objects[0].GetName() != "c") {
+		t.Errorf("DeleteCollection: %v, %v", objects, err)
+	}
+
+	// Never deleted
+	fake.PrependReactor("delete", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	if err := dynamic.DeleteResource(testConfigMapGVK, "c", "default", &DeleteOptions{Wait: true, Timeout: 100 * time.Millisecond}); err == nil {
+		t.Error("DeleteResource: did not time out")
+	}
+}
+
+func TestWaitForWatchDeletionError(t *testing.T) {
+	tests := []struct {
+		reason  meta.StatusReason
+		code    int64
+		expired bool
+	}{
+		{meta.StatusReasonExpired, 410, true},
+		{meta.StatusReasonGone, 410, true},
+		{meta.StatusReasonForbidden, 403, false},
+	}
+
+	for _, test := range tests {
+		// The dynamic client decodes the status as unstructured
+		watcher := watch.NewFakeWithChanSize(1, false)
+		watcher.Error(&unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Status",
+			"status":     meta.StatusFailure,
+			"reason":     string(test.reason),
+			"code":       test.code,
+		}})
+
+		done, err := waitForWatchDeletion(context.TODO(), watcher, map[types.UID]string{"a": "a"})
+		if done || ((err == nil) != test.expired) {
+			t.Errorf("%s: %t, %v", test.reason, done, err)
+		}
+	}
+}
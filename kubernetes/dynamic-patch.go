@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+//
+// ApplyOptions
+//
+
+type ApplyOptions struct {
+	FieldManager string // defaults to [Dynamic.ToolName]
+	Force        bool   // take ownership of fields managed by other field managers
+	DryRun       bool   // validate on the server without persisting
+}
+
+//
+// JSONPatchOperation
+//
+
+// See RFC 6902.
+type JSONPatchOperation struct {
+	Operation string `json:"op"` // "add", "remove", "replace", "move", "copy", or "test"
+	Path      string `json:"path"`
+	From      string `json:"from,omitempty"` // for "move" and "copy"
+	Value     any    `json:"value"`          // ignored for "remove", "move", and "copy"
+}
+
+// Server-side apply. The object should contain only the fields that we want to
+// manage. Fields that we managed in a previous apply but are missing from the
+// object will be removed.
+func (self *Dynamic) ApplyResource(object *unstructured.Unstructured, options *ApplyOptions) (*unstructured.Unstructured, error) {
+	applyOptions := meta.ApplyOptions{FieldManager: self.ToolName}
+	if options != nil {
+		if options.FieldManager != "" {
+			applyOptions.FieldManager = options.FieldManager
+		}
+		applyOptions.Force = options.Force
+		if options.DryRun {
+			applyOptions.DryRun = []string{meta.DryRunAll}
+		}
+	}
+
+	if gvr, err := FindResourceForUnstructured(self.Discovery, object, "patch"); err == nil {
+		return self.Dynamic.Resource(gvr).Namespace(object.GetNamespace()).Apply(self.context, object.GetName(), object, applyOptions)
+	} else {
+		return nil, err
+	}
+}
+
+// Set subresource to "status" to patch the status.
+func (self *Dynamic) PatchResource(gvk schema.GroupVersionKind, name string, namespace string, patchType types.PatchType, patch []byte, subresource string) (*unstructured.Unstructured, error) {
+	var subresources []string
+	if subresource != "" {
+		subresources = []string{subresource}
+	}
+
+	if gvr, err := FindResourceForKind(self.Discovery, gvk, "patch"); err == nil {
+		return self.Dynamic.Resource(gvr).Namespace(namespace).Patch(self.context, name, patchType, patch, meta.PatchOptions{FieldManager: self.ToolName}, subresources...)
+	} else {
+		return nil, err
+	}
+}
+
+// JSON merge patch (RFC 7386). The patch will be encoded as JSON. Note that a
+// nil value removes the field and that lists are replaced entirely.
+func (self *Dynamic) MergePatchResource(gvk schema.GroupVersionKind, name string, namespace string, patch any) (*unstructured.Unstructured, error) {
+	if patch_, err := json.Marshal(patch); err == nil {
+		return self.PatchResource(gvk, name, namespace, types.MergePatchType, patch_, "")
+	} else {
+		return nil, err
+	}
+}
+
+// Like [Dynamic.MergePatchResource] but lists are merged according to the
+// patch strategy of the type. Supported only for built-in types.
+func (self *Dynamic) StrategicMergePatchResource(gvk schema.GroupVersionKind, name string, namespace string, patch any) (*unstructured.Unstructured, error) {
+	if patch_, err := json.Marshal(patch); err == nil {
+		return self.PatchResource(gvk, name, namespace, types.StrategicMergePatchType, patch_, "")
+	} else {
+		return nil, err
+	}
+}
+
+// JSON patch (RFC 6902). Use a "test" operation on "/metadata/resourceVersion"
+// to make the patch conditional.
+func (self *Dynamic) JSONPatchResource(gvk schema.GroupVersionKind, name string, namespace string, operations ...JSONPatchOperation) (*unstructured.Unstructured, error) {
+	if patch, err := json.Marshal(operations); err == nil {
+		return self.PatchResource(gvk, name, namespace, types.JSONPatchType, patch, "")
+	} else {
+		return nil, err
+	}
+}
+
+// Creates the object if it doesn't exist, otherwise updates it.
+//
+// Mutate is called on the existing object (or on the object if it is being
+// created) to make the changes. If it is nil, the existing object's content is
+// replaced by the object's. On conflict the existing object is fetched again
+// and mutate is called again, so it should be idempotent.
+//
+// Returns true if the object was created.
+func (self *Dynamic) CreateOrUpdateResource(object *unstructured.Unstructured, mutate func(object *unstructured.Unstructured) error) (*unstructured.Unstructured, bool, error) {
+	gvr, err := FindResourceForUnstructured(self.Discovery, object, "get", "create", "update")
+	if err != nil {
+		return nil, false, err
+	}
+
+	client := self.Dynamic.Resource(gvr).Namespace(object.GetNamespace())
+	var result *unstructured.Unstructured
+	var created bool
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := client.Get(self.context, object.GetName(), meta.GetOptions{})
+		if errors.IsNotFound(err) {
+			object_ := object.DeepCopy()
+			if mutate != nil {
+				if err := mutate(object_); err != nil {
+					return err
+				}
+			}
+
+			if result, err = client.Create(self.context, object_, meta.CreateOptions{FieldManager: self.ToolName}); err == nil {
+				created = true
+				return nil
+			} else if errors.IsAlreadyExists(err) {
+				// Someone else created it in the meantime, so try again as an update
+				return errors.NewConflict(gvr.GroupResource(), object.GetName(), err)
+			} else {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if mutate != nil {
+			if err := mutate(existing); err != nil {
+				return err
+			}
+		} else {
+			resourceVersion := existing.GetResourceVersion()
+			existing = object.DeepCopy()
+			existing.SetResourceVersion(resourceVersion)
+		}
+
+		if (existing.GetName() != object.GetName()) || (existing.GetNamespace() != object.GetNamespace()) {
+			return fmt.Errorf("mutate must not change the name or namespace: %s/%s", object.GetNamespace(), object.GetName())
+		}
+
+		result, err = client.Update(self.context, existing, meta.UpdateOptions{FieldManager: self.ToolName})
+		return err
+	})
+
+	return result, created, err
+}
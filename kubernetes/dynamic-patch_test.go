@@ -0,0 +1,131 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDynamicPatch(t *testing.T) {
+	dynamic := newTestDynamic(newTestConfigMap("a", map[string]any{"x": "1", "y": "2"}))
+	gvk := testConfigMapGVK
+
+	if object, err := dynamic.MergePatchResource(gvk, "a", "default", map[string]any{
+		"data": map[string]any{"x": "10", "y": nil},
+	}); err == nil {
+		if data, _, _ := unstructured.NestedStringMap(object.Object, "data"); (len(data) != 1) || (data["x"] != "10") {
+			t.Errorf("MergePatchResource: %v", data)
+		}
+	} else {
+		t.Fatalf("MergePatchResource: %s", err.Error())
+	}
+
+	if object, err := dynamic.JSONPatchResource(gvk, "a", "default",
+		JSONPatchOperation{Operation: "add", Path: "/data/z", Value: "3"},
+		JSONPatchOperation{Operation: "remove", Path: "/data/x"},
+	); err == nil {
+		if data, _, _ := unstructured.NestedStringMap(object.Object, "data"); (len(data) != 1) || (data["z"] != "3") {
+			t.Errorf("JSONPatchResource: %v", data)
+		}
+	} else {
+		t.Fatalf("JSONPatchResource: %s", err.Error())
+	}
+
+	// Create
+	object, created, err := dynamic.CreateOrUpdateResource(newTestConfigMap("b", nil), func(object *unstructured.Unstructured) error {
+		return unstructured.SetNestedField(object.Object, "created", "data", "state")
+	})
+	if (err != nil) || !created {
+		t.Fatalf("CreateOrUpdateResource: %v, %v", created, err)
+	}
+	if state, _, _ := unstructured.NestedString(object.Object, "data", "state"); state != "created" {
+		t.Errorf("CreateOrUpdateResource: %q", state)
+	}
+
+	// Update
+	object, created, err = dynamic.CreateOrUpdateResource(newTestConfigMap("b", nil), func(object *unstructured.Unstructured) error {
+		return unstructured.SetNestedField(object.Object, "updated", "data", "state")
+	})
+	if (err != nil) || created {
+		t.Fatalf("CreateOrUpdateResource: %v, %v", created, err)
+	}
+	if state, _, _ := unstructured.NestedString(object.Object, "data", "state"); state != "updated" {
+		t.Errorf("CreateOrUpdateResource: %q", state)
+	}
+
+	// Replace
+	if _, _, err = dynamic.CreateOrUpdateResource(newTestConfigMap("b", map[string]any{"replaced": "true"}), nil); err != nil {
+		t.Fatalf("CreateOrUpdateResource: %s", err.Error())
+	}
+	if object, err := dynamic.GetResource(gvk, "b", "default"); err == nil {
+		if data, _, _ := unstructured.NestedStringMap(object.Object, "data"); (len(data) != 1) || (data["replaced"] != "true") {
+			t.Errorf("CreateOrUpdateResource: %v", data)
+		}
+	} else {
+		t.Fatalf("GetResource: %s", err.Error())
+	}
+}
+
+func TestDynamicApply(t *testing.T) {
+	dynamic := newTestDynamic()
+
+	// The fake dynamic client doesn't support server-side apply
+	var patchType types.PatchType
+	var patch []byte
+	dynamic.Dynamic.(*dynamicfake.FakeDynamicClient).PrependReactor("patch", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(clienttesting.PatchAction)
+		patchType = patchAction.GetPatchType()
+		patch = patchAction.GetPatch()
+		return true, newTestConfigMap(patchAction.GetName(), nil), nil
+	})
+
+	if _, err := dynamic.ApplyResource(newTestConfigMap("a", map[string]any{"x": "1"}), &ApplyOptions{Force: true}); err != nil {
+		t.Fatalf("ApplyResource: %s", err.Error())
+	}
+	if (patchType != types.ApplyPatchType) || !strings.Contains(string(patch), `"data":{"x":"1"}`) {
+		t.Errorf("ApplyResource: %s %s", patchType, patch)
+	}
+}
+
+func newTestDynamic(objects ...runtime.Object) *Dynamic {
+	discovery := fake.NewClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discovery.Resources = []*meta.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []meta.APIResource{{
+			Name:       "configmaps",
+			Namespaced: true,
+			Kind:       "ConfigMap",
+			Verbs:      meta.Verbs{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"},
+		}},
+	}}
+
+	return NewDynamic("test", dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objects...), discovery, "", context.TODO())
+}
+
+func newTestConfigMap(name string, data map[string]any) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": "default",
+		},
+	}}
+	if data != nil {
+		object.Object["data"] = data
+	}
+	return object
+}
+
+var testConfigMapGVK = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"fmt"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+type UnstructuredConditionFunc = func(object *unstructured.Unstructured) (bool, error)
+
+//
+// ReadyOptions
+//
+
+type ReadyOptions struct {
+	WaitOptions
+
+	// Condition types that must all have status "True"; when set (or when
+	// JSONPath is set) they are used instead of [IsReady]
+	Conditions []string
+
+	// JSONPath expression and the value it must match; see
+	// [NewJSONPathReadyFunc]
+	JSONPath      string
+	JSONPathValue string
+}
+
+// Returns the condition for the options.
+func (self *ReadyOptions) ReadyFunc() (UnstructuredConditionFunc, error) {
+	var conditions []UnstructuredConditionFunc
+
+	if len(self.Conditions) > 0 {
+		conditions = append(conditions, NewConditionsReadyFunc(self.Conditions...))
+	}
+
+	if self.JSONPath != "" {
+		if condition, err := NewJSONPathReadyFunc(self.JSONPath, self.JSONPathValue); err == nil {
+			conditions = append(conditions, condition)
+		} else {
+			return nil, err
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		return IsReady, nil
+
+	case 1:
+		return conditions[0], nil
+
+	default:
+		return func(object *unstructured.Unstructured) (bool, error) {
+			for _, condition := range conditions {
+				if ready, err := condition(object); !ready || (err != nil) {
+					return false, err
+				}
+			}
+			return true, nil
+		}, nil
+	}
+}
+
+// Watches the object until it is ready. The object does not have to exist
+// yet. Nil options means [IsReady] with the timeout in [DefaultWaitOptions].
+func (self *Dynamic) WaitForReady(gvk schema.GroupVersionKind, name string, namespace string, options *ReadyOptions) (*unstructured.Unstructured, error) {
+	if options == nil {
+		options = &ReadyOptions{WaitOptions: DefaultWaitOptions}
+	}
+
+	condition, err := options.ReadyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	gvr, err := FindResourceForKind(self.Discovery, gvk, "get", "list", "watch")
+	if err != nil {
+		return nil, err
+	}
+
+	context := self.context
+	if options.Timeout > 0 {
+		var cancel contextpkg.CancelFunc
+		context, cancel = contextpkg.WithTimeout(context, options.Timeout)
+		defer cancel()
+	}
+
+	self.Log.Infof("waiting for %s %s to be ready", gvk.Kind, joinNamespacedName(namespace, name))
+	if object, err := self.waitForResource(context, gvr, name, namespace, condition); err == nil {
+		self.Log.Infof("%s %s is ready", gvk.Kind, joinNamespacedName(namespace, name))
+		return object, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Watches the object until the condition is true. The object does not have to
+// exist yet. Use a context with a timeout or deadline to limit the wait.
+func (self *Dynamic) waitForResource(context contextpkg.Context, gvr schema.GroupVersionResource, name string, namespace string, condition UnstructuredConditionFunc) (*unstructured.Unstructured, error) {
+	client := self.Dynamic.Resource(gvr).Namespace(namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+
+	listWatch := cache.ListWatch{
+		ListWithContextFunc: func(context contextpkg.Context, options meta.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return client.List(context, options)
+		},
+		WatchFuncWithContext: func(context contextpkg.Context, options meta.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return client.Watch(context, options)
+		},
+	}
+
+	event, err := watchtools.UntilWithSync(context, &listWatch, new(unstructured.Unstructured), nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			if object, ok := event.Object.(*unstructured.Unstructured); ok && (object.GetName() == name) {
+				return condition(object)
+			}
+		}
+		return false, nil
+	})
+
+	if err == nil {
+		return event.Object.(*unstructured.Unstructured), nil
+	} else {
+		return nil, fmt.Errorf("waiting for %s %s: %w", gvr.Resource, joinNamespacedName(namespace, name), err)
+	}
+}
+
+// Utils
+
+func joinNamespacedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	} else {
+		return namespace + "/" + name
+	}
+}
@@ -25,6 +25,7 @@ import (
 type OnChangedFunc = func(object *unstructured.Unstructured) error
 
 type Dynamic struct {
+	ToolName        string
 	Dynamic         dynamicpkg.Interface
 	Discovery       discovery.DiscoveryInterface
 	InformerFactory dynamicinformer.DynamicSharedInformerFactory
@@ -44,6 +45,7 @@ func NewDynamic(toolName string, dynamic dynamicpkg.Interface, discovery discove
 	}
 
 	return &Dynamic{
+		ToolName:        toolName,
 		Dynamic:         dynamic,
 		Discovery:       discovery,
 		InformerFactory: informerFactory,
@@ -0,0 +1,287 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"slices"
+	"strings"
+
+	"github.com/tliron/commonlog"
+	"github.com/tliron/go-kutil/util"
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+//
+// Endpoint
+//
+
+// A backend address and port of a service.
+type Endpoint struct {
+	Address     string
+	Port        int
+	PortName    string
+	Protocol    core.Protocol
+	Zone        string
+	NodeName    string
+	Hostname    string
+	Pod         string // empty if not a pod
+	Ready       bool
+	Serving     bool
+	Terminating bool
+}
+
+// Returns "[address]:port" for IPv6 and "address:port" for IPv4 and FQDN.
+//
+// ([fmt.Stringer] interface)
+func (self *Endpoint) String() string {
+	return util.JoinIPAddressPort(self.Address, self.Port)
+}
+
+//
+// EndpointResolveOptions
+//
+
+type EndpointResolveOptions struct {
+	// The name of the service port; empty for the unnamed port of a service
+	// with a single port
+	PortName string
+
+	// Which address types to include; empty for dual stack, which also
+	// includes FQDN addresses
+	IPStack util.IPStack
+
+	// If any of the endpoints are in this zone then only those are returned;
+	// empty to ignore zones
+	PreferZone string
+
+	// By default only Ready endpoints are returned; with Serving, endpoints
+	// that are still serving while terminating are included
+	Serving bool
+
+	// Include all endpoints regardless of their conditions
+	All bool
+}
+
+// Resolves the endpoints of a service from its EndpointSlices. Each address is
+// returned once per port. The result is sorted by address and port.
+func ResolveEndpointSlices(endpointSlices []*discovery.EndpointSlice, options *EndpointResolveOptions) []Endpoint {
+	if options == nil {
+		options = new(EndpointResolveOptions)
+	}
+
+	var endpoints []Endpoint
+	found := make(map[string]struct{})
+	var inZone bool
+
+	for _, endpointSlice := range endpointSlices {
+		if !isEndpointSliceAddressTypeInStack(endpointSlice.AddressType, options.IPStack) {
+			continue
+		}
+
+		for _, port := range endpointSlice.Ports {
+			if (port.Port == nil) || (getStringValue(port.Name) != options.PortName) {
+				continue
+			}
+
+			for _, endpoint := range endpointSlice.Endpoints {
+				endpoint_ := Endpoint{
+					Port:     int(*port.Port),
+					PortName: options.PortName,
+					Zone:     getStringValue(endpoint.Zone),
+					NodeName: getStringValue(endpoint.NodeName),
+					Hostname: getStringValue(endpoint.Hostname),
+					// Unknown means ready (and serving)
+					Ready:       getBoolValue(endpoint.Conditions.Ready, true),
+					Serving:     getBoolValue(endpoint.Conditions.Serving, true),
+					Terminating: getBoolValue(endpoint.Conditions.Terminating, false),
+				}
+
+				if port.Protocol != nil {
+					endpoint_.Protocol = *port.Protocol
+				} else {
+					endpoint_.Protocol = core.ProtocolTCP
+				}
+
+				if (endpoint.TargetRef != nil) && (endpoint.TargetRef.Kind == "Pod") {
+					endpoint_.Pod = endpoint.TargetRef.Name
+				}
+
+				if !options.All && !endpoint_.Ready && !(options.Serving && endpoint_.Serving) {
+					continue
+				}
+
+				for _, address := range endpoint.Addresses {
+					endpoint_.Address = address
+
+					// The same endpoint may transiently appear in more than one slice
+					key := endpoint_.String() + "/" + string(endpoint_.Protocol)
+					if _, ok := found[key]; ok {
+						continue
+					}
+					found[key] = struct{}{}
+
+					if (options.PreferZone != "") && (endpoint_.Zone == options.PreferZone) {
+						inZone = true
+					}
+
+					endpoints = append(endpoints, endpoint_)
+				}
+			}
+		}
+	}
+
+	if inZone {
+		endpoints = slices.DeleteFunc(endpoints, func(endpoint Endpoint) bool {
+			return endpoint.Zone != options.PreferZone
+		})
+	}
+
+	slices.SortFunc(endpoints, func(a Endpoint, b Endpoint) int {
+		if c := strings.Compare(a.Address, b.Address); c != 0 {
+			return c
+		}
+		return a.Port - b.Port
+	})
+
+	return endpoints
+}
+
+// Lists the EndpointSlices of the service and resolves their endpoints. For
+// continuous resolution use an [EndpointSliceWatcher] instead.
+func GetServiceEndpoints(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, serviceName string, options *EndpointResolveOptions) ([]Endpoint, error) {
+	if list, err := kubernetes.DiscoveryV1().EndpointSlices(namespace).List(context, meta.ListOptions{LabelSelector: getEndpointSliceSelector(serviceName)}); err == nil {
+		endpointSlices := make([]*discovery.EndpointSlice, len(list.Items))
+		for index := range list.Items {
+			endpointSlices[index] = &list.Items[index]
+		}
+		return ResolveEndpointSlices(endpointSlices, options), nil
+	} else {
+		return nil, err
+	}
+}
+
+//
+// EndpointSliceWatcher
+//
+
+type EndpointsChangedFunc func()
+
+// Watches the EndpointSlices of a service via an informer.
+type EndpointSliceWatcher struct {
+	Namespace   string
+	ServiceName string
+
+	endpointsChanged EndpointsChangedFunc
+	log              commonlog.Logger
+	cancel           contextpkg.CancelFunc
+	informer         cache.SharedIndexInformer
+}
+
+// Starts watching the EndpointSlices of the service. The endpointsChanged
+// function (which can be nil) is called whenever any of them changes.
+//
+// Cancelling the context is equivalent to calling [EndpointSliceWatcher.Stop].
+func StartEndpointSliceWatcher(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, serviceName string, endpointsChanged EndpointsChangedFunc, log commonlog.Logger) (*EndpointSliceWatcher, error) {
+	self := EndpointSliceWatcher{
+		Namespace:        namespace,
+		ServiceName:      serviceName,
+		endpointsChanged: endpointsChanged,
+		log:              log,
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(object any) {
+			self.changed()
+		},
+		UpdateFunc: func(old any, new any) {
+			self.changed()
+		},
+		DeleteFunc: func(object any) {
+			self.changed()
+		},
+	}
+
+	var informerContext contextpkg.Context
+	var err error
+	informerContext, self.cancel = contextpkg.WithCancel(context)
+	if self.informer, _, err = startInformer(informerContext, kubernetes, namespace, getEndpointSliceSelector(serviceName), getEndpointSliceInformer, handler); err != nil {
+		self.cancel()
+		return nil, err
+	}
+
+	return &self, nil
+}
+
+// Stops the informer.
+func (self *EndpointSliceWatcher) Stop() {
+	self.cancel()
+}
+
+// Resolves the current endpoints. See [ResolveEndpointSlices].
+func (self *EndpointSliceWatcher) Resolve(options *EndpointResolveOptions) []Endpoint {
+	var endpointSlices []*discovery.EndpointSlice
+	for _, object := range self.informer.GetStore().List() {
+		if endpointSlice, ok := object.(*discovery.EndpointSlice); ok {
+			// The selector is also enforced by the server, but we make sure
+			if endpointSlice.Labels[discovery.LabelServiceName] == self.ServiceName {
+				endpointSlices = append(endpointSlices, endpointSlice)
+			}
+		}
+	}
+	return ResolveEndpointSlices(endpointSlices, options)
+}
+
+func (self *EndpointSliceWatcher) changed() {
+	if self.log != nil {
+		self.log.Debugf("endpoint slices changed for service: %s/%s", self.Namespace, self.ServiceName)
+	}
+
+	// Informer handlers are called sequentially
+	if self.endpointsChanged != nil {
+		self.endpointsChanged()
+	}
+}
+
+// Utils
+
+func getEndpointSliceInformer(informerFactory informers.SharedInformerFactory) cache.SharedIndexInformer {
+	return informerFactory.Discovery().V1().EndpointSlices().Informer()
+}
+
+func getEndpointSliceSelector(serviceName string) string {
+	return labels.Set(map[string]string{
+		discovery.LabelServiceName: serviceName,
+	}).AsSelector().String()
+}
+
+func isEndpointSliceAddressTypeInStack(addressType discovery.AddressType, ipStack util.IPStack) bool {
+	switch ipStack {
+	case util.IPv4Stack:
+		return addressType == discovery.AddressTypeIPv4
+	case util.IPv6Stack:
+		return addressType == discovery.AddressTypeIPv6
+	default:
+		return true
+	}
+}
+
+func getStringValue(value *string) string {
+	if value != nil {
+		return *value
+	} else {
+		return ""
+	}
+}
+
+func getBoolValue(value *bool, default_ bool) bool {
+	if value != nil {
+		return *value
+	} else {
+		return default_
+	}
+}
@@ -0,0 +1,140 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tliron/go-kutil/util"
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveEndpointSlices(t *testing.T) {
+	ipv4 := newTestEndpointSlice("web-ipv4", discovery.AddressTypeIPv4,
+		newTestEndpoint("10.0.0.1", "a", true, false),
+		newTestEndpoint("10.0.0.2", "b", false, true),
+		newTestEndpoint("10.0.0.3", "b", true, false),
+	)
+	ipv6 := newTestEndpointSlice("web-ipv6", discovery.AddressTypeIPv6,
+		newTestEndpoint("fd00::1", "a", true, false),
+	)
+	// Transient duplicate
+	duplicate := newTestEndpointSlice("web-duplicate", discovery.AddressTypeIPv4,
+		newTestEndpoint("10.0.0.1", "a", true, false),
+	)
+	endpointSlices := []*discovery.EndpointSlice{ipv4, ipv6, duplicate}
+
+	expectEndpoints(t, "ready", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http"}),
+		"10.0.0.1:80", "10.0.0.3:80", "[fd00::1]:80")
+	expectEndpoints(t, "port name", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "metrics", IPStack: util.IPv4Stack}),
+		"10.0.0.1:9090", "10.0.0.3:9090")
+	expectEndpoints(t, "ipv6", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http", IPStack: util.IPv6Stack}),
+		"[fd00::1]:80")
+	expectEndpoints(t, "serving", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http", IPStack: util.IPv4Stack, Serving: true}),
+		"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80")
+	expectEndpoints(t, "zone", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http", PreferZone: "b"}),
+		"10.0.0.3:80")
+	expectEndpoints(t, "missing zone", ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http", IPStack: util.IPv4Stack, PreferZone: "c"}),
+		"10.0.0.1:80", "10.0.0.3:80")
+	expectEndpoints(t, "unnamed port", ResolveEndpointSlices(endpointSlices, nil))
+
+	endpoints := ResolveEndpointSlices(endpointSlices, &EndpointResolveOptions{PortName: "http", IPStack: util.IPv4Stack, All: true})
+	expectEndpoints(t, "all", endpoints, "10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80")
+	if endpoint := endpoints[1]; endpoint.Ready || !endpoint.Serving || !endpoint.Terminating || (endpoint.Pod != "10.0.0.2") || (endpoint.Zone != "b") || (endpoint.Protocol != core.ProtocolTCP) {
+		t.Errorf("all: %+v", endpoint)
+	}
+}
+
+func TestEndpointSliceWatcher(t *testing.T) {
+	other := newTestEndpointSlice("other", discovery.AddressTypeIPv4, newTestEndpoint("10.0.1.1", "a", true, false))
+	other.Labels[discovery.LabelServiceName] = "other"
+	kubernetes := fake.NewClientset(
+		newTestEndpointSlice("web", discovery.AddressTypeIPv4, newTestEndpoint("10.0.0.1", "a", true, false)),
+		other,
+	)
+
+	if endpoints, err := GetServiceEndpoints(context.TODO(), kubernetes, "default", "web", &EndpointResolveOptions{PortName: "http"}); err == nil {
+		expectEndpoints(t, "GetServiceEndpoints", endpoints, "10.0.0.1:80")
+	} else {
+		t.Fatalf("GetServiceEndpoints: %s", err.Error())
+	}
+
+	changed := make(chan struct{}, 10)
+	watcher, err := StartEndpointSliceWatcher(context.TODO(), kubernetes, "default", "web", func() {
+		changed <- struct{}{}
+	}, nil)
+	if err != nil {
+		t.Fatalf("StartEndpointSliceWatcher: %s", err.Error())
+	}
+	defer watcher.Stop()
+
+	expectEndpoints(t, "Resolve", watcher.Resolve(&EndpointResolveOptions{PortName: "http"}), "10.0.0.1:80")
+
+	for len(changed) > 0 {
+		<-changed
+	}
+
+	kubernetes.DiscoveryV1().EndpointSlices("default").Update(context.TODO(), newTestEndpointSlice("web", discovery.AddressTypeIPv4,
+		newTestEndpoint("10.0.0.1", "a", true, false),
+		newTestEndpoint("10.0.0.2", "a", true, false),
+	), meta.UpdateOptions{})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change")
+	}
+
+	expectEndpoints(t, "Resolve", watcher.Resolve(&EndpointResolveOptions{PortName: "http"}), "10.0.0.1:80", "10.0.0.2:80")
+}
+
+func expectEndpoints(t *testing.T, name string, endpoints []Endpoint, expected ...string) {
+	t.Helper()
+
+	var addresses []string
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.String())
+	}
+
+	if !equalStrings(addresses, expected) {
+		t.Errorf("%s: %v, expected %v", name, addresses, expected)
+	}
+}
+
+func newTestEndpointSlice(name string, addressType discovery.AddressType, endpoints ...discovery.Endpoint) *discovery.EndpointSlice {
+	http := "http"
+	httpPort := int32(80)
+	metrics := "metrics"
+	metricsPort := int32(9090)
+
+	return &discovery.EndpointSlice{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discovery.LabelServiceName: "web"},
+		},
+		AddressType: addressType,
+		Endpoints:   endpoints,
+		Ports: []discovery.EndpointPort{
+			{Name: &http, Port: &httpPort},
+			{Name: &metrics, Port: &metricsPort},
+		},
+	}
+}
+
+func newTestEndpoint(address string, zone string, ready bool, terminating bool) discovery.Endpoint {
+	serving := ready || terminating
+	return discovery.Endpoint{
+		Addresses: []string{address},
+		Zone:      &zone,
+		TargetRef: &core.ObjectReference{Kind: "Pod", Name: address},
+		Conditions: discovery.EndpointConditions{
+			Ready:       &ready,
+			Serving:     &serving,
+			Terminating: &terminating,
+		},
+	}
+}
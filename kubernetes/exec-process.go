@@ -0,0 +1,132 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tliron/go-kutil/exec"
+	core "k8s.io/api/core/v1"
+	restpkg "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Starts the command in a container and returns it as an [exec.Process].
+//
+// Supported are the command's Name, Args, PseudoTerminal, and Timeout, as well
+// as the settings used by [exec.NewProcessDriver]. With a PseudoTerminal stderr
+// is always merged into stdout. Dir and Environment are not supported. Instead
+// of the command's termination sequence, cancelling the context (or the timeout)
+// closes the stream.
+func StartExecProcess(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, command *exec.Command) (*exec.Process, error) {
+	if (command.Dir != "") || (len(command.Environment) > 0) {
+		return nil, errors.New("Dir and Environment are not supported for container commands")
+	}
+
+	tty := command.PseudoTerminal != nil
+
+	execOptions := core.PodExecOptions{
+		Container: containerName,
+		Command:   append([]string{command.Name}, command.Args...),
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    !tty,
+		TTY:       tty,
+	}
+
+	if executor, err := NewExecExecutor(rest, config, namespace, podName, &execOptions); err == nil {
+		return StartExecutorProcess(context, executor, command)
+	} else {
+		return nil, err
+	}
+}
+
+// Streams the executor via an [exec.Process]. The executor must have been
+// created for stdin, stdout, and (unless the command has a PseudoTerminal)
+// stderr. See [StartExecProcess].
+func StartExecutorProcess(context contextpkg.Context, executor remotecommand.Executor, command *exec.Command) (*exec.Process, error) {
+	driver, err := exec.NewProcessDriver(context, command)
+	if err != nil {
+		return nil, err
+	}
+
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:  driver.StdinReader(),
+		Stdout: driver.Stdout,
+	}
+
+	if command.PseudoTerminal != nil {
+		streamOptions.Tty = true
+		streamOptions.TerminalSizeQueue = newTerminalSizeQueue(driver, *command.PseudoTerminal)
+	} else {
+		streamOptions.Stderr = driver.Stderr
+	}
+
+	go func() {
+		// Closing the stream is our termination
+		<-driver.Context.Done()
+		driver.Terminated()
+	}()
+
+	go func() {
+		startTime := time.Now()
+		err := executor.StreamWithContext(driver.Context, streamOptions)
+		exitStatus := exec.ExitStatus{Duration: time.Since(startTime)}
+
+		if err != nil {
+			var exitError utilexec.ExitError
+			if errors.As(err, &exitError) {
+				exitStatus.Code = exitError.ExitStatus()
+			} else if termination := driver.Termination(); termination != nil {
+				exitStatus.Code = -1
+				exitStatus.Termination = termination
+			} else {
+				driver.Exit(nil, fmt.Errorf("container command: %w", err))
+				return
+			}
+		}
+
+		driver.Exit(&exitStatus, nil)
+	}()
+
+	return driver.Process, nil
+}
+
+//
+// terminalSizeQueue
+//
+
+type terminalSizeQueue struct {
+	driver  *exec.ProcessDriver
+	initial *exec.Size
+}
+
+func newTerminalSizeQueue(driver *exec.ProcessDriver, initial exec.Size) *terminalSizeQueue {
+	return &terminalSizeQueue{
+		driver:  driver,
+		initial: &initial,
+	}
+}
+
+// ([remotecommand.TerminalSizeQueue] interface)
+func (self *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	if self.initial != nil {
+		size := self.initial
+		self.initial = nil
+		return &remotecommand.TerminalSize{Width: uint16(size.Width), Height: uint16(size.Height)}
+	}
+
+	select {
+	case size, ok := <-self.driver.Resize():
+		if ok {
+			return &remotecommand.TerminalSize{Width: uint16(size.Width), Height: uint16(size.Height)}
+		} else {
+			return nil
+		}
+
+	case <-self.driver.Context.Done():
+		return nil
+	}
+}
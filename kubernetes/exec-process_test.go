@@ -0,0 +1,97 @@
+package kubernetes
+
+import (
+	"bufio"
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tliron/go-kutil/exec"
+	core "k8s.io/api/core/v1"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+	restpkg "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestNewExecExecutor(t *testing.T) {
+	config := restpkg.Config{Host: "http://127.0.0.1:1"}
+	kubernetes, err := kubernetespkg.NewForConfig(&config)
+	if err != nil {
+		t.Fatalf("NewForConfig: %s", err.Error())
+	}
+
+	executor, err := NewExecExecutor(kubernetes.CoreV1().RESTClient(), &config, "default", "pod", &core.PodExecOptions{Command: []string{"true"}, Stdout: true})
+	if err != nil {
+		t.Fatalf("NewExecExecutor: %s", err.Error())
+	}
+	if _, ok := executor.(*remotecommand.FallbackExecutor); !ok {
+		t.Errorf("executor: %T", executor)
+	}
+}
+
+func TestStartExecutorProcess(t *testing.T) {
+	command := exec.NewCommand()
+	command.Name = "sh"
+	command.PseudoTerminal = &exec.Size{Width: 80, Height: 24}
+
+	process, err := StartExecutorProcess(contextpkg.TODO(), new(fakeExecutor), command)
+	if err != nil {
+		t.Errorf("StartExecutorProcess: %s", err.Error())
+		return
+	}
+	defer process.Close()
+
+	process.Stdin([]byte("hello\n"))
+	process.Resize(100, 50)
+
+	var stdout string
+	for p := range process.Stdout {
+		stdout += string(p)
+	}
+
+	if stdout != "80x24\nhello\n100x50\n" {
+		t.Errorf("stdout: %q", stdout)
+	}
+
+	if exitStatus, err := process.Wait(); err == nil {
+		if exitStatus.Code != 3 {
+			t.Errorf("exit code: %d", exitStatus.Code)
+		}
+	} else {
+		t.Errorf("process.Wait: %s", err.Error())
+	}
+}
+
+//
+// fakeExecutor
+//
+
+type fakeExecutor struct{}
+
+// ([remotecommand.Executor] interface)
+func (self *fakeExecutor) Stream(options remotecommand.StreamOptions) error {
+	return self.StreamWithContext(contextpkg.TODO(), options)
+}
+
+// ([remotecommand.Executor] interface)
+func (self *fakeExecutor) StreamWithContext(context contextpkg.Context, options remotecommand.StreamOptions) error {
+	if !options.Tty || (options.TerminalSizeQueue == nil) {
+		return errors.New("expected a TTY")
+	}
+
+	size := options.TerminalSizeQueue.Next()
+	fmt.Fprintf(options.Stdout, "%dx%d\n", size.Width, size.Height)
+
+	if line, err := bufio.NewReader(options.Stdin).ReadString('\n'); err == nil {
+		fmt.Fprint(options.Stdout, line)
+	} else {
+		return err
+	}
+
+	size = options.TerminalSizeQueue.Next()
+	fmt.Fprintf(options.Stdout, "%dx%d\n", size.Width, size.Height)
+
+	return utilexec.CodeExitError{Err: errors.New("command terminated with non-zero exit code"), Code: 3}
+}
@@ -0,0 +1,364 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	contextpkg "context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/tliron/go-kutil/streampackage"
+	"github.com/tliron/go-kutil/util"
+	restpkg "k8s.io/client-go/rest"
+)
+
+// Uploads a local file or directory (recursively) to the container by
+// streaming a tarball to "tar" running in the container (like "kubectl cp").
+// For a directory the target path is the directory into which its contents
+// will be copied. Missing directories will be created. Preserves modes and
+// modification times. Files will be owned by the container's user. Symbolic
+// links are copied as is.
+//
+// Options can be nil.
+func UploadToContainer(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, sourcePath string, targetPath string, options *util.FileTransferOptions) error {
+	return uploadToContainer(newContainerExec(context, rest, config, namespace, podName, containerName), sourcePath, targetPath, options)
+}
+
+// Downloads a file or directory (recursively) from the container by streaming
+// a tarball from "tar" running in the container (like "kubectl cp"). For a
+// directory the target path is the directory into which its contents will be
+// copied. Missing directories will be created. Preserves modes and modification
+// times. Symbolic links are copied as is, but extraction is guarded against path
+// traversal (see [util.ExtractTarEntries]).
+//
+// Progress will be reported with an unknown total.
+//
+// Options can be nil.
+func DownloadFromContainer(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, sourcePath string, targetPath string, options *util.FileTransferOptions) error {
+	return downloadFromContainer(newContainerExec(context, rest, config, namespace, podName, containerName), sourcePath, targetPath, options)
+}
+
+// Streams a tarball of the file or directory in the container. The tarball's
+// entries are named by the path's base name, e.g. for "/opt/app" they would be
+// "app", "app/bin", etc.
+//
+// Errors from the "tar" command are returned by reading from the tarball.
+// Closing the tarball stops the command.
+func OpenContainerTarball(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, path string) *util.TarballReader {
+	dir, root := splitContainerTarPath(path, true)
+	reader := newContainerTarReader(newContainerExec(context, rest, config, namespace, podName, containerName), dir, root)
+	return util.NewTarballReader(tar.NewReader(reader), reader, nil)
+}
+
+// Provides the regular files under the path in the container as streams. Their
+// paths are as in [OpenContainerTarball].
+func NewContainerStreamPackage(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, path string) *streampackage.TarStreamPackage {
+	dir, root := splitContainerTarPath(path, true)
+	reader := newContainerTarReader(newContainerExec(context, rest, config, namespace, podName, containerName), dir, root)
+	return streampackage.NewTarStreamPackageFromReader(reader)
+}
+
+// Uploads the streams to the container as regular files under the target path,
+// with mode 0755 for executables and 0644 otherwise. The stream paths must be
+// relative and may not lead outside of the target path. The stream package is
+// not closed.
+//
+// Because tar entries require a size in advance, each stream is first spooled
+// into a temporary file.
+//
+// Options can be nil. Progress will be reported with an unknown total.
+func UploadStreamPackageToContainer(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, streamPackage streampackage.StreamPackage, targetPath string, options *util.FileTransferOptions) error {
+	return uploadStreamPackageToContainer(context, newContainerExec(context, rest, config, namespace, podName, containerName), streamPackage, targetPath, options)
+}
+
+// Runs a command in a container (or elsewhere, for testing)
+type containerExec func(stdin io.Reader, stdout io.Writer, command ...string) error
+
+func newContainerExec(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string) containerExec {
+	return func(stdin io.Reader, stdout io.Writer, command ...string) error {
+		return Exec(context, rest, config, namespace, podName, containerName, stdin, stdout, nil, false, command...)
+	}
+}
+
+func uploadToContainer(exec containerExec, sourcePath string, targetPath string, options *util.FileTransferOptions) error {
+	total, err := util.GetFileTransferSize(sourcePath)
+	if err != nil {
+		return err
+	}
+	tracker := util.NewFileTransferTracker(options, total)
+
+	// Entries are named by the full target path so that tar would create missing directories
+	dir, root := splitContainerTarPath(targetPath, false)
+
+	if err := execWithTarEntries(exec, func(tarWriter *tar.Writer) error {
+		return util.WriteTarEntries(tarWriter, sourcePath, root, tracker)
+	}, "tar", "-x", "-f", "-", "-C", dir); err != nil {
+		return err
+	}
+
+	if (options != nil) && options.Verify {
+		if sourceChecksums, err := util.GetFileChecksums(sourcePath); err == nil {
+			if targetChecksums, err := getContainerChecksums(exec, targetPath); err == nil {
+				return sourceChecksums.Verify(targetChecksums)
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func downloadFromContainer(exec containerExec, sourcePath string, targetPath string, options *util.FileTransferOptions) error {
+	tracker := util.NewFileTransferTracker(options, -1)
+
+	if err := streamFromContainer(exec, sourcePath, func(tarReader *tar.Reader, root string) error {
+		return util.ExtractTarEntries(tarReader, root, targetPath, tracker)
+	}); err != nil {
+		return err
+	}
+
+	if (options != nil) && options.Verify {
+		if sourceChecksums, err := getContainerChecksums(exec, sourcePath); err == nil {
+			if targetChecksums, err := util.GetFileChecksums(targetPath); err == nil {
+				return sourceChecksums.Verify(targetChecksums)
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getContainerChecksums(exec containerExec, path string) (util.FileChecksums, error) {
+	var checksums util.FileChecksums
+	err := streamFromContainer(exec, path, func(tarReader *tar.Reader, root string) error {
+		var err error
+		checksums, err = util.GetTarChecksums(tarReader, root)
+		return err
+	})
+	return checksums, err
+}
+
+//
+// containerTarReader
+//
+
+type containerTarReader struct {
+	pipeReader *io.PipeReader
+	done       chan struct{}
+}
+
+// Runs "tar" to archive the root relative to the dir.
+func newContainerTarReader(exec containerExec, dir string, root string) *containerTarReader {
+	pipeReader, pipeWriter := io.Pipe()
+	self := containerTarReader{
+		pipeReader: pipeReader,
+		done:       make(chan struct{}),
+	}
+
+	go func() {
+		// Readers will get the error after the end of the output
+		pipeWriter.CloseWithError(exec(nil, pipeWriter, "tar", "-c", "-f", "-", "-C", dir, root))
+		close(self.done)
+	}()
+
+	return &self
+}
+
+// ([io.Reader] interface)
+func (self *containerTarReader) Read(p []byte) (int, error) {
+	return self.pipeReader.Read(p)
+}
+
+// ([io.Closer] interface)
+func (self *containerTarReader) Close() error {
+	// Will cause the command's writes to fail if it's still running
+	self.pipeReader.Close()
+	<-self.done
+	return nil
+}
+
+// Calls the function with the tar stream for the path.
+func streamFromContainer(exec containerExec, path string, f func(tarReader *tar.Reader, root string) error) error {
+	dir, root := splitContainerTarPath(path, true)
+	reader := newContainerTarReader(exec, dir, root)
+	defer reader.Close()
+
+	if err := f(tar.NewReader(reader), root); err != nil {
+		return err
+	}
+
+	// The command's error (if any) will be returned by the reader after the end of the tarball
+	_, err := io.Copy(io.Discard, reader)
+	return err
+}
+
+// For extracting (base is false) we name entries by the full path relative to
+// the root ("/") or working directory ("."). For archiving (base is true) we
+// change into the parent directory and archive just the base name.
+func splitContainerTarPath(path_ string, base bool) (string, string) {
+	path_ = path.Clean(path_)
+
+	if base {
+		return path.Dir(path_), path.Base(path_)
+	} else if path.IsAbs(path_) {
+		return "/", path_[1:]
+	} else {
+		return ".", path_
+	}
+}
+
+func uploadStreamPackageToContainer(context contextpkg.Context, exec containerExec, streamPackage streampackage.StreamPackage, targetPath string, options *util.FileTransferOptions) error {
+	spool, err := os.CreateTemp("", "kutil-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	tracker := util.NewFileTransferTracker(options, -1)
+	dir, root := splitContainerTarPath(targetPath, false)
+
+	return execWithTarEntries(exec, func(tarWriter *tar.Writer) error {
+		return writeStreamPackageTarEntries(context, tarWriter, streamPackage, root, spool, tracker)
+	}, "tar", "-x", "-f", "-", "-C", dir)
+}
+
+// Runs the command with the tar entries written by writeEntries as its stdin.
+//
+// The exec does not report errors writing its stdin, and tar would succeed if
+// the archive is cut off at an entry boundary, so we must report the error of
+// writeEntries ourselves.
+func execWithTarEntries(exec containerExec, writeEntries func(tarWriter *tar.Writer) error, command ...string) error {
+	pipeReader, pipeWriter := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		tarWriter := tar.NewWriter(pipeWriter)
+		err := writeEntries(tarWriter)
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+		written <- err
+	}()
+
+	err := exec(pipeReader, nil, command...)
+
+	// Unblocks the writer if the command did not read all of its stdin
+	if err != nil {
+		pipeReader.CloseWithError(err)
+	} else {
+		pipeReader.Close()
+	}
+
+	if writeErr := <-written; (writeErr != nil) && (err == nil) {
+		return writeErr
+	}
+	return err
+}
+
+func writeStreamPackageTarEntries(context contextpkg.Context, tarWriter *tar.Writer, streamPackage streampackage.StreamPackage, root string, spool *os.File, tracker *util.FileTransferTracker) error {
+	for {
+		stream, err := streamPackage.Next()
+		if err != nil {
+			return err
+		} else if stream == nil {
+			return nil
+		}
+
+		reader, path_, executable, err := stream.Open(context)
+		if err != nil {
+			return err
+		}
+
+		size, err := spoolStream(spool, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+
+		path_ = path.Clean(util.FixTarballEntryPath(path_))
+		if !filepath.IsLocal(filepath.FromSlash(path_)) {
+			return fmt.Errorf("stream path is outside of the target: %q", path_)
+		}
+
+		header := tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(root, path_),
+			Size:     size,
+			Mode:     0644,
+			ModTime:  time.Now(),
+		}
+		if executable {
+			header.Mode = 0755
+		}
+
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(tracker.Writer(path_, tarWriter), spool); err != nil {
+			return err
+		}
+	}
+}
+
+// Leaves the spool file positioned at its start.
+func spoolStream(spool *os.File, reader io.Reader) (int64, error) {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := spool.Truncate(0); err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = spool.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// Spools the reader into a temporary file so that it could be sent as a tar entry.
+func uploadReaderToContainer(exec containerExec, reader io.Reader, targetPath string, permissions *int64) error {
+	file, err := os.CreateTemp("", "kutil-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if permissions != nil {
+		mode = os.FileMode(*permissions)
+	}
+	if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+
+	return uploadToContainer(exec, file.Name(), targetPath, nil)
+}
+
+// Writes the content of the single file in the tar stream.
+// A single file doesn't need the overhead of tar.
+func readFileFromContainer(exec containerExec, writer io.Writer, sourcePath string) error {
+	return exec(nil, writer, "cat", sourcePath)
+}
@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tliron/go-kutil/streampackage"
+	"github.com/tliron/go-kutil/util"
+)
+
+func TestContainerFileTransfer(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+
+	exec_ := execTestLocally
+	root := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	source := filepath.Join(root, "source")
+	writeTestFile(t, filepath.Join(source, "hello.txt"), "hello", 0600, mtime)
+	writeTestFile(t, filepath.Join(source, "bin", "run.sh"), "#!/bin/sh\n", 0755, mtime)
+	if err := os.Symlink("bin/run.sh", filepath.Join(source, "run")); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress util.FileTransferProgress
+	options := util.FileTransferOptions{
+		Progress: func(progress_ util.FileTransferProgress) {
+			progress = progress_
+		},
+		Verify: true,
+	}
+
+	// Upload into a directory that doesn't exist yet
+	container := filepath.Join(root, "container", "deep", "target")
+	if err := uploadToContainer(exec_, source, container, &options); err != nil {
+		t.Fatalf("uploadToContainer: %s", err.Error())
+	}
+	if (progress.Transferred != 15) || (progress.Total != 15) {
+		t.Errorf("progress: %+v", progress)
+	}
+	assertTestFile(t, filepath.Join(container, "bin", "run.sh"), "#!/bin/sh\n", 0755, mtime)
+
+	download := filepath.Join(root, "download")
+	if err := downloadFromContainer(exec_, container, download, &options); err != nil {
+		t.Fatalf("downloadFromContainer: %s", err.Error())
+	}
+	assertTestFile(t, filepath.Join(download, "hello.txt"), "hello", 0600, mtime)
+	assertTestFile(t, filepath.Join(download, "bin", "run.sh"), "#!/bin/sh\n", 0755, mtime)
+	if link, err := os.Readlink(filepath.Join(download, "run")); (err != nil) || (link != "bin/run.sh") {
+		t.Errorf("symbolic link: %q, %v", link, err)
+	}
+
+	// Stream packages
+	dir, root_ := splitContainerTarPath(container, true)
+	streamPackage := streampackage.NewTarStreamPackageFromReader(newContainerTarReader(exec_, dir, root_))
+	streamed := filepath.Join(root, "container", "streamed")
+	if err := uploadStreamPackageToContainer(context.TODO(), exec_, streamPackage, streamed, nil); err != nil {
+		t.Fatalf("uploadStreamPackageToContainer: %s", err.Error())
+	}
+	streamPackage.Close()
+	if content, err := os.ReadFile(filepath.Join(streamed, "target", "bin", "run.sh")); (err != nil) || (string(content) != "#!/bin/sh\n") {
+		t.Errorf("stream package: %q, %v", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(streamed, "target", "bin", "run.sh")); (err != nil) || (info.Mode().Perm() != 0755) {
+		t.Errorf("stream package mode: %v, %v", info, err)
+	}
+
+	// Single files
+	permissions := int64(0640)
+	single := filepath.Join(root, "container", "single.txt")
+	if err := uploadReaderToContainer(exec_, strings.NewReader("single"), single, &permissions); err != nil {
+		t.Fatalf("uploadReaderToContainer: %s", err.Error())
+	}
+
+	var buffer bytes.Buffer
+	if err := readFileFromContainer(exec_, &buffer, single); err != nil {
+		t.Fatalf("readFileFromContainer: %s", err.Error())
+	}
+	if buffer.String() != "single" {
+		t.Errorf("readFileFromContainer: %q", buffer.String())
+	}
+	if info, err := os.Stat(single); (err != nil) || (info.Mode().Perm() != 0640) {
+		t.Errorf("uploadReaderToContainer mode: %v", info.Mode())
+	}
+}
+
+func TestContainerFileTransferSourceError(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+
+	// Like client-go's remotecommand, errors reading stdin are not reported
+	exec_ := func(stdin io.Reader, stdout io.Writer, command ...string) error {
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			io.Copy(pipeWriter, stdin)
+			pipeWriter.Close()
+		}()
+		return execTestLocally(pipeReader, stdout, command...)
+	}
+
+	// The failures are right after directory entries (which have no content),
+	// so that the archive is cut off at an entry boundary, which tar accepts
+	root := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if os.Geteuid() != 0 {
+		source := filepath.Join(root, "unreadable")
+		writeTestFile(t, filepath.Join(source, "dir", "file.txt"), "", 0, mtime)
+		if err := uploadToContainer(exec_, source, filepath.Join(root, "container", "unreadable"), nil); err == nil {
+			t.Error("unreadable file: no error")
+		}
+	}
+
+	// Even root cannot archive a socket
+	source := filepath.Join(root, "unsupported")
+	if err := os.MkdirAll(filepath.Join(source, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(source, "dir", "socket"))
+	if err != nil {
+		t.Skipf("net.Listen: %s", err.Error())
+	}
+	defer listener.Close()
+	if err := uploadToContainer(exec_, source, filepath.Join(root, "container", "unsupported"), nil); err == nil {
+		t.Error("unsupported file type: no error")
+	}
+
+	// The empty file has no content
+	empty := filepath.Join(root, "empty.txt")
+	writeTestFile(t, empty, "", 0600, mtime)
+	streamPackage := streampackage.NewStaticStreamPackage(
+		streampackage.NewFileStream(empty, "empty.txt"),
+		streampackage.NewFileStream(filepath.Join(root, "missing.txt"), "missing.txt"),
+	)
+	if err := uploadStreamPackageToContainer(context.TODO(), exec_, streamPackage, filepath.Join(root, "container", "streamed"), nil); err == nil {
+		t.Error("stream package: no error")
+	}
+}
+
+// Runs tar locally instead of in a container.
+//
+// ([containerExec] signature)
+func execTestLocally(stdin io.Reader, stdout io.Writer, command ...string) error {
+	command_ := exec.Command(command[0], command[1:]...)
+	command_.Stdin = stdin
+	command_.Stdout = stdout
+	var stderr strings.Builder
+	command_.Stderr = &stderr
+	if err := command_.Run(); err == nil {
+		return nil
+	} else {
+		return NewExecError(err, stderr.String())
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string, mode os.FileMode, mtime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertTestFile(t *testing.T, path string, content string, mode os.FileMode, mtime time.Time) {
+	if content_, err := os.ReadFile(path); err == nil {
+		if string(content_) != content {
+			t.Errorf("content of %s: %q", path, content_)
+		}
+	} else {
+		t.Errorf("%s", err.Error())
+		return
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode().Perm() != mode {
+			t.Errorf("mode of %s: %s", path, info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("mtime of %s: %s", path, info.ModTime())
+		}
+	} else {
+		t.Errorf("%s", err.Error())
+	}
+}
@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"fmt"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	discoverypkg "k8s.io/client-go/discovery"
@@ -58,3 +59,26 @@ func FindResourcesForKind(discovery discoverypkg.DiscoveryInterface, gvk schema.
 		return nil, err
 	}
 }
+
+// Whether resources of the kind are namespaced. Returns an error if the kind
+// is not found.
+func IsNamespacedKind(discovery discoverypkg.DiscoveryInterface, gvk schema.GroupVersionKind) (bool, error) {
+	groupVersion := gvk.GroupVersion().String()
+
+	if _, resourceLists, err := discovery.ServerGroupsAndResources(); err == nil {
+		for _, resourceList := range resourceLists {
+			if resourceList.GroupVersion == groupVersion {
+				for _, resource := range resourceList.APIResources {
+					// Subresources (e.g. "deployments/scale") may have the same kind
+					if (resource.Kind == gvk.Kind) && !strings.Contains(resource.Name, "/") {
+						return resource.Namespaced, nil
+					}
+				}
+			}
+		}
+
+		return false, fmt.Errorf("resource not found for: %s", gvk.String())
+	} else {
+		return false, err
+	}
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Starts an informer for the namespace filtered by the label selector. When
+// the handler is not nil it is added before starting and this function
+// returns after it has seen the initial list, otherwise it returns after the
+// informer's store has synced.
+//
+// The informer is shut down when the context is done or when the returned
+// stop function is called. The stop function blocks until the shutdown is
+// complete, so it must not be called from the handler.
+func startInformer(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, selector string, getInformer func(informers.SharedInformerFactory) cache.SharedIndexInformer, handler cache.ResourceEventHandler) (cache.SharedIndexInformer, func(), error) {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(kubernetes, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = selector
+		}))
+	informer := getInformer(informerFactory)
+
+	hasSynced := informer.HasSynced
+	if handler != nil {
+		if registration, err := informer.AddEventHandler(handler); err == nil {
+			// The registration has synced once our handler has seen the initial list
+			hasSynced = registration.HasSynced
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	informerContext, cancel := contextpkg.WithCancel(context)
+	informerFactory.Start(informerContext.Done())
+	if !cache.WaitForCacheSync(informerContext.Done(), hasSynced) {
+		cancel()
+		informerFactory.Shutdown()
+		return nil, nil, fmt.Errorf("informer for namespace %q and selector %q did not sync", namespace, selector)
+	}
+
+	shutdown := make(chan struct{})
+	go func() {
+		<-informerContext.Done()
+		informerFactory.Shutdown()
+		close(shutdown)
+	}()
+
+	return informer, func() {
+		cancel()
+		<-shutdown
+	}, nil
+}
+
+// Calls updated for added and updated pods, and deleted for deleted pods
+// (including those recovered from tombstones).
+func newPodEventHandler(updated func(pod *core.Pod), deleted func(pod *core.Pod)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(object any) {
+			if pod, ok := object.(*core.Pod); ok {
+				updated(pod)
+			}
+		},
+		UpdateFunc: func(old any, new any) {
+			if pod, ok := new.(*core.Pod); ok {
+				updated(pod)
+			}
+		},
+		DeleteFunc: func(object any) {
+			if tombstone, ok := object.(cache.DeletedFinalStateUnknown); ok {
+				object = tombstone.Obj
+			}
+			if pod, ok := object.(*core.Pod); ok {
+				deleted(pod)
+			}
+		},
+	}
+}
+
+func getPodInformer(informerFactory informers.SharedInformerFactory) cache.SharedIndexInformer {
+	return informerFactory.Core().V1().Pods().Informer()
+}
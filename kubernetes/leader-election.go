@@ -0,0 +1,152 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tliron/commonlog"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+//
+// LeaderElection
+//
+
+type LeaderElection struct {
+	Kubernetes kubernetespkg.Interface
+	Namespace  string // of the lease
+	Name       string // of the lease
+	Identity   string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	// Release the lease when the context is cancelled so that another
+	// candidate can take over immediately
+	ReleaseOnCancel bool
+
+	// Optional callbacks
+	OnStartedLeading func()
+	OnStoppedLeading func()
+	OnNewLeader      func(identity string)
+
+	Log commonlog.Logger
+
+	leading bool
+	lock    sync.Mutex
+}
+
+// Creates a Lease-based leader election with the default durations used by
+// Kubernetes's own components. The identity is the hostname (which for a pod
+// is its name) with a unique suffix.
+func NewLeaderElection(toolName string, kubernetes kubernetespkg.Interface, namespace string, name string) *LeaderElection {
+	identity, _ := os.Hostname()
+	if identity == "" {
+		identity = toolName
+	}
+
+	return &LeaderElection{
+		Kubernetes:    kubernetes,
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity + "_" + string(uuid.NewUUID()),
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		Log:           commonlog.GetLoggerf("%s.leader-election", toolName),
+	}
+}
+
+// True if we currently hold the lease.
+func (self *LeaderElection) IsLeading() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.leading
+}
+
+// Contends for the lease until the context is cancelled. Whenever leadership
+// is acquired lead is called in a goroutine with a channel that is closed when
+// leadership is lost. After losing leadership we will contend again.
+//
+// Blocks until the context is cancelled. Returns an error only if the
+// configuration is invalid.
+func (self *LeaderElection) Run(context contextpkg.Context, lead func(stopChannel <-chan struct{})) error {
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: meta.ObjectMeta{
+				Namespace: self.Namespace,
+				Name:      self.Name,
+			},
+			Client: self.Kubernetes.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: self.Identity,
+			},
+		},
+		LeaseDuration:   self.LeaseDuration,
+		RenewDeadline:   self.RenewDeadline,
+		RetryPeriod:     self.RetryPeriod,
+		ReleaseOnCancel: self.ReleaseOnCancel,
+		Name:            self.Namespace + "/" + self.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context contextpkg.Context) {
+				self.setLeading(true)
+				self.logInfo("started leading %s/%s as %q", self.Namespace, self.Name, self.Identity)
+				if self.OnStartedLeading != nil {
+					self.OnStartedLeading()
+				}
+				lead(context.Done())
+			},
+			OnStoppedLeading: func() {
+				// Also called if we never started leading
+				if self.setLeading(false) {
+					self.logInfo("stopped leading %s/%s as %q", self.Namespace, self.Name, self.Identity)
+					if self.OnStoppedLeading != nil {
+						self.OnStoppedLeading()
+					}
+				}
+			},
+			OnNewLeader: func(identity string) {
+				self.logInfo("leader of %s/%s is %q", self.Namespace, self.Name, identity)
+				if self.OnNewLeader != nil {
+					self.OnNewLeader(identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for context.Err() == nil {
+		// Returns when leadership is lost or the context is cancelled
+		elector.Run(context)
+	}
+
+	return nil
+}
+
+// Returns true if changed.
+func (self *LeaderElection) setLeading(leading bool) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.leading != leading {
+		self.leading = leading
+		return true
+	} else {
+		return false
+	}
+}
+
+func (self *LeaderElection) logInfo(format string, values ...any) {
+	if self.Log != nil {
+		self.Log.Infof(format, values...)
+	}
+}
@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElection(t *testing.T) {
+	kubernetes := fake.NewClientset()
+
+	newLeaderElection := func(identity string, leading chan string) *LeaderElection {
+		leaderElection := NewLeaderElection("test", kubernetes, "default", "test-lease")
+		leaderElection.Identity = identity
+		leaderElection.LeaseDuration = time.Second
+		leaderElection.RenewDeadline = 500 * time.Millisecond
+		leaderElection.RetryPeriod = 100 * time.Millisecond
+		leaderElection.ReleaseOnCancel = true
+		leaderElection.OnStartedLeading = func() {
+			leading <- identity
+		}
+		return leaderElection
+	}
+
+	waitForLeader := func(leading chan string) string {
+		select {
+		case identity := <-leading:
+			return identity
+		case <-time.After(10 * time.Second):
+			t.Fatal("no leader")
+			return ""
+		}
+	}
+
+	leading := make(chan string, 10)
+	stopped := make(chan struct{})
+
+	// Processors should start only when leading
+	processed := make(chan any, 10)
+	processors := NewProcessors("test")
+	informer := informers.NewSharedInformerFactory(kubernetes, 0).Core().V1().ConfigMaps().Informer()
+	processor := NewProcessor("test", "configmap", informer, 0,
+		func(name string, namespace string) (any, error) {
+			return name, nil
+		},
+		func(object any) (bool, error) {
+			processed <- object
+			return true, nil
+		})
+	processors.Add(core.SchemeGroupVersion.WithKind("ConfigMap"), processor)
+	processors.LeaderElection = newLeaderElection("a", leading)
+	processors.LeaderElection.OnStoppedLeading = func() {
+		close(stopped)
+	}
+	processor.Workqueue.Add(ProcessorKey{Namespace: "default", Name: "work"})
+
+	stopChannelA := make(chan struct{})
+	processors.Start(1, stopChannelA)
+	if identity := waitForLeader(leading); identity != "a" {
+		t.Errorf("leader: %q", identity)
+	}
+
+	select {
+	case object := <-processed:
+		if object != "work" {
+			t.Errorf("processed: %v", object)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("not processed")
+	}
+
+	contextB, cancelB := context.WithCancel(context.TODO())
+	defer cancelB()
+	b := newLeaderElection("b", leading)
+	go b.Run(contextB, func(stopChannel <-chan struct{}) {})
+
+	// "b" should take over only when "a" releases the lease
+	select {
+	case identity := <-leading:
+		t.Fatalf("leader while the lease is held: %q", identity)
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	close(stopChannelA)
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not stop leading")
+	}
+	if processors.LeaderElection.IsLeading() {
+		t.Error("still leading")
+	}
+
+	if identity := waitForLeader(leading); identity != "b" {
+		t.Errorf("leader: %q", identity)
+	}
+	if !b.IsLeading() {
+		t.Error("not leading")
+	}
+}
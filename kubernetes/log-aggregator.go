@@ -0,0 +1,417 @@
+package kubernetes
+
+import (
+	"bufio"
+	contextpkg "context"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tliron/commonlog"
+	"github.com/tliron/go-kutil/terminal"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+//
+// LogOptions
+//
+
+type LogOptions struct {
+	Containers     []string          // container names; empty for all containers
+	InitContainers bool              // also include init containers
+	Follow         bool              // keep streaming and pick up new pods and containers as they appear
+	Tail           *int64            // nil for all lines
+	Since          time.Time         // zero for no limit
+	Previous       bool              // the previous (terminated) instance of each container instead of the current one
+	Timestamps     bool              // parse the timestamp of each line into [LogRecord.Timestamp]
+	Stylist        *terminal.Stylist // used by [LogAggregator.Reader]; nil for no colorization
+}
+
+//
+// LogRecord
+//
+
+type LogRecord struct {
+	Namespace string
+	Pod       string
+	Container string
+	Timestamp time.Time // zero if [LogOptions.Timestamps] is false
+	Line      string
+}
+
+var logRecordColorizers = []terminal.Colorizer{
+	terminal.ColorGreen,
+	terminal.ColorYellow,
+	terminal.ColorBlue,
+	terminal.ColorMagenta,
+	terminal.ColorCyan,
+}
+
+// Formats the record as a single line (without a newline) prefixed by the pod
+// and container names. When the stylist colorizes, each pod and container
+// name will be consistently assigned a color.
+func (self *LogRecord) Format(stylist *terminal.Stylist) string {
+	pod := self.Pod
+	container := self.Container
+	var timestamp string
+	if !self.Timestamp.IsZero() {
+		timestamp = self.Timestamp.Format(time.RFC3339Nano)
+	}
+
+	if (stylist != nil) && stylist.Colorize {
+		pod = getLogRecordColorizer(pod)(pod)
+		container = getLogRecordColorizer(container)(container)
+		if timestamp != "" {
+			timestamp = terminal.ColorGray(timestamp)
+		}
+	}
+
+	if timestamp != "" {
+		return pod + " " + container + " " + timestamp + " " + self.Line
+	} else {
+		return pod + " " + container + " " + self.Line
+	}
+}
+
+//
+// LogAggregator
+//
+
+// Streams the logs of all containers of all pods matching a label selector and
+// merges them into a single stream of records.
+//
+// Pods are discovered via an informer. With [LogOptions.Follow] new pods and
+// restarted containers will be picked up as they appear, otherwise only the
+// logs of the pods existing at start will be streamed and the stream will end
+// when they are exhausted.
+type LogAggregator struct {
+	Namespace string
+	Selector  string
+	Options   LogOptions
+
+	kubernetes kubernetespkg.Interface
+	log        commonlog.Logger
+	context    contextpkg.Context
+	cancel     contextpkg.CancelFunc
+	records    chan LogRecord
+	streams    map[string]time.Time // when followed streams were interrupted; zero if not
+	lock       sync.Mutex
+	wait       sync.WaitGroup
+}
+
+// Starts streaming the logs of pods matching the label selector. An empty
+// selector selects all pods in the namespace.
+//
+// Cancelling the context is equivalent to calling [LogAggregator.Close].
+func StartLogAggregator(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, selector string, options *LogOptions, log commonlog.Logger) (*LogAggregator, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, err
+	}
+
+	self := LogAggregator{
+		Namespace:  namespace,
+		Selector:   selector,
+		kubernetes: kubernetes,
+		log:        log,
+		records:    make(chan LogRecord, 100),
+		streams:    make(map[string]time.Time),
+	}
+
+	if options != nil {
+		self.Options = *options
+	}
+
+	self.context, self.cancel = contextpkg.WithCancel(context)
+
+	var handler cache.ResourceEventHandler
+	if self.Options.Follow {
+		handler = newPodEventHandler(self.podUpdated, self.podDeleted)
+	}
+
+	// This counts as a stream so that records will not be closed before we
+	// are done discovering pods
+	self.wait.Add(1)
+
+	informer, stopInformer, err := startInformer(self.context, kubernetes, namespace, selector, getPodInformer, handler)
+	if err != nil {
+		self.cancel()
+		self.wait.Done()
+		return nil, err
+	}
+
+	if self.Options.Follow {
+		go func() {
+			<-self.context.Done()
+			stopInformer()
+			self.wait.Done()
+		}()
+	} else {
+		for _, object := range informer.GetStore().List() {
+			if pod, ok := object.(*core.Pod); ok {
+				self.podUpdated(pod)
+			}
+		}
+
+		// We don't need the informer anymore, but the streams continue
+		stopInformer()
+		self.wait.Done()
+	}
+
+	go func() {
+		self.wait.Wait()
+		close(self.records)
+	}()
+
+	return &self, nil
+}
+
+// Starts streaming the logs of pods with the "app.kubernetes.io/name" label.
+func StartAppLogAggregator(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, appName string, options *LogOptions, log commonlog.Logger) (*LogAggregator, error) {
+	selector := labels.Set(map[string]string{
+		"app.kubernetes.io/name": appName,
+	}).AsSelector().String()
+
+	return StartLogAggregator(context, kubernetes, namespace, selector, options, log)
+}
+
+// Stops all streams. The records channel will be closed once they have ended.
+func (self *LogAggregator) Close() {
+	self.cancel()
+}
+
+// The merged records of all streams. Lines from the same container are in
+// order, but there is no ordering between containers.
+//
+// The channel is closed when all streams have ended. Do not use together with
+// [LogAggregator.Reader].
+func (self *LogAggregator) Records() <-chan LogRecord {
+	return self.records
+}
+
+// The merged records of all streams, formatted with [LogRecord.Format] using
+// [LogOptions.Stylist], one per line.
+//
+// Closing the reader closes the aggregator. Do not use together with
+// [LogAggregator.Records].
+func (self *LogAggregator) Reader() io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		for record := range self.records {
+			if _, err := io.WriteString(pipeWriter, record.Format(self.Options.Stylist)+"\n"); err != nil {
+				// The reader was closed, but we must keep draining
+				self.Close()
+			}
+		}
+		pipeWriter.Close()
+	}()
+
+	return &logAggregatorReader{pipeReader, self}
+}
+
+func (self *LogAggregator) podUpdated(pod *core.Pod) {
+	if pod.DeletionTimestamp != nil {
+		return
+	}
+
+	if self.Options.InitContainers {
+		self.containersUpdated(pod, pod.Status.InitContainerStatuses)
+	}
+	self.containersUpdated(pod, pod.Status.ContainerStatuses)
+}
+
+func (self *LogAggregator) podDeleted(pod *core.Pod) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	prefix := pod.Namespace + "/" + pod.Name + "/"
+	for key := range self.streams {
+		if strings.HasPrefix(key, prefix) {
+			delete(self.streams, key)
+		}
+	}
+}
+
+func (self *LogAggregator) containersUpdated(pod *core.Pod, statuses []core.ContainerStatus) {
+	for _, status := range statuses {
+		if !self.includeContainer(status.Name) {
+			continue
+		}
+
+		var containerID string
+		follow := false
+		if self.Options.Previous {
+			if status.LastTerminationState.Terminated == nil {
+				continue
+			}
+			containerID = status.LastTerminationState.Terminated.ContainerID
+		} else {
+			if status.State.Running != nil {
+				follow = self.Options.Follow
+			} else if status.State.Terminated == nil {
+				// Waiting containers have no logs yet
+				continue
+			}
+			containerID = status.ContainerID
+		}
+
+		// A restarted container gets a new ID, so we will stream it anew
+		key := pod.Namespace + "/" + pod.Name + "/" + status.Name + "/" + containerID
+
+		self.lock.Lock()
+		interrupted, ok := self.streams[key]
+		// Streams that ended while the container is still running are resumed
+		start := !ok || (follow && !interrupted.IsZero())
+		if start {
+			self.streams[key] = time.Time{}
+			self.wait.Add(1)
+		}
+		self.lock.Unlock()
+
+		if start {
+			go self.stream(key, pod.Namespace, pod.Name, status.Name, follow, interrupted)
+		}
+	}
+}
+
+func (self *LogAggregator) includeContainer(name string) bool {
+	if len(self.Options.Containers) == 0 {
+		return true
+	}
+
+	for _, name_ := range self.Options.Containers {
+		if name_ == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// When resuming an interrupted stream we start from when it was interrupted.
+func (self *LogAggregator) stream(key string, namespace string, podName string, containerName string, follow bool, resume time.Time) {
+	defer self.wait.Done()
+
+	if follow {
+		defer func() {
+			if self.context.Err() == nil {
+				self.lock.Lock()
+				// Unless the pod was deleted in the meantime
+				if _, ok := self.streams[key]; ok {
+					self.streams[key] = time.Now()
+				}
+				self.lock.Unlock()
+			}
+		}()
+	}
+
+	options := core.PodLogOptions{
+		Container:  containerName,
+		Follow:     follow,
+		Previous:   self.Options.Previous,
+		Timestamps: self.Options.Timestamps,
+	}
+
+	if resume.IsZero() {
+		if self.Options.Tail != nil {
+			tail := *self.Options.Tail
+			options.TailLines = &tail
+		}
+
+		if !self.Options.Since.IsZero() {
+			since := meta.NewTime(self.Options.Since)
+			options.SinceTime = &since
+		}
+	} else {
+		since := meta.NewTime(resume)
+		options.SinceTime = &since
+	}
+
+	reader, err := self.kubernetes.CoreV1().Pods(namespace).GetLogs(podName, &options).Stream(self.context)
+	if err != nil {
+		if self.context.Err() == nil {
+			self.logError("log of %s/%s/%s: %s", namespace, podName, containerName, err.Error())
+		}
+		return
+	}
+	defer reader.Close()
+
+	self.logDebug("streaming log of %s/%s/%s", namespace, podName, containerName)
+
+	bufferedReader := bufio.NewReader(reader)
+	for {
+		line, err := bufferedReader.ReadString('\n')
+
+		if line != "" {
+			record := LogRecord{
+				Namespace: namespace,
+				Pod:       podName,
+				Container: containerName,
+				Line:      strings.TrimRight(line, "\r\n"),
+			}
+
+			if self.Options.Timestamps {
+				if timestamp, line_, ok := strings.Cut(record.Line, " "); ok {
+					if timestamp_, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+						record.Timestamp = timestamp_
+						record.Line = line_
+					}
+				}
+			}
+
+			select {
+			case self.records <- record:
+			case <-self.context.Done():
+				return
+			}
+		}
+
+		if err != nil {
+			if (err != io.EOF) && (self.context.Err() == nil) {
+				self.logError("log of %s/%s/%s: %s", namespace, podName, containerName, err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (self *LogAggregator) logDebug(format string, values ...any) {
+	if self.log != nil {
+		self.log.Debugf(format, values...)
+	}
+}
+
+func (self *LogAggregator) logError(format string, values ...any) {
+	if self.log != nil {
+		self.log.Errorf(format, values...)
+	}
+}
+
+//
+// logAggregatorReader
+//
+
+type logAggregatorReader struct {
+	*io.PipeReader
+	aggregator *LogAggregator
+}
+
+// ([io.Closer] interface)
+func (self *logAggregatorReader) Close() error {
+	self.aggregator.Close()
+	return self.PipeReader.Close()
+}
+
+// Utils
+
+func getLogRecordColorizer(name string) terminal.Colorizer {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return logRecordColorizers[hash.Sum32()%uint32(len(logRecordColorizers))]
+}
@@ -0,0 +1,168 @@
+package kubernetes
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tliron/go-kutil/terminal"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestLogAggregator(t *testing.T) {
+	kubernetes := fake.NewClientset(
+		newTestLogPod("app-1", "app", "c1"),
+		newTestLogPod("other-1", "other", "c2"),
+	)
+
+	// The fake clientset streams "fake logs" for every container
+	tail := int64(10)
+	aggregator, err := StartAppLogAggregator(context.TODO(), kubernetes, "default", "app", &LogOptions{Tail: &tail}, nil)
+	if err != nil {
+		t.Fatalf("StartAppLogAggregator: %s", err.Error())
+	}
+
+	var records []LogRecord
+	for record := range aggregator.Records() {
+		records = append(records, record)
+	}
+	if (len(records) != 1) || (records[0] != LogRecord{Namespace: "default", Pod: "app-1", Container: "main", Line: "fake logs"}) {
+		t.Errorf("records: %+v", records)
+	}
+	expectTestLogTail(t, kubernetes, &tail)
+
+	// Nil options means all lines
+	kubernetes.ClearActions()
+	aggregator, err = StartAppLogAggregator(context.TODO(), kubernetes, "default", "app", nil, nil)
+	if err != nil {
+		t.Fatalf("StartAppLogAggregator: %s", err.Error())
+	}
+	for range aggregator.Records() {
+	}
+	expectTestLogTail(t, kubernetes, nil)
+
+	// Follow should pick up new pods
+	aggregator, err = StartAppLogAggregator(context.TODO(), kubernetes, "default", "app", &LogOptions{Follow: true, Stylist: terminal.NewStylist(false)}, nil)
+	if err != nil {
+		t.Fatalf("StartAppLogAggregator: %s", err.Error())
+	}
+	reader := aggregator.Reader()
+
+	line := make([]byte, len("app-1 main fake logs\n"))
+	if _, err := io.ReadFull(reader, line); (err != nil) || (string(line) != "app-1 main fake logs\n") {
+		t.Errorf("reader: %q, %v", line, err)
+	}
+
+	if _, err := kubernetes.CoreV1().Pods("default").Create(context.TODO(), newTestLogPod("app-2", "app", "c3"), meta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	line = make([]byte, len("app-2 main fake logs\n"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := io.ReadFull(reader, line); (err != nil) || (string(line) != "app-2 main fake logs\n") {
+			t.Errorf("reader: %q, %v", line, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new pod not picked up")
+	}
+
+	reader.Close()
+	for range aggregator.Records() {
+	}
+}
+
+func TestLogAggregatorResume(t *testing.T) {
+	kubernetes := fake.NewClientset(newTestLogPod("app-1", "app", "c1"))
+
+	aggregator, err := StartAppLogAggregator(context.TODO(), kubernetes, "default", "app", &LogOptions{Follow: true}, nil)
+	if err != nil {
+		t.Fatalf("StartAppLogAggregator: %s", err.Error())
+	}
+	defer aggregator.Close()
+
+	expectTestLogRecord(t, aggregator, "app-1")
+
+	// The fake stream has ended, but the container is still running, so the
+	// next pod update should resume it
+	for interrupted := false; !interrupted; {
+		time.Sleep(10 * time.Millisecond)
+		aggregator.lock.Lock()
+		interrupted = !aggregator.streams["default/app-1/main/c1"].IsZero()
+		aggregator.lock.Unlock()
+	}
+
+	kubernetes.ClearActions()
+	pod := newTestLogPod("app-1", "app", "c1")
+	pod.Labels["updated"] = "true"
+	if _, err := kubernetes.CoreV1().Pods("default").Update(context.TODO(), pod, meta.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectTestLogRecord(t, aggregator, "app-1")
+	for _, action := range kubernetes.Actions() {
+		if action.GetSubresource() == "log" {
+			if options := action.(clienttesting.GenericAction).GetValue().(*core.PodLogOptions); options.SinceTime == nil {
+				t.Error("resumed stream has no SinceTime")
+			}
+		}
+	}
+}
+
+func TestLogRecordFormat(t *testing.T) {
+	record := LogRecord{Pod: "pod", Container: "main", Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Line: "hello"}
+	if line := record.Format(nil); line != "pod main 2020-01-02T03:04:05Z hello" {
+		t.Errorf("Format: %q", line)
+	}
+	if line := record.Format(terminal.NewStylist(true)); line == "pod main 2020-01-02T03:04:05Z hello" {
+		t.Errorf("Format not colorized: %q", line)
+	}
+}
+
+func newTestLogPod(name string, appName string, containerID string) *core.Pod {
+	pod := newTestPod(name, map[string]string{"app.kubernetes.io/name": appName}, true)
+	pod.Status.ContainerStatuses = []core.ContainerStatus{{
+		Name:        "main",
+		ContainerID: containerID,
+		State:       core.ContainerState{Running: &core.ContainerStateRunning{}},
+	}}
+	return pod
+}
+
+func expectTestLogRecord(t *testing.T, aggregator *LogAggregator, pod string) {
+	t.Helper()
+
+	select {
+	case record := <-aggregator.Records():
+		if record.Pod != pod {
+			t.Errorf("record: %+v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no record for %s", pod)
+	}
+}
+
+func expectTestLogTail(t *testing.T, kubernetes *fake.Clientset, tail *int64) {
+	t.Helper()
+
+	for _, action := range kubernetes.Actions() {
+		if action.GetSubresource() == "log" {
+			options := action.(clienttesting.GenericAction).GetValue().(*core.PodLogOptions)
+			if ((options.TailLines == nil) != (tail == nil)) || ((tail != nil) && (*options.TailLines != *tail)) {
+				t.Errorf("TailLines: %v", options.TailLines)
+			}
+			return
+		}
+	}
+
+	t.Error("no log request")
+}
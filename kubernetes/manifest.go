@@ -0,0 +1,274 @@
+package kubernetes
+
+import (
+	"bytes"
+	contextpkg "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tliron/go-ard"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+)
+
+// Kinds that other kinds may depend on are applied first. Kinds not in this
+// list (e.g. custom resources) are applied last.
+var ManifestKindOrder = []string{
+	"CustomResourceDefinition",
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+	"MutatingWebhookConfiguration",
+	"ValidatingWebhookConfiguration",
+}
+
+var DefaultCustomResourceDefinitionTimeout = 60 * time.Second
+
+//
+// Manifest
+//
+
+type Manifest struct {
+	Objects []*unstructured.Unstructured
+}
+
+// Renders a Go template into a multi-document YAML manifest. Empty documents
+// are skipped and "List" kinds are expanded into their items.
+func NewManifestFromYAMLTemplate(code string, data any) (*Manifest, error) {
+	if template_, err := template.New("manifest").Parse(code); err == nil {
+		var buffer bytes.Buffer
+		if err := template_.Execute(&buffer, data); err == nil {
+			return NewManifestFromYAML(buffer.Bytes())
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Empty documents are skipped and "List" kinds are expanded into their items.
+func NewManifestFromYAML(code []byte) (*Manifest, error) {
+	documents, err := ard.ReadAllYAML(bytes.NewReader(code))
+	if err != nil {
+		return nil, err
+	}
+
+	var self Manifest
+	for index, document := range documents {
+		if document == nil {
+			continue
+		}
+
+		// Round-tripping through JSON normalizes the values for Unstructured
+		document, _ = ard.ConvertMapsToStringMaps(document)
+		code, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", index+1, err)
+		}
+
+		object, err := runtime.Decode(unstructured.UnstructuredJSONScheme, code)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", index+1, err)
+		}
+
+		switch object_ := object.(type) {
+		case *unstructured.Unstructured:
+			self.Objects = append(self.Objects, object_)
+
+		case *unstructured.UnstructuredList:
+			for index_ := range object_.Items {
+				self.Objects = append(self.Objects, &object_.Items[index_])
+			}
+		}
+	}
+
+	return &self, nil
+}
+
+// Sorts the objects according to [ManifestKindOrder]. The order of objects of
+// the same kind is preserved.
+func (self *Manifest) Sort() {
+	slices.SortStableFunc(self.Objects, func(a *unstructured.Unstructured, b *unstructured.Unstructured) int {
+		return getManifestKindOrder(a) - getManifestKindOrder(b)
+	})
+}
+
+//
+// ManifestApplyOptions
+//
+
+type ManifestApplyOptions struct {
+	ApplyOptions
+
+	// The namespace for objects of namespaced kinds that don't specify one;
+	// empty to leave them as is
+	Namespace string
+
+	// Continue applying the remaining objects after an object fails
+	ContinueOnError bool
+
+	// How long to wait for each CustomResourceDefinition to become Established;
+	// zero for [DefaultCustomResourceDefinitionTimeout]
+	CustomResourceDefinitionTimeout time.Duration
+}
+
+//
+// ManifestResult
+//
+
+type ManifestResult struct {
+	Object  *unstructured.Unstructured // as in the manifest
+	Applied *unstructured.Unstructured // as returned by the server; nil on error
+	Err     error
+}
+
+// ([fmt.Stringer] interface)
+func (self *ManifestResult) String() string {
+	name := self.Object.GetKind() + " "
+	if namespace := self.Object.GetNamespace(); namespace != "" {
+		name += namespace + "/"
+	}
+	name += self.Object.GetName()
+
+	if self.Err == nil {
+		return name + ": applied"
+	} else {
+		return name + ": " + self.Err.Error()
+	}
+}
+
+// Sorts the manifest and applies its objects in order via
+// [Dynamic.ApplyResource]. CustomResourceDefinitions are waited for until they
+// are Established so that custom resources in the same manifest can be
+// applied (except in dry-run, in which case the custom resources will fail if
+// their definitions don't already exist).
+//
+// Returns the results of the objects that were attempted, and all their
+// errors joined.
+func (self *Dynamic) ApplyManifest(manifest *Manifest, options *ManifestApplyOptions) ([]ManifestResult, error) {
+	if options == nil {
+		options = new(ManifestApplyOptions)
+	}
+
+	manifest.Sort()
+
+	results := make([]ManifestResult, 0, len(manifest.Objects))
+	var errs []error
+	var waitedForDefinitions bool
+
+	for _, object := range manifest.Objects {
+		if waitedForDefinitions && !isCustomResourceDefinition(object) {
+			// The discovery cache might not include our new custom resources
+			if cached, ok := self.Discovery.(discovery.CachedDiscoveryInterface); ok {
+				cached.Invalidate()
+			}
+			waitedForDefinitions = false
+		}
+
+		result := ManifestResult{Object: object}
+		if object_, err := self.setManifestNamespace(object, options.Namespace); err == nil {
+			result.Applied, result.Err = self.ApplyResource(object_, &options.ApplyOptions)
+		} else {
+			result.Err = err
+		}
+
+		if (result.Err == nil) && isCustomResourceDefinition(object) && !options.DryRun {
+			timeout := options.CustomResourceDefinitionTimeout
+			if timeout == 0 {
+				timeout = DefaultCustomResourceDefinitionTimeout
+			}
+			if result.Err = self.WaitForCustomResourceDefinition(object.GetName(), timeout); result.Err == nil {
+				waitedForDefinitions = true
+			}
+		}
+
+		if result.Err == nil {
+			self.Log.Infof("%s", result.String())
+		} else {
+			self.Log.Errorf("%s", result.String())
+			errs = append(errs, errors.New(result.String()))
+		}
+
+		results = append(results, result)
+
+		if (result.Err != nil) && !options.ContinueOnError {
+			break
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// Returns a copy of the object with the namespace if it is needed, otherwise
+// the object itself.
+func (self *Dynamic) setManifestNamespace(object *unstructured.Unstructured, namespace string) (*unstructured.Unstructured, error) {
+	if (namespace == "") || (object.GetNamespace() != "") {
+		return object, nil
+	}
+
+	if namespaced, err := IsNamespacedKind(self.Discovery, object.GroupVersionKind()); err == nil {
+		if namespaced {
+			object = object.DeepCopy()
+			object.SetNamespace(namespace)
+		}
+		return object, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Waits until the CustomResourceDefinition has the Established condition.
+func (self *Dynamic) WaitForCustomResourceDefinition(name string, timeout time.Duration) error {
+	context, cancel := contextpkg.WithTimeout(self.context, timeout)
+	defer cancel()
+
+	gvr := customResourceDefinitionGVK.GroupVersion().WithResource("customresourcedefinitions")
+	_, err := self.waitForResource(context, gvr, name, "", NewConditionsReadyFunc("Established"))
+	return err
+}
+
+// Utils
+
+func isCustomResourceDefinition(object *unstructured.Unstructured) bool {
+	return (object.GetKind() == customResourceDefinitionGVK.Kind) && strings.HasPrefix(object.GetAPIVersion(), customResourceDefinitionGVK.Group+"/")
+}
+
+func getManifestKindOrder(object *unstructured.Unstructured) int {
+	if index := slices.Index(ManifestKindOrder, object.GetKind()); index != -1 {
+		return index
+	} else {
+		return len(ManifestKindOrder)
+	}
+}
@@ -0,0 +1,193 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testManifest = `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+  namespace: {{ .Namespace }}
+spec:
+  size: 3
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: {{ .Namespace }}
+---
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Namespace }}
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`
+
+func TestManifest(t *testing.T) {
+	manifest, err := NewManifestFromYAMLTemplate(testManifest, map[string]any{"Namespace": "test"})
+	if err != nil {
+		t.Fatalf("NewManifestFromYAMLTemplate: %s", err.Error())
+	}
+
+	if len(manifest.Objects) != 4 {
+		t.Fatalf("objects: %d", len(manifest.Objects))
+	}
+	if size, _, _ := unstructured.NestedInt64(manifest.Objects[0].Object, "spec", "size"); size != 3 {
+		t.Errorf("size: %d", size)
+	}
+
+	manifest.Sort()
+	var kinds []string
+	for _, object := range manifest.Objects {
+		kinds = append(kinds, object.GetKind())
+	}
+	if (kinds[0] != "CustomResourceDefinition") || (kinds[1] != "Namespace") || (kinds[2] != "ConfigMap") || (kinds[3] != "Widget") {
+		t.Errorf("order: %v", kinds)
+	}
+
+	crdGVR := customResourceDefinitionGVK.GroupVersion().WithResource("customresourcedefinitions")
+	widgetGVR := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	namespaceGVR := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+	dynamic := newTestDynamic()
+	discovery := dynamic.Discovery.(*fakediscovery.FakeDiscovery)
+	verbs := meta.Verbs{"get", "list", "watch", "create", "update", "patch"}
+	discovery.Resources = append(discovery.Resources,
+		&meta.APIResourceList{GroupVersion: "v1", APIResources: []meta.APIResource{{Name: "namespaces", Kind: "Namespace", Verbs: verbs}}},
+		&meta.APIResourceList{GroupVersion: "apiextensions.k8s.io/v1", APIResources: []meta.APIResource{{Name: "customresourcedefinitions", Kind: "CustomResourceDefinition", Verbs: verbs}}},
+		&meta.APIResourceList{GroupVersion: "example.com/v1", APIResources: []meta.APIResource{{Name: "widgets", Namespaced: true, Kind: "Widget", Verbs: verbs}, {Name: "gadgets", Namespaced: true, Kind: "Gadget", Verbs: verbs}}},
+	)
+
+	fake := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, map[schema.GroupVersionResource]string{
+		crdGVR:       "CustomResourceDefinitionList",
+		widgetGVR:    "WidgetList",
+		namespaceGVR: "NamespaceList",
+	})
+	dynamic.Dynamic = fake
+
+	// The fake dynamic client doesn't support server-side apply
+	var applied []string
+	fake.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(clienttesting.PatchAction)
+		object := new(unstructured.Unstructured)
+		if err := object.UnmarshalJSON(patchAction.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		applied = append(applied, object.GetKind())
+
+		if object.GetKind() == "CustomResourceDefinition" {
+			// Becomes established later
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				established := object.DeepCopy()
+				unstructured.SetNestedSlice(established.Object, []any{map[string]any{"type": "Established", "status": "True"}}, "status", "conditions")
+				fake.Tracker().Update(crdGVR, established, "")
+			}()
+		}
+
+		err := fake.Tracker().Create(patchAction.GetResource(), object, patchAction.GetNamespace())
+		if errors.IsAlreadyExists(err) {
+			err = fake.Tracker().Update(patchAction.GetResource(), object, patchAction.GetNamespace())
+		}
+		return true, object, err
+	})
+
+	results, err := dynamic.ApplyManifest(manifest, &ManifestApplyOptions{CustomResourceDefinitionTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("ApplyManifest: %s", err.Error())
+	}
+	if (len(results) != 4) || (results[3].Applied == nil) {
+		t.Errorf("ApplyManifest: %v", results)
+	}
+	if (len(applied) != 4) || (applied[0] != "CustomResourceDefinition") || (applied[3] != "Widget") {
+		t.Errorf("applied: %v", applied)
+	}
+
+	// Without the custom resource we should stop at the first error
+	resources := discovery.Resources[len(discovery.Resources)-1]
+	resources.APIResources = resources.APIResources[1:]
+	gadget := manifest.Objects[3].DeepCopy()
+	gadget.SetKind("Gadget")
+	manifest.Objects = append(manifest.Objects, gadget)
+
+	applied = nil
+	results, err = dynamic.ApplyManifest(manifest, &ManifestApplyOptions{ApplyOptions: ApplyOptions{DryRun: true}})
+	if (err == nil) || (len(results) != 4) || (len(applied) != 3) {
+		t.Errorf("ApplyManifest: %v, %v", results, err)
+	}
+
+	applied = nil
+	results, err = dynamic.ApplyManifest(manifest, &ManifestApplyOptions{ApplyOptions: ApplyOptions{DryRun: true}, ContinueOnError: true})
+	if (err == nil) || (len(results) != 5) || (results[3].Err == nil) || (results[4].Err != nil) || (len(applied) != 4) {
+		t.Errorf("ApplyManifest: %v, %v", results, err)
+	}
+}
+
+func TestManifestNamespace(t *testing.T) {
+	manifest, err := NewManifestFromYAML([]byte(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+  namespace: other
+`))
+	if err != nil {
+		t.Fatalf("NewManifestFromYAML: %s", err.Error())
+	}
+
+	dynamic := newTestDynamic()
+	discovery := dynamic.Discovery.(*fakediscovery.FakeDiscovery)
+	discovery.Resources[0].APIResources = append(discovery.Resources[0].APIResources, meta.APIResource{Name: "namespaces", Kind: "Namespace", Verbs: meta.Verbs{"patch"}})
+
+	namespaces := make(map[string]string)
+	dynamic.Dynamic.(*dynamicfake.FakeDynamicClient).PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(clienttesting.PatchAction)
+		namespaces[patchAction.GetName()] = patchAction.GetNamespace()
+		return true, new(unstructured.Unstructured), nil
+	})
+
+	if _, err := dynamic.ApplyManifest(manifest, &ManifestApplyOptions{Namespace: "test"}); err != nil {
+		t.Fatalf("ApplyManifest: %s", err.Error())
+	}
+
+	// Only namespaced kinds get the namespace, and only if they don't have one
+	for name, namespace := range map[string]string{"test": "", "config": "test", "other": "other"} {
+		if namespaces[name] != namespace {
+			t.Errorf("%s: namespace %q", name, namespaces[name])
+		}
+	}
+
+	// The manifest is not modified
+	if namespace := manifest.Objects[1].GetNamespace(); namespace != "" {
+		t.Errorf("manifest namespace: %q", namespace)
+	}
+}
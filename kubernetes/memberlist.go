@@ -3,66 +3,360 @@
 package kubernetes
 
 import (
+	contextpkg "context"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/hashicorp/memberlist"
 	"github.com/tliron/commonlog"
+	"github.com/tliron/go-kutil/util"
 	core "k8s.io/api/core/v1"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+)
+
+//
+// MemberlistPodEvent
+//
+
+type MemberlistPodEventType string
+
+const (
+	// The pod is a member of the cluster, either because we joined it or
+	// because it already was
+	MemberlistPodJoined MemberlistPodEventType = "joined"
+
+	// The pod is no longer discovered, in which case memberlist's own failure
+	// detection will remove it from the cluster, or it is no longer a member
+	// of the cluster, in which case we will try to join it again
+	MemberlistPodLeft MemberlistPodEventType = "left"
 )
 
+type MemberlistPodEvent struct {
+	Type    MemberlistPodEventType
+	Pod     *core.Pod
+	Address string // "address:port"
+}
+
+type MemberlistPodEventFunc func(event MemberlistPodEvent)
+
+//
+// MemberlistPodDiscoveryOptions
+//
+
+const DEFAULT_MEMBERLIST_RETRY_INTERVAL = 10 * time.Second
+
+type MemberlistPodDiscoveryOptions struct {
+	// The memberlist port of the pods; zero to use PortName
+	Port int
+
+	// The name of the container port of the pods; empty to use the port of the
+	// local node
+	PortName string
+
+	// Which of the pod's IPs to use; for dual stack (or empty) we prefer the
+	// IP version of the local node
+	IPStack util.IPStack
+
+	// How often to retry joining the pods that we failed to join; zero for
+	// DEFAULT_MEMBERLIST_RETRY_INTERVAL
+	RetryInterval time.Duration
+
+	// Called without locks, so it may call back into the cluster
+	OnEvent MemberlistPodEventFunc
+}
+
 //
 // MemberlistPodDiscovery
 //
 
+// Joins the pods discovered by a [PodDiscovery] to a memberlist cluster.
+//
+// Multiple instances (for different clusters) may run in the same process,
+// e.g. with different selectors or port names.
 type MemberlistPodDiscovery struct {
-	cluster      *memberlist.Memberlist
-	podDiscovery *PodDiscovery
-	log          commonlog.Logger
+	Options MemberlistPodDiscoveryOptions
+
+	cluster       *memberlist.Memberlist
+	podDiscovery  *PodDiscovery
+	log           commonlog.Logger
+	cancel        contextpkg.CancelFunc
+	pods          map[string]memberlistPod // discovered
+	joined        map[string]string        // pod key to address
+	lock          sync.Mutex
+	reconcileLock sync.Mutex // so that events are delivered in order
+}
+
+type memberlistPod struct {
+	pod     *core.Pod
+	address string
 }
 
-func StartMemberlistPodDiscovery(cluster *memberlist.Memberlist, namespace string, selector string, frequency float64, log commonlog.Logger) (*MemberlistPodDiscovery, error) {
+func StartMemberlistPodDiscovery(context contextpkg.Context, cluster *memberlist.Memberlist, kubernetes kubernetespkg.Interface, namespace string, selector string, options *MemberlistPodDiscoveryOptions, log commonlog.Logger) (*MemberlistPodDiscovery, error) {
 	self := MemberlistPodDiscovery{
 		cluster: cluster,
 		log:     log,
+		pods:    make(map[string]memberlistPod),
+		joined:  make(map[string]string),
 	}
 
+	if options != nil {
+		self.Options = *options
+	}
+
+	if self.Options.IPStack == "" {
+		self.Options.IPStack = util.DualStack
+	} else if err := self.Options.IPStack.Validate("IPStack"); err != nil {
+		return nil, err
+	}
+
+	if self.Options.RetryInterval <= 0 {
+		self.Options.RetryInterval = DEFAULT_MEMBERLIST_RETRY_INTERVAL
+	}
+
+	context, self.cancel = contextpkg.WithCancel(context)
+
 	var err error
-	if self.podDiscovery, err = StartPodDiscovery(namespace, selector, frequency, self.podsDiscovered, log); err == nil {
-		return &self, nil
-	} else {
+	if self.podDiscovery, err = StartPodDiscovery(context, kubernetes, namespace, selector, self.podsChanged, log); err != nil {
+		self.cancel()
 		return nil, err
 	}
+
+	go self.retry(context)
+
+	return &self, nil
 }
 
 func (self *MemberlistPodDiscovery) Stop() {
-	self.podDiscovery.Stop()
+	self.cancel()
 }
 
-// kubernetes.PodsDiscoveredFunc signature
-func (self *MemberlistPodDiscovery) podsDiscovered(pods []*core.Pod) {
-	members := self.cluster.Members()
+// The addresses ("address:port") of the discovered pods that are members of
+// the cluster.
+func (self *MemberlistPodDiscovery) Joined() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 
-	var newNodes []string
-	for _, pod := range pods {
-		found := false
-		for _, member := range members {
-			if pod.Status.PodIP == member.Addr.String() {
-				found = true
-				break
-			}
+	addresses := make([]string, 0, len(self.joined))
+	for _, address := range self.joined {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// ([PodsChangedFunc] signature)
+func (self *MemberlistPodDiscovery) podsChanged(added []*core.Pod, removed []*core.Pod) {
+	self.reconcileLock.Lock()
+	defer self.reconcileLock.Unlock()
+
+	var events []MemberlistPodEvent
+
+	self.lock.Lock()
+
+	for _, pod := range removed {
+		key := pod.Namespace + "/" + pod.Name
+		if address, ok := self.joined[key]; ok {
+			delete(self.joined, key)
+			events = append(events, MemberlistPodEvent{MemberlistPodLeft, pod, address})
 		}
+		delete(self.pods, key)
+	}
 
-		if !found {
-			newNodes = append(newNodes, pod.Status.PodIP)
+	for _, pod := range added {
+		if address, err := self.getAddress(pod); err == nil {
+			self.pods[pod.Namespace+"/"+pod.Name] = memberlistPod{pod, address}
+		} else {
+			self.logError(err)
 		}
 	}
 
-	if len(newNodes) > 0 {
+	self.lock.Unlock()
+
+	// Pods that we failed to join before will be retried
+	self.notify(append(events, self.reconcile()...))
+}
+
+// Pods that we failed to join are also retried periodically, because
+// otherwise we would have to wait for the next change.
+func (self *MemberlistPodDiscovery) retry(context contextpkg.Context) {
+	ticker := time.NewTicker(self.Options.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			self.reconcileLock.Lock()
+			self.notify(self.reconcile())
+			self.reconcileLock.Unlock()
+
+		case <-context.Done():
+			return
+		}
+	}
+}
+
+// Call with reconcileLock. Joins the cluster without holding lock, because it
+// may take a while.
+func (self *MemberlistPodDiscovery) reconcile() []MemberlistPodEvent {
+	self.lock.Lock()
+	events := self.updateJoined()
+	join := self.unjoined()
+	self.lock.Unlock()
+
+	if len(join) == 0 {
+		return events
+	}
+
+	if _, err := self.cluster.Join(join); err != nil {
+		// Some may have succeeded
+		self.logError(err)
+	}
+
+	self.lock.Lock()
+	events = append(events, self.updateJoined()...)
+	self.lock.Unlock()
+
+	return events
+}
+
+// Call with lock. Marks the pods that are members of the cluster as joined and
+// those that no longer are as unjoined.
+func (self *MemberlistPodDiscovery) updateJoined() []MemberlistPodEvent {
+	var events []MemberlistPodEvent
+
+	for key, address := range self.joined {
+		// The pod's address may also have changed
+		if (self.pods[key].address != address) || !self.isMember(address) {
+			delete(self.joined, key)
+			events = append(events, MemberlistPodEvent{MemberlistPodLeft, self.pods[key].pod, address})
+		}
+	}
+
+	localAddress := self.localAddress()
+	for key, pod := range self.pods {
+		if _, ok := self.joined[key]; !ok && (pod.address != localAddress) && self.isMember(pod.address) {
+			self.joined[key] = pod.address
+			events = append(events, MemberlistPodEvent{MemberlistPodJoined, pod.pod, pod.address})
+		}
+	}
+
+	return events
+}
+
+// Call with lock. The addresses of the pods that are not (yet) joined.
+func (self *MemberlistPodDiscovery) unjoined() []string {
+	var addresses []string
+
+	localAddress := self.localAddress()
+	for key, pod := range self.pods {
+		if _, ok := self.joined[key]; !ok && (pod.address != localAddress) {
+			addresses = append(addresses, pod.address)
+		}
+	}
+
+	return addresses
+}
+
+// Call without lock.
+func (self *MemberlistPodDiscovery) notify(events []MemberlistPodEvent) {
+	for _, event := range events {
 		if self.log != nil {
-			self.log.Infof("new pods discovered: %v", newNodes)
+			self.log.Infof("pod %s: %s/%s %s", event.Type, event.Pod.Namespace, event.Pod.Name, event.Address)
+		}
+		if self.Options.OnEvent != nil {
+			self.Options.OnEvent(event)
+		}
+	}
+}
+
+func (self *MemberlistPodDiscovery) localAddress() string {
+	local := self.cluster.LocalNode()
+	return util.JoinIPAddressPort(local.Addr.String(), int(local.Port))
+}
+
+func (self *MemberlistPodDiscovery) isMember(address string) bool {
+	for _, member := range self.cluster.Members() {
+		if util.JoinIPAddressPort(member.Addr.String(), int(member.Port)) == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *MemberlistPodDiscovery) getAddress(pod *core.Pod) (string, error) {
+	ip := self.getIP(pod)
+	if ip == "" {
+		return "", fmt.Errorf("pod %s/%s has no %s IP", pod.Namespace, pod.Name, self.Options.IPStack)
+	}
+
+	port := self.Options.Port
+	if port == 0 {
+		if self.Options.PortName != "" {
+			for _, container := range pod.Spec.Containers {
+				for _, containerPort := range container.Ports {
+					if containerPort.Name == self.Options.PortName {
+						port = int(containerPort.ContainerPort)
+						break
+					}
+				}
+			}
+			if port == 0 {
+				return "", fmt.Errorf("pod %s/%s has no container port named %q", pod.Namespace, pod.Name, self.Options.PortName)
+			}
+		} else {
+			port = int(self.cluster.LocalNode().Port)
 		}
-		if _, err := self.cluster.Join(newNodes); err != nil {
-			if self.log != nil {
-				self.log.Errorf("%s", err.Error())
+	}
+
+	return util.JoinIPAddressPort(ip, port), nil
+}
+
+func (self *MemberlistPodDiscovery) getIP(pod *core.Pod) string {
+	ips := getPodIPs(pod)
+
+	switch self.Options.IPStack {
+	case util.IPv4Stack, util.IPv6Stack:
+		ipv6 := self.Options.IPStack == util.IPv6Stack
+		for _, ip := range ips {
+			if util.IsIPv6(ip) == ipv6 {
+				return ip
 			}
 		}
+		return ""
+
+	default:
+		// Prefer the local node's IP version
+		ipv6 := util.IsIPv6(self.cluster.LocalNode().Addr.String())
+		for _, ip := range ips {
+			if util.IsIPv6(ip) == ipv6 {
+				return ip
+			}
+		}
+		if len(ips) > 0 {
+			return ips[0]
+		}
+		return ""
+	}
+}
+
+func (self *MemberlistPodDiscovery) logError(err error) {
+	if self.log != nil {
+		self.log.Errorf("%s", err.Error())
+	}
+}
+
+// Utils
+
+func getPodIPs(pod *core.Pod) []string {
+	if len(pod.Status.PodIPs) > 0 {
+		ips := make([]string, len(pod.Status.PodIPs))
+		for index, ip := range pod.Status.PodIPs {
+			ips[index] = ip.IP
+		}
+		return ips
+	} else if pod.Status.PodIP != "" {
+		return []string{pod.Status.PodIP}
+	} else {
+		return nil
 	}
 }
@@ -0,0 +1,196 @@
+//go:build !wasm
+
+package kubernetes
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/tliron/go-kutil/util"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMemberlistPodDiscovery(t *testing.T) {
+	local := newTestMemberlist(t, "local", 0)
+	remote := newTestMemberlist(t, "remote", 0)
+
+	// Both pods are on the same IP, so only the named port distinguishes them
+	localPod := newTestMemberlistPod("local", int32(local.LocalNode().Port))
+	remotePod := newTestMemberlistPod("remote", int32(remote.LocalNode().Port))
+	kubernetes := fake.NewClientset(localPod, remotePod)
+
+	events := make(chan MemberlistPodEvent, 10)
+	discovery, err := StartMemberlistPodDiscovery(context.TODO(), local, kubernetes, "default", "app=test", &MemberlistPodDiscoveryOptions{
+		PortName: "gossip",
+		IPStack:  "ipv4",
+		OnEvent: func(event MemberlistPodEvent) {
+			events <- event
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("StartMemberlistPodDiscovery: %s", err.Error())
+	}
+	defer discovery.Stop()
+
+	expectMemberlistPodEvent(t, events, MemberlistPodJoined, "remote")
+	if members := local.NumMembers(); members != 2 {
+		t.Errorf("members: %d", members)
+	}
+	if joined := discovery.Joined(); len(joined) != 1 {
+		t.Errorf("Joined: %v", joined)
+	}
+
+	kubernetes.CoreV1().Pods("default").Delete(context.TODO(), "remote", meta.DeleteOptions{})
+	expectMemberlistPodEvent(t, events, MemberlistPodLeft, "remote")
+	if joined := discovery.Joined(); len(joined) != 0 {
+		t.Errorf("Joined: %v", joined)
+	}
+
+	// Our own pod is never joined
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event: %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMemberlistPodDiscoveryRetry(t *testing.T) {
+	local := newTestMemberlist(t, "local", 0)
+
+	// Reserve a port for the remote, which is not running yet
+	remote := newTestMemberlist(t, "remote", 0)
+	port := int(remote.LocalNode().Port)
+	remote.Shutdown()
+
+	kubernetes := fake.NewClientset(newTestMemberlistPod("remote", int32(port)))
+
+	events := make(chan MemberlistPodEvent, 10)
+	discovery, err := StartMemberlistPodDiscovery(context.TODO(), local, kubernetes, "default", "app=test", &MemberlistPodDiscoveryOptions{
+		PortName:      "gossip",
+		IPStack:       "ipv4",
+		RetryInterval: 100 * time.Millisecond,
+		OnEvent: func(event MemberlistPodEvent) {
+			events <- event
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("StartMemberlistPodDiscovery: %s", err.Error())
+	}
+	defer discovery.Stop()
+
+	if joined := discovery.Joined(); len(joined) != 0 {
+		t.Errorf("Joined: %v", joined)
+	}
+
+	// No pod changes, so only the retry can join it
+	newTestMemberlist(t, "remote", port)
+	expectMemberlistPodEvent(t, events, MemberlistPodJoined, "remote")
+}
+
+func TestMemberlistPodDiscoveryRejoin(t *testing.T) {
+	local := newTestMemberlist(t, "local", 0)
+	remote := newTestMemberlist(t, "remote", 0)
+	port := int(remote.LocalNode().Port)
+
+	kubernetes := fake.NewClientset(newTestMemberlistPod("remote", int32(port)))
+
+	events := make(chan MemberlistPodEvent, 10)
+	discovery, err := StartMemberlistPodDiscovery(context.TODO(), local, kubernetes, "default", "app=test", &MemberlistPodDiscoveryOptions{
+		PortName:      "gossip",
+		IPStack:       "ipv4",
+		RetryInterval: 100 * time.Millisecond,
+		OnEvent: func(event MemberlistPodEvent) {
+			events <- event
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("StartMemberlistPodDiscovery: %s", err.Error())
+	}
+	defer discovery.Stop()
+
+	expectMemberlistPodEvent(t, events, MemberlistPodJoined, "remote")
+
+	// The pod is still discovered, but is no longer a member
+	remote.Leave(time.Second)
+	remote.Shutdown()
+	expectMemberlistPodEvent(t, events, MemberlistPodLeft, "remote")
+	if joined := discovery.Joined(); len(joined) != 0 {
+		t.Errorf("Joined: %v", joined)
+	}
+
+	newTestMemberlist(t, "remote", port)
+	expectMemberlistPodEvent(t, events, MemberlistPodJoined, "remote")
+}
+
+func TestMemberlistPodDiscoveryIP(t *testing.T) {
+	local := newTestMemberlist(t, "local", 0)
+	pod := newTestMemberlistPod("a", 7946)
+	pod.Status.PodIPs = []core.PodIP{{IP: "fd00::1"}, {IP: "10.0.0.1"}}
+
+	discovery := MemberlistPodDiscovery{cluster: local}
+
+	discovery.Options.IPStack = "dual"
+	if address, _ := discovery.getAddress(pod); address != util.JoinIPAddressPort("10.0.0.1", int(local.LocalNode().Port)) {
+		t.Errorf("dual: %s", address)
+	}
+
+	discovery.Options.IPStack = "ipv6"
+	discovery.Options.Port = 1000
+	if address, _ := discovery.getAddress(pod); address != "[fd00::1]:1000" {
+		t.Errorf("ipv6: %s", address)
+	}
+
+	discovery.Options.Port = 0
+	discovery.Options.PortName = "gossip"
+	if address, _ := discovery.getAddress(pod); address != "[fd00::1]:7946" {
+		t.Errorf("port name: %s", address)
+	}
+
+	discovery.Options.PortName = "missing"
+	if _, err := discovery.getAddress(pod); err == nil {
+		t.Errorf("port name: no error")
+	}
+}
+
+func expectMemberlistPodEvent(t *testing.T, events <-chan MemberlistPodEvent, type_ MemberlistPodEventType, name string) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		if (event.Type != type_) || (event.Pod.Name != name) {
+			t.Errorf("event: %s %s, expected %s %s", event.Type, event.Pod.Name, type_, name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event, expected %s %s", type_, name)
+	}
+}
+
+// Zero port for random.
+func newTestMemberlist(t *testing.T, name string, port int) *memberlist.Memberlist {
+	config := memberlist.DefaultLocalConfig()
+	config.Name = name
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = port
+	config.Logger = log.New(io.Discard, "", 0)
+
+	cluster, err := memberlist.Create(config)
+	if err != nil {
+		t.Fatalf("memberlist.Create: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		cluster.Shutdown()
+	})
+	return cluster
+}
+
+func newTestMemberlistPod(name string, port int32) *core.Pod {
+	pod := newTestDiscoveryPod(name, "127.0.0.1", true)
+	pod.Spec.Containers[0].Ports = []core.ContainerPort{{Name: "gossip", ContainerPort: port}}
+	return pod
+}
@@ -82,3 +82,18 @@ func GetFirstPodIP(context contextpkg.Context, kubernetes kubernetespkg.Interfac
 		return "", err
 	}
 }
+
+// True if the pod is running, not being deleted, and has a true Ready condition.
+func IsPodReady(pod *core.Pod) bool {
+	if (pod.DeletionTimestamp != nil) || (pod.Status.Phase != core.PodRunning) {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == core.PodReady {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+
+	return false
+}
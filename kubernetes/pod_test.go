@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestIsPodReady(t *testing.T) {
+	pending := newTestPod("pending", nil, false)
+	pending.Status.Phase = core.PodPending
+
+	deleted := newTestPod("deleted", nil, true)
+	deletionTimestamp := meta.Now()
+	deleted.DeletionTimestamp = &deletionTimestamp
+
+	noConditions := newTestPod("no-conditions", nil, true)
+	noConditions.Status.Conditions = nil
+
+	tests := []struct {
+		pod   *core.Pod
+		ready bool
+	}{
+		{newTestPod("ready", nil, true), true},
+		{newTestPod("not-ready", nil, false), false},
+		{pending, false},
+		{deleted, false},
+		{noConditions, false},
+	}
+
+	for _, test := range tests {
+		if ready := IsPodReady(test.pod); ready != test.ready {
+			t.Errorf("%s: %t", test.pod.Name, ready)
+		}
+	}
+}
+
+// A running pod in the "default" namespace with a "main" container. The UID
+// is the name.
+func newTestPod(name string, labels map[string]string, ready bool) *core.Pod {
+	status := core.ConditionFalse
+	if ready {
+		status = core.ConditionTrue
+	}
+
+	return &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Labels:    labels,
+		},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{Name: "main"}},
+		},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: status}},
+		},
+	}
+}
@@ -0,0 +1,204 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubernetespkg "k8s.io/client-go/kubernetes"
+)
+
+//
+// PortForwardTarget
+//
+
+type PortForwardTarget interface {
+	// Returns the name of a ready pod and the pod ports for the requested ports.
+	// Called whenever a connection to the target is (re)established.
+	ResolvePortForward(context contextpkg.Context, ports []int) (string, []int, error)
+}
+
+// Targets a specific pod. The ports are pod ports.
+func NewPodPortForwardTarget(kubernetes kubernetespkg.Interface, namespace string, podName string) PortForwardTarget {
+	return &podPortForwardTarget{kubernetes, namespace, podName}
+}
+
+// Targets a ready pod found via [GetPods]. The ports are pod ports.
+func NewAppPortForwardTarget(kubernetes kubernetespkg.Interface, namespace string, appName string) PortForwardTarget {
+	return &appPortForwardTarget{kubernetes, namespace, appName}
+}
+
+// Targets a ready pod selected by the first service found via [GetServices].
+// The ports are service ports, which will be mapped to the pod ports (possibly
+// named) that they target.
+func NewServicePortForwardTarget(kubernetes kubernetespkg.Interface, namespace string, appName string) PortForwardTarget {
+	return &servicePortForwardTarget{kubernetes, namespace, appName}
+}
+
+// Targets a ready pod selected by the deployment. The ports are pod ports.
+func NewDeploymentPortForwardTarget(kubernetes kubernetespkg.Interface, namespace string, deploymentName string) PortForwardTarget {
+	return &deploymentPortForwardTarget{kubernetes, namespace, deploymentName}
+}
+
+//
+// podPortForwardTarget
+//
+
+type podPortForwardTarget struct {
+	kubernetes kubernetespkg.Interface
+	namespace  string
+	podName    string
+}
+
+// ([PortForwardTarget] interface)
+func (self *podPortForwardTarget) ResolvePortForward(context contextpkg.Context, ports []int) (string, []int, error) {
+	if pod, err := self.kubernetes.CoreV1().Pods(self.namespace).Get(context, self.podName, meta.GetOptions{}); err == nil {
+		if IsPodReady(pod) {
+			return pod.Name, ports, nil
+		} else {
+			return "", nil, fmt.Errorf("pod %q in namespace %q is not ready", self.podName, self.namespace)
+		}
+	} else {
+		return "", nil, err
+	}
+}
+
+//
+// appPortForwardTarget
+//
+
+type appPortForwardTarget struct {
+	kubernetes kubernetespkg.Interface
+	namespace  string
+	appName    string
+}
+
+// ([PortForwardTarget] interface)
+func (self *appPortForwardTarget) ResolvePortForward(context contextpkg.Context, ports []int) (string, []int, error) {
+	if pods, err := GetPods(context, self.kubernetes, self.namespace, self.appName); err == nil {
+		if pod := getFirstReadyPod(pods.Items); pod != nil {
+			return pod.Name, ports, nil
+		} else {
+			return "", nil, fmt.Errorf("no ready pods for app.kubernetes.io/name=%q in namespace %q", self.appName, self.namespace)
+		}
+	} else {
+		return "", nil, err
+	}
+}
+
+//
+// servicePortForwardTarget
+//
+
+type servicePortForwardTarget struct {
+	kubernetes kubernetespkg.Interface
+	namespace  string
+	appName    string
+}
+
+// ([PortForwardTarget] interface)
+func (self *servicePortForwardTarget) ResolvePortForward(context contextpkg.Context, ports []int) (string, []int, error) {
+	services, err := GetServices(context, self.kubernetes, self.namespace, self.appName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	service := &services.Items[0]
+	if len(service.Spec.Selector) == 0 {
+		return "", nil, fmt.Errorf("service %q in namespace %q has no selector", service.Name, self.namespace)
+	}
+
+	pod, err := getFirstReadyPodForSelector(context, self.kubernetes, self.namespace, labels.SelectorFromSet(service.Spec.Selector))
+	if err != nil {
+		return "", nil, err
+	}
+
+	podPorts := make([]int, len(ports))
+	for index, port := range ports {
+		if podPorts[index], err = getServiceTargetPort(service, pod, port); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return pod.Name, podPorts, nil
+}
+
+//
+// deploymentPortForwardTarget
+//
+
+type deploymentPortForwardTarget struct {
+	kubernetes     kubernetespkg.Interface
+	namespace      string
+	deploymentName string
+}
+
+// ([PortForwardTarget] interface)
+func (self *deploymentPortForwardTarget) ResolvePortForward(context contextpkg.Context, ports []int) (string, []int, error) {
+	if deployment, err := self.kubernetes.AppsV1().Deployments(self.namespace).Get(context, self.deploymentName, meta.GetOptions{}); err == nil {
+		if selector, err := meta.LabelSelectorAsSelector(deployment.Spec.Selector); err == nil {
+			if pod, err := getFirstReadyPodForSelector(context, self.kubernetes, self.namespace, selector); err == nil {
+				return pod.Name, ports, nil
+			} else {
+				return "", nil, err
+			}
+		} else {
+			return "", nil, err
+		}
+	} else {
+		return "", nil, err
+	}
+}
+
+// Utils
+
+func getFirstReadyPod(pods []core.Pod) *core.Pod {
+	for index := range pods {
+		if IsPodReady(&pods[index]) {
+			return &pods[index]
+		}
+	}
+	return nil
+}
+
+func getFirstReadyPodForSelector(context contextpkg.Context, kubernetes kubernetespkg.Interface, namespace string, selector labels.Selector) (*core.Pod, error) {
+	if pods, err := kubernetes.CoreV1().Pods(namespace).List(context, meta.ListOptions{LabelSelector: selector.String()}); err == nil {
+		if pod := getFirstReadyPod(pods.Items); pod != nil {
+			return pod, nil
+		} else {
+			return nil, fmt.Errorf("no ready pods for %q in namespace %q", selector.String(), namespace)
+		}
+	} else {
+		return nil, err
+	}
+}
+
+func getServiceTargetPort(service *core.Service, pod *core.Pod, port int) (int, error) {
+	for _, servicePort := range service.Spec.Ports {
+		if int(servicePort.Port) == port {
+			switch servicePort.TargetPort.Type {
+			case intstr.Int:
+				if servicePort.TargetPort.IntVal == 0 {
+					// Defaults to the service port
+					return port, nil
+				}
+				return int(servicePort.TargetPort.IntVal), nil
+
+			case intstr.String:
+				for _, container := range pod.Spec.Containers {
+					for _, containerPort := range container.Ports {
+						if containerPort.Name == servicePort.TargetPort.StrVal {
+							return int(containerPort.ContainerPort), nil
+						}
+					}
+				}
+				return 0, fmt.Errorf("pod %q has no port named %q", pod.Name, servicePort.TargetPort.StrVal)
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("service %q has no port %d", service.Name, port)
+}
@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServicePortForwardTarget(t *testing.T) {
+	labels := map[string]string{"app.kubernetes.io/name": "myapp"}
+
+	newPod := func(name string, ready bool) *core.Pod {
+		pod := newTestPod(name, labels, ready)
+		pod.Namespace = "ns"
+		pod.Spec.Containers[0].Ports = []core.ContainerPort{{Name: "http", ContainerPort: 8080}}
+		return pod
+	}
+
+	kubernetes := fake.NewClientset(
+		newPod("not-ready", false),
+		newPod("ready", true),
+		&core.Service{
+			ObjectMeta: meta.ObjectMeta{Name: "myapp", Namespace: "ns", Labels: labels},
+			Spec: core.ServiceSpec{
+				Selector: labels,
+				Ports: []core.ServicePort{
+					{Port: 80, TargetPort: intstr.FromString("http")},
+					{Port: 9000, TargetPort: intstr.FromInt32(9090)},
+					{Port: 7000},
+				},
+			},
+		},
+	)
+
+	target := NewServicePortForwardTarget(kubernetes, "ns", "myapp")
+	if podName, ports, err := target.ResolvePortForward(context.TODO(), []int{80, 9000, 7000}); err == nil {
+		if podName != "ready" {
+			t.Errorf("pod: %s", podName)
+		}
+		if (ports[0] != 8080) || (ports[1] != 9090) || (ports[2] != 7000) {
+			t.Errorf("ports: %v", ports)
+		}
+	} else {
+		t.Errorf("ResolvePortForward: %s", err.Error())
+	}
+
+	if _, _, err := target.ResolvePortForward(context.TODO(), []int{81}); err == nil {
+		t.Error("unknown service port was accepted")
+	}
+
+	if _, _, err := NewPodPortForwardTarget(kubernetes, "ns", "not-ready").ResolvePortForward(context.TODO(), []int{80}); err == nil {
+		t.Error("pod that is not ready was accepted")
+	}
+}
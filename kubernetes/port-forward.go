@@ -0,0 +1,398 @@
+package kubernetes
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tliron/commonlog"
+	"github.com/tliron/go-kutil/util"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	restpkg "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+//
+// PortForwardPort
+//
+
+type PortForwardPort struct {
+	Local  int // zero to choose a random port
+	Remote int // see the PortForwardTarget constructors for how it is interpreted
+}
+
+//
+// PortForward
+//
+
+// Forwards local ports to a target in the cluster.
+//
+// The connection to the target is established on start and then shared by all
+// forwarded connections. If the connection is lost, or the pod is deleted, then
+// the next forwarded connection will resolve the target again and reconnect,
+// e.g. to the pod that replaced the deleted one. The local ports remain bound
+// throughout.
+type PortForward struct {
+	Namespace string
+	Ports     []PortForwardPort // the Local ports are the actual bound ports
+
+	target    PortForwardTarget
+	rest      restpkg.Interface
+	config    *restpkg.Config
+	log       commonlog.Logger
+	context   contextpkg.Context
+	cancel    contextpkg.CancelFunc
+	listeners []net.Listener
+	requestID atomic.Int64
+
+	connection *portForwardConnection
+	lock       sync.Mutex
+}
+
+// Resolves the target, connects to it, and binds local listeners for the ports
+// according to [util.IPStack.ServerBinds]. For dual stack both listeners of a
+// port will use the same port number. An empty address binds all interfaces;
+// use "localhost" to allow only local access.
+//
+// Cancelling the context is equivalent to calling [PortForward.Close].
+func StartPortForward(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, target PortForwardTarget, ipStack util.IPStack, address string, ports []PortForwardPort, log commonlog.Logger) (*PortForward, error) {
+	if err := ipStack.Validate("IP stack"); err != nil {
+		return nil, err
+	}
+
+	self := PortForward{
+		Namespace: namespace,
+		Ports:     append([]PortForwardPort(nil), ports...),
+		target:    target,
+		rest:      rest,
+		config:    config,
+		log:       log,
+	}
+
+	self.context, self.cancel = contextpkg.WithCancel(context)
+
+	if _, err := self.getConnection(); err != nil {
+		self.cancel()
+		return nil, err
+	}
+
+	for index := range self.Ports {
+		port := &self.Ports[index]
+		for _, bind := range ipStack.ServerBinds(address) {
+			if listener, err := net.Listen(bind.Level2Protocol, net.JoinHostPort(bind.Address, strconv.Itoa(port.Local))); err == nil {
+				if port.Local == 0 {
+					port.Local = listener.Addr().(*net.TCPAddr).Port
+				}
+				self.listeners = append(self.listeners, listener)
+				go self.accept(listener, index)
+			} else {
+				self.Close()
+				return nil, err
+			}
+		}
+	}
+
+	go func() {
+		<-self.context.Done()
+		self.Close()
+	}()
+
+	return &self, nil
+}
+
+// Unbinds the local listeners and disconnects from the target. Forwarded
+// connections that are in progress will be closed.
+func (self *PortForward) Close() error {
+	self.cancel()
+
+	var errs []error
+	self.lock.Lock()
+	for _, listener := range self.listeners {
+		if err := listener.Close(); (err != nil) && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	if self.connection != nil {
+		self.connection.close()
+		self.connection = nil
+	}
+	self.lock.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// The name of the currently connected pod. Empty if not connected.
+func (self *PortForward) PodName() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.connection != nil {
+		return self.connection.podName
+	} else {
+		return ""
+	}
+}
+
+func (self *PortForward) accept(listener net.Listener, index int) {
+	for {
+		if conn, err := listener.Accept(); err == nil {
+			go self.handle(conn, index)
+		} else {
+			if !errors.Is(err, net.ErrClosed) {
+				self.logError("accept: %s", err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (self *PortForward) handle(conn net.Conn, index int) {
+	defer conn.Close()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		connection, err := self.getConnection()
+		if err != nil {
+			self.logError("%s", err.Error())
+			return
+		}
+
+		if retry, err := self.forward(connection, conn, self.Ports[index].Local, connection.ports[index]); err != nil {
+			// The connection is no good
+			self.dropConnection(connection)
+
+			if retry {
+				self.logDebug("reconnecting: %s", err.Error())
+				continue
+			}
+
+			self.logError("%s", err.Error())
+		}
+
+		return
+	}
+}
+
+// Returns true for retry if the streams could not be created (and thus no data
+// was forwarded).
+func (self *PortForward) forward(connection *portForwardConnection, conn net.Conn, localPort int, remotePort int) (bool, error) {
+	// See: k8s.io/client-go/tools/portforward.PortForwarder.handleConnection
+
+	headers := http.Header{}
+	headers.Set(core.StreamType, core.StreamTypeError)
+	headers.Set(core.PortHeader, strconv.Itoa(remotePort))
+	headers.Set(core.PortForwardRequestIDHeader, strconv.FormatInt(self.requestID.Add(1), 10))
+
+	errorStream, err := connection.connection.CreateStream(headers)
+	if err != nil {
+		return true, fmt.Errorf("error stream for port %d -> %d: %w", localPort, remotePort, err)
+	}
+	// We're not writing to this stream
+	errorStream.Close()
+	defer connection.connection.RemoveStreams(errorStream)
+
+	errorChan := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		if err != nil {
+			errorChan <- fmt.Errorf("error stream for port %d -> %d: %w", localPort, remotePort, err)
+		} else if len(message) > 0 {
+			errorChan <- fmt.Errorf("forwarding port %d -> %d: %s", localPort, remotePort, message)
+		}
+		close(errorChan)
+	}()
+
+	headers.Set(core.StreamType, core.StreamTypeData)
+	dataStream, err := connection.connection.CreateStream(headers)
+	if err != nil {
+		return true, fmt.Errorf("data stream for port %d -> %d: %w", localPort, remotePort, err)
+	}
+	defer connection.connection.RemoveStreams(dataStream)
+
+	localDone := make(chan struct{})
+	remoteDone := make(chan struct{})
+
+	go func() {
+		io.Copy(conn, dataStream)
+		close(remoteDone)
+	}()
+
+	go func() {
+		// Tell the server that we're not sending any more data
+		defer dataStream.Close()
+		if _, err := io.Copy(dataStream, conn); err != nil {
+			close(localDone)
+		}
+	}()
+
+	select {
+	case <-remoteDone:
+	case <-localDone:
+	}
+
+	// Discard unsent data so that the error stream would not be blocked
+	dataStream.Reset()
+
+	return false, <-errorChan
+}
+
+func (self *PortForward) getConnection() (*portForwardConnection, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.connection != nil {
+		return self.connection, nil
+	}
+
+	if err := self.context.Err(); err != nil {
+		return nil, err
+	}
+
+	remotePorts := make([]int, len(self.Ports))
+	for index, port := range self.Ports {
+		remotePorts[index] = port.Remote
+	}
+
+	podName, podPorts, err := self.target.ResolvePortForward(self.context, remotePorts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(podPorts) != len(remotePorts) {
+		return nil, fmt.Errorf("port forward target resolved %d ports instead of %d", len(podPorts), len(remotePorts))
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(self.config)
+	if err != nil {
+		return nil, err
+	}
+
+	url := self.rest.Post().Resource("pods").Namespace(self.Namespace).Name(podName).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	connection, protocol, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("port forward to pod %q: %w", podName, err)
+	}
+	if protocol != portforward.PortForwardProtocolV1Name {
+		connection.Close()
+		return nil, fmt.Errorf("port forward to pod %q: unsupported protocol %q", podName, protocol)
+	}
+
+	self.logInfo("connected to pod %q", podName)
+
+	self.connection = newPortForwardConnection(self.context, connection, podName, podPorts)
+	go self.watch(self.connection)
+
+	return self.connection, nil
+}
+
+func (self *PortForward) dropConnection(connection *portForwardConnection) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.connection == connection {
+		self.logInfo("disconnected from pod %q", connection.podName)
+		self.connection = nil
+	}
+
+	connection.close()
+}
+
+// Drops the connection when it is closed or the pod is deleted.
+func (self *PortForward) watch(connection *portForwardConnection) {
+	for {
+		watcher, err := self.rest.Get().Resource("pods").Namespace(self.Namespace).VersionedParams(&meta.ListOptions{
+			FieldSelector: "metadata.name=" + connection.podName,
+			Watch:         true,
+		}, scheme.ParameterCodec).Watch(connection.context)
+		if err != nil {
+			// Without a watch we will rely on the connection being closed
+			self.logDebug("watch pod %q: %s", connection.podName, err.Error())
+			select {
+			case <-connection.connection.CloseChan():
+				self.dropConnection(connection)
+			case <-connection.context.Done():
+			}
+			return
+		}
+
+		for done := false; !done; {
+			select {
+			case event, ok := <-watcher.ResultChan():
+				if !ok {
+					// The watch expired, so start another one
+					done = true
+				} else if event.Type == watch.Deleted {
+					watcher.Stop()
+					self.dropConnection(connection)
+					return
+				}
+
+			case <-connection.connection.CloseChan():
+				watcher.Stop()
+				self.dropConnection(connection)
+				return
+
+			case <-connection.context.Done():
+				watcher.Stop()
+				return
+			}
+		}
+	}
+}
+
+func (self *PortForward) logInfo(format string, values ...any) {
+	if self.log != nil {
+		self.log.Infof(format, values...)
+	}
+}
+
+func (self *PortForward) logDebug(format string, values ...any) {
+	if self.log != nil {
+		self.log.Debugf(format, values...)
+	}
+}
+
+func (self *PortForward) logError(format string, values ...any) {
+	if self.log != nil {
+		self.log.Errorf(format, values...)
+	}
+}
+
+//
+// portForwardConnection
+//
+
+type portForwardConnection struct {
+	connection httpstream.Connection
+	podName    string
+	ports      []int
+	context    contextpkg.Context
+	cancel     contextpkg.CancelFunc
+}
+
+func newPortForwardConnection(context contextpkg.Context, connection httpstream.Connection, podName string, ports []int) *portForwardConnection {
+	self := portForwardConnection{
+		connection: connection,
+		podName:    podName,
+		ports:      ports,
+	}
+	self.context, self.cancel = contextpkg.WithCancel(context)
+	return &self
+}
+
+func (self *portForwardConnection) close() {
+	self.cancel()
+	self.connection.Close()
+}
@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"errors"
+	"time"
+)
+
+//
+// ProcessResult
+//
+
+type ProcessResult struct {
+	// Requeue with rate limiting
+	Requeue bool
+
+	// Requeue after this delay, without rate limiting; takes precedence over
+	// Requeue
+	RequeueAfter time.Duration
+}
+
+// The work item is done and will not be requeued unless it changes.
+var ProcessDone = ProcessResult{}
+
+// The work item is unfinished and will be requeued with rate limiting.
+var ProcessRequeue = ProcessResult{Requeue: true}
+
+// The work item is unfinished and will be requeued after the delay.
+func ProcessRequeueAfter(delay time.Duration) ProcessResult {
+	return ProcessResult{RequeueAfter: delay}
+}
+
+//
+// PermanentError
+//
+
+// Returned from a [ReconcileFunc] to indicate that retrying will not help, so
+// the work item should not be requeued.
+type PermanentError struct {
+	Err error
+}
+
+func NewPermanentError(err error) *PermanentError {
+	return &PermanentError{err}
+}
+
+// ([error] interface)
+func (self *PermanentError) Error() string {
+	return self.Err.Error()
+}
+
+func (self *PermanentError) Unwrap() error {
+	return self.Err
+}
+
+func IsPermanentError(err error) bool {
+	var permanentError *PermanentError
+	return errors.As(err, &permanentError)
+}
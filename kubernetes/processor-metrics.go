@@ -0,0 +1,152 @@
+package kubernetes
+
+import (
+	"expvar"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+//
+// ProcessorMetrics
+//
+
+// Receives the metrics of [Processor] workqueues. All methods may be called
+// concurrently.
+type ProcessorMetrics interface {
+	// The number of work items waiting in the queue changed by delta
+	Depth(processor string, delta int)
+
+	// A work item was added to the queue
+	Added(processor string)
+
+	// A work item was requeued (with rate limiting or after a delay)
+	Retried(processor string)
+
+	// A work item was taken from the queue after waiting in it for the duration
+	QueueLatency(processor string, duration time.Duration)
+
+	// A work item was processed for the duration
+	ProcessingDuration(processor string, duration time.Duration)
+}
+
+//
+// ExpvarProcessorMetrics
+//
+
+// A [ProcessorMetrics] that publishes to an [expvar.Map]. For each processor
+// there will be "<processor>.depth", "<processor>.adds", "<processor>.retries",
+// "<processor>.queue_latency_seconds", and "<processor>.processing_seconds"
+// (the latter two are totals).
+type ExpvarProcessorMetrics struct {
+	Map *expvar.Map
+}
+
+// The name must be unique among published expvars.
+func NewExpvarProcessorMetrics(name string) *ExpvarProcessorMetrics {
+	return &ExpvarProcessorMetrics{expvar.NewMap(name)}
+}
+
+// ([ProcessorMetrics] interface)
+func (self *ExpvarProcessorMetrics) Depth(processor string, delta int) {
+	self.Map.Add(processor+".depth", int64(delta))
+}
+
+// ([ProcessorMetrics] interface)
+func (self *ExpvarProcessorMetrics) Added(processor string) {
+	self.Map.Add(processor+".adds", 1)
+}
+
+// ([ProcessorMetrics] interface)
+func (self *ExpvarProcessorMetrics) Retried(processor string) {
+	self.Map.Add(processor+".retries", 1)
+}
+
+// ([ProcessorMetrics] interface)
+func (self *ExpvarProcessorMetrics) QueueLatency(processor string, duration time.Duration) {
+	self.Map.AddFloat(processor+".queue_latency_seconds", duration.Seconds())
+}
+
+// ([ProcessorMetrics] interface)
+func (self *ExpvarProcessorMetrics) ProcessingDuration(processor string, duration time.Duration) {
+	self.Map.AddFloat(processor+".processing_seconds", duration.Seconds())
+}
+
+//
+// processorMetricsProvider
+//
+
+// Adapts [ProcessorMetrics] to [workqueue.MetricsProvider]. The workqueue
+// passes its name, which is the processor name.
+type processorMetricsProvider struct {
+	metrics ProcessorMetrics
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return processorDepthMetric{self.metrics, name}
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return processorCounterMetric(func() { self.metrics.Added(name) })
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return processorHistogramMetric(func(duration time.Duration) { self.metrics.QueueLatency(name, duration) })
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return processorHistogramMetric(func(duration time.Duration) { self.metrics.ProcessingDuration(name, duration) })
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return processorNoopMetric{}
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return processorNoopMetric{}
+}
+
+// ([workqueue.MetricsProvider] interface)
+func (self processorMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return processorCounterMetric(func() { self.metrics.Retried(name) })
+}
+
+type processorDepthMetric struct {
+	metrics ProcessorMetrics
+	name    string
+}
+
+// ([workqueue.GaugeMetric] interface)
+func (self processorDepthMetric) Inc() {
+	self.metrics.Depth(self.name, 1)
+}
+
+// ([workqueue.GaugeMetric] interface)
+func (self processorDepthMetric) Dec() {
+	self.metrics.Depth(self.name, -1)
+}
+
+type processorCounterMetric func()
+
+// ([workqueue.CounterMetric] interface)
+func (self processorCounterMetric) Inc() {
+	self()
+}
+
+type processorHistogramMetric func(duration time.Duration)
+
+// ([workqueue.HistogramMetric] interface)
+func (self processorHistogramMetric) Observe(seconds float64) {
+	self(time.Duration(seconds * float64(time.Second)))
+}
+
+type processorNoopMetric struct{}
+
+// ([workqueue.SettableGaugeMetric] interface)
+func (self processorNoopMetric) Set(float64) {}
@@ -0,0 +1,62 @@
+package kubernetes
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+)
+
+//
+// ProcessorKey
+//
+
+// Identifies a [Processor] work item.
+type ProcessorKey struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+}
+
+// ([fmt.Stringer] interface)
+func (self ProcessorKey) String() string {
+	if self.Namespace != "" {
+		return self.Namespace + "/" + self.Name
+	} else {
+		return self.Name
+	}
+}
+
+//
+// ProcessorRateLimiting
+//
+
+// Work items that are requeued are delayed by the larger of the per-item
+// exponential backoff and the overall token bucket.
+type ProcessorRateLimiting struct {
+	BaseDelay time.Duration // per-item exponential backoff, doubled on each requeue
+	MaxDelay  time.Duration // per-item exponential backoff limit
+	QPS       float64       // overall token bucket; zero to disable
+	Burst     int           // overall token bucket
+}
+
+// The same as [workqueue.DefaultControllerRateLimiter].
+var DefaultProcessorRateLimiting = ProcessorRateLimiting{
+	BaseDelay: 5 * time.Millisecond,
+	MaxDelay:  1000 * time.Second,
+	QPS:       10,
+	Burst:     100,
+}
+
+func (self ProcessorRateLimiting) NewRateLimiter() workqueue.TypedRateLimiter[ProcessorKey] {
+	exponential := workqueue.NewTypedItemExponentialFailureRateLimiter[ProcessorKey](self.BaseDelay, self.MaxDelay)
+	if self.QPS > 0 {
+		return workqueue.NewTypedMaxOfRateLimiter(
+			exponential,
+			&workqueue.TypedBucketRateLimiter[ProcessorKey]{Limiter: rate.NewLimiter(rate.Limit(self.QPS), self.Burst)},
+		)
+	} else {
+		return exponential
+	}
+}
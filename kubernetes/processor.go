@@ -2,12 +2,17 @@ package kubernetes
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/tliron/commonlog"
 	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
@@ -22,68 +27,198 @@ type GetControllerObjectFunc = func(name string, namespace string) (any, error)
 
 type ProcessFunc = func(object any) (bool, error)
 
+// Non-permanent errors are requeued with rate limiting. See [PermanentError].
+type ReconcileFunc = func(object any) (ProcessResult, error)
+
+type FinalizeFunc = func(object any) error
+
+type UpdateControllerObjectFunc = func(object any) (any, error)
+
+type DeletedFunc = func(name string, namespace string) error
+
+// Returns true if the update should be enqueued.
+type ProcessorPredicate = func(old any, new any) bool
+
 type Processor struct {
 	Name                string
 	GVK                 schema.GroupVersionKind
 	Informer            cache.SharedIndexInformer
-	Workqueue           workqueue.RateLimitingInterface
+	Workqueue           workqueue.TypedRateLimitingInterface[ProcessorKey]
 	Period              time.Duration
 	GetControllerObject GetControllerObjectFunc
 	Process             ProcessFunc
 	Log                 commonlog.Logger
+
+	// When set, used instead of Process
+	Reconcile ReconcileFunc
+
+	// When not empty, this finalizer will be added to controller objects on
+	// their first reconcile. When they are marked for deletion, Finalize will
+	// be called instead of Process and the finalizer will be removed if it
+	// succeeds. UpdateControllerObject is used to persist the change to the
+	// finalizers and must return the updated object.
+	Finalizer              string
+	Finalize               FinalizeFunc
+	UpdateControllerObject UpdateControllerObjectFunc
+
+	// Optional. Called when the controller object no longer exists.
+	Deleted DeletedFunc
+
+	// An update is enqueued if any of the predicates returns true. When empty
+	// defaults to [ResourceVersionChangedPredicate], which skips the no-op
+	// updates sent by the informer's periodic resync. Updates that mark the
+	// object for deletion are always enqueued.
+	Predicates []ProcessorPredicate
+
+	// When non-zero all objects in the informer's cache will be enqueued at
+	// this interval regardless of the predicates.
+	ResyncPeriod time.Duration
+
+	// When non-zero overrides the concurrency argument of Start
+	Concurrency uint
+}
+
+//
+// ProcessorOptions
+//
+
+type ProcessorOptions struct {
+	// Nil for [DefaultProcessorRateLimiting]
+	RateLimiting *ProcessorRateLimiting
+
+	// Nil for no metrics
+	Metrics ProcessorMetrics
 }
 
 func NewProcessor(toolName string, name string, informer cache.SharedIndexInformer, period time.Duration, get GetControllerObjectFunc, process ProcessFunc) *Processor {
+	return NewProcessorWithOptions(toolName, name, informer, period, get, process, nil)
+}
+
+// Nil options means defaults.
+func NewProcessorWithOptions(toolName string, name string, informer cache.SharedIndexInformer, period time.Duration, get GetControllerObjectFunc, process ProcessFunc, options *ProcessorOptions) *Processor {
 	self := Processor{
 		Name:                name,
 		Informer:            informer,
-		Workqueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
 		Period:              period,
 		GetControllerObject: get,
 		Process:             process,
 		Log:                 commonlog.GetLoggerf("%s.processor.%s", toolName, name),
 	}
 
+	self.Workqueue = newProcessorWorkqueue(name, options)
+
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: self.EnqueueFor,
-		UpdateFunc: func(old any, new any) {
-			// TODO: the informer's periodic resync will send "update" events on all resources
-			// even if they didn't change
-			self.EnqueueFor(new)
-		},
+		AddFunc:    self.EnqueueFor,
+		UpdateFunc: self.onUpdated,
+		DeleteFunc: self.EnqueueFor,
 	})
 
 	return &self
 }
 
+func NewReconcileProcessor(toolName string, name string, informer cache.SharedIndexInformer, period time.Duration, get GetControllerObjectFunc, reconcile ReconcileFunc) *Processor {
+	return NewReconcileProcessorWithOptions(toolName, name, informer, period, get, reconcile, nil)
+}
+
+// Nil options means defaults.
+func NewReconcileProcessorWithOptions(toolName string, name string, informer cache.SharedIndexInformer, period time.Duration, get GetControllerObjectFunc, reconcile ReconcileFunc, options *ProcessorOptions) *Processor {
+	self := NewProcessorWithOptions(toolName, name, informer, period, get, nil, options)
+	self.Reconcile = reconcile
+	return self
+}
+
+// Stops accepting work items and waits for the work items that are being
+// processed to finish.
+func (self *Processor) ShutDown() {
+	self.Workqueue.ShutDownWithDrain()
+}
+
 // cache.InformerSynced signature
 func (self *Processor) HasSynced() bool {
 	return self.Informer.HasSynced()
 }
 
 func (self *Processor) Start(concurrency uint, stopChannel <-chan struct{}) {
+	if self.Concurrency != 0 {
+		concurrency = self.Concurrency
+	}
+
 	var i uint
 	for i = 0; i < concurrency; i++ {
-		go wait.Until(self.worker, self.Period, stopChannel)
+		go wait.Until(func() {
+			self.worker(stopChannel)
+		}, self.Period, stopChannel)
+	}
+
+	if self.ResyncPeriod > 0 {
+		go self.resync(stopChannel)
 	}
 }
 
 func (self *Processor) EnqueueFor(object any) {
-	if key, err := cache.MetaNamespaceKeyFunc(object); err == nil {
-		self.Workqueue.Add(key)
+	// Supports tombstones
+	if name, err := cache.DeletionHandlingObjectToName(object); err == nil {
+		self.Workqueue.Add(ProcessorKey{GVK: self.GVK, Namespace: name.Namespace, Name: name.Name})
 	} else {
 		self.Log.Error(err.Error())
 	}
 }
 
-func (self *Processor) worker() {
-	for self.nextWorkItem() {
+func (self *Processor) onUpdated(old any, new any) {
+	predicates := self.Predicates
+	if len(predicates) == 0 {
+		predicates = []ProcessorPredicate{ResourceVersionChangedPredicate}
+	}
+
+	if DeletionChangedPredicate(old, new) {
+		self.EnqueueFor(new)
+		return
+	}
+
+	for _, predicate := range predicates {
+		if predicate(old, new) {
+			self.EnqueueFor(new)
+			return
+		}
 	}
 }
 
-func (self *Processor) nextWorkItem() bool {
+func (self *Processor) resync(stopChannel <-chan struct{}) {
+	ticker := time.NewTicker(self.ResyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			self.Log.Debugf("resyncing")
+			for _, object := range self.Informer.GetStore().List() {
+				self.EnqueueFor(object)
+			}
+
+		case <-stopChannel:
+			return
+		}
+	}
+}
+
+func (self *Processor) worker(stopChannel <-chan struct{}) {
+	for self.nextWorkItem(stopChannel) {
+	}
+}
+
+func (self *Processor) nextWorkItem(stopChannel <-chan struct{}) bool {
 	if item, shutdown := self.Workqueue.Get(); !shutdown {
 		defer self.Workqueue.Done(item)
+
+		select {
+		case <-stopChannel:
+			// We were stopped while waiting, so leave the item for the next
+			// worker (it will be requeued when we call Done)
+			self.Workqueue.Add(item)
+			return false
+		default:
+		}
+
 		self.processWorkItem(item)
 		return true
 	} else {
@@ -91,40 +226,157 @@ func (self *Processor) nextWorkItem() bool {
 	}
 }
 
-func (self *Processor) processWorkItem(item any) {
-	if key, ok := item.(string); ok {
-		if namespace, name, err := cache.SplitMetaNamespaceKey(key); err == nil {
-			if object, err := self.GetControllerObject(name, namespace); err == nil {
-				self.Log.Infof("processing work item %s/%s", namespace, name)
-				if finished, err := self.Process(object); finished {
-					self.Workqueue.Forget(item)
-					if err == nil {
-						self.Log.Infof("finished work item %s/%s", namespace, name)
-					} else {
-						self.Log.Errorf("finished work item %s/%s: %s", namespace, name, err.Error())
-					}
-				} else {
-					self.Workqueue.AddRateLimited(key)
-					if err == nil {
-						self.Log.Infof("requeuing unfinished work item (%d time) %s/%s", self.Workqueue.NumRequeues(key), namespace, name)
-					} else {
-						self.Log.Errorf("requeuing unfinished work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
-					}
-				}
-			} else if kuberneteserrors.IsNotFound(err) {
-				self.Workqueue.Forget(item)
-				self.Log.Infof("ignoring stale work item %s/%s", namespace, name)
+func (self *Processor) processWorkItem(key ProcessorKey) {
+	namespace := key.Namespace
+	name := key.Name
+	if object, err := self.GetControllerObject(name, namespace); err == nil {
+		self.processObject(key, namespace, name, object)
+	} else if kuberneteserrors.IsNotFound(err) {
+		self.processDeleted(key, namespace, name)
+	} else {
+		self.Workqueue.AddRateLimited(key)
+		self.Log.Errorf("requeuing failed work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
+	}
+}
+
+func (self *Processor) processObject(key ProcessorKey, namespace string, name string, object any) {
+	if self.Finalizer != "" {
+		metaObject, err := GetMetaObject(object, self.Log)
+		if err != nil {
+			self.Workqueue.Forget(key)
+			self.Log.Errorf("work item %s/%s: %s", namespace, name, err.Error())
+			return
+		}
+
+		if metaObject.GetDeletionTimestamp() != nil {
+			self.finalizeObject(key, namespace, name, object, metaObject)
+			return
+		}
+
+		if !hasFinalizer(metaObject, self.Finalizer) {
+			if object, err = self.updateFinalizers(object, func(finalizers []string) []string {
+				return append(finalizers, self.Finalizer)
+			}); err == nil {
+				self.Log.Infof("added finalizer %q to work item %s/%s", self.Finalizer, namespace, name)
 			} else {
 				self.Workqueue.AddRateLimited(key)
-				self.Log.Errorf("requeuing failed work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
+				self.Log.Errorf("requeuing work item (%d time) after failing to add finalizer %q %s/%s: %s", self.Workqueue.NumRequeues(key), self.Finalizer, namespace, name, err.Error())
+				return
 			}
+		}
+	}
+
+	self.Log.Infof("processing work item %s/%s", namespace, name)
+	result, err := self.reconcile(object)
+
+	if err != nil {
+		if IsPermanentError(err) {
+			self.Workqueue.Forget(key)
+			self.Log.Errorf("failed work item permanently %s/%s: %s", namespace, name, err.Error())
 		} else {
-			self.Workqueue.Forget(item)
-			self.Log.Errorf("work item in wrong format: %+v", key)
+			self.Workqueue.AddRateLimited(key)
+			self.Log.Errorf("requeuing failed work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
 		}
+	} else if result.RequeueAfter > 0 {
+		// Resets the rate limiting
+		self.Workqueue.Forget(key)
+		self.Workqueue.AddAfter(key, result.RequeueAfter)
+		self.Log.Infof("requeuing work item after %s %s/%s", result.RequeueAfter, namespace, name)
+	} else if result.Requeue {
+		self.Workqueue.AddRateLimited(key)
+		self.Log.Infof("requeuing unfinished work item (%d time) %s/%s", self.Workqueue.NumRequeues(key), namespace, name)
 	} else {
-		self.Workqueue.Forget(item)
-		self.Log.Errorf("work item not a string: %+v", item)
+		self.Workqueue.Forget(key)
+		self.Log.Infof("finished work item %s/%s", namespace, name)
+	}
+}
+
+func (self *Processor) reconcile(object any) (ProcessResult, error) {
+	if self.Reconcile != nil {
+		return self.Reconcile(object)
+	}
+
+	if finished, err := self.Process(object); finished {
+		if err == nil {
+			return ProcessDone, nil
+		} else {
+			return ProcessDone, NewPermanentError(err)
+		}
+	} else {
+		return ProcessRequeue, err
+	}
+}
+
+func (self *Processor) finalizeObject(key ProcessorKey, namespace string, name string, object any, metaObject meta.Object) {
+	if !hasFinalizer(metaObject, self.Finalizer) {
+		// Already finalized, waiting to be deleted
+		self.Workqueue.Forget(key)
+		return
+	}
+
+	self.Log.Infof("finalizing work item %s/%s", namespace, name)
+	if self.Finalize != nil {
+		if err := self.Finalize(object); err != nil {
+			self.Workqueue.AddRateLimited(key)
+			self.Log.Errorf("requeuing unfinalized work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
+			return
+		}
+	}
+
+	if _, err := self.updateFinalizers(object, func(finalizers []string) []string {
+		finalizers_ := make([]string, 0, len(finalizers))
+		for _, finalizer := range finalizers {
+			if finalizer != self.Finalizer {
+				finalizers_ = append(finalizers_, finalizer)
+			}
+		}
+		return finalizers_
+	}); err == nil {
+		self.Workqueue.Forget(key)
+		self.Log.Infof("finalized work item %s/%s", namespace, name)
+	} else if kuberneteserrors.IsNotFound(err) {
+		self.Workqueue.Forget(key)
+		self.Log.Infof("finalized work item %s/%s (already deleted)", namespace, name)
+	} else {
+		self.Workqueue.AddRateLimited(key)
+		self.Log.Errorf("requeuing work item (%d time) after failing to remove finalizer %q %s/%s: %s", self.Workqueue.NumRequeues(key), self.Finalizer, namespace, name, err.Error())
+	}
+}
+
+func (self *Processor) processDeleted(key ProcessorKey, namespace string, name string) {
+	if self.Deleted == nil {
+		self.Workqueue.Forget(key)
+		self.Log.Infof("ignoring deleted work item %s/%s", namespace, name)
+		return
+	}
+
+	if err := self.Deleted(name, namespace); err == nil {
+		self.Workqueue.Forget(key)
+		self.Log.Infof("processed deleted work item %s/%s", namespace, name)
+	} else {
+		self.Workqueue.AddRateLimited(key)
+		self.Log.Errorf("requeuing deleted work item (%d time) %s/%s: %s", self.Workqueue.NumRequeues(key), namespace, name, err.Error())
+	}
+}
+
+// Updates a copy of the object, because the original may be shared by the
+// informer's cache.
+func (self *Processor) updateFinalizers(object any, update func([]string) []string) (any, error) {
+	if self.UpdateControllerObject == nil {
+		return nil, fmt.Errorf("processor %q has a finalizer but no UpdateControllerObject", self.Name)
+	}
+
+	runtimeObject, ok := object.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("controller object does not support runtime.Object interface: %T", object)
+	}
+
+	object = runtimeObject.DeepCopyObject()
+	if metaObject, err := GetMetaObject(object, self.Log); err == nil {
+		metaObject.SetFinalizers(update(metaObject.GetFinalizers()))
+		return self.UpdateControllerObject(object)
+	} else {
+		return nil, err
 	}
 }
 
@@ -133,6 +385,9 @@ func (self *Processor) processWorkItem(item any) {
 //
 
 type Processors struct {
+	// When set, Start will start the processors only while we are the leader
+	LeaderElection *LeaderElection
+
 	processors     map[schema.GroupVersionKind]*Processor
 	controlledGvks map[schema.GroupVersionKind]struct{}
 
@@ -149,6 +404,9 @@ func NewProcessors(toolName string) *Processors {
 }
 
 func (self *Processors) Add(gvk schema.GroupVersionKind, processor *Processor) {
+	if processor.GVK.Empty() {
+		processor.GVK = gvk
+	}
 	self.processors[gvk] = processor
 }
 
@@ -161,16 +419,37 @@ func (self *Processors) Get(name string) (*Processor, bool) {
 	return nil, false
 }
 
+// If LeaderElection is set, the processors will be started whenever
+// leadership is acquired and stopped when it is lost. Workers will finish the
+// work item they are processing before stopping.
 func (self *Processors) Start(concurrency uint, stopChannel <-chan struct{}) {
+	if self.LeaderElection != nil {
+		go func() {
+			if err := self.LeaderElection.Run(wait.ContextForChannel(stopChannel), func(stopChannel <-chan struct{}) {
+				self.start(concurrency, stopChannel)
+			}); err != nil {
+				self.log.Errorf("leader election: %s", err.Error())
+			}
+		}()
+	} else {
+		self.start(concurrency, stopChannel)
+	}
+}
+
+func (self *Processors) start(concurrency uint, stopChannel <-chan struct{}) {
 	for _, processor := range self.processors {
 		processor.Start(concurrency, stopChannel)
 	}
 }
 
+// Shuts down all processors concurrently and waits for the work items that are
+// being processed to finish.
 func (self *Processors) ShutDown() {
+	var wait sync.WaitGroup
 	for _, processor := range self.processors {
-		processor.Workqueue.ShutDown()
+		wait.Go(processor.ShutDown)
 	}
+	wait.Wait()
 }
 
 func (self *Processors) HasSynced() []cache.InformerSynced {
@@ -225,3 +504,92 @@ func (self *Processors) onObjectChanged(object *unstructured.Unstructured) error
 		return err
 	}
 }
+
+//
+// ProcessorPredicate
+//
+
+// ([ProcessorPredicate] signature)
+func ResourceVersionChangedPredicate(old any, new any) bool {
+	if old_, new_, ok := getPredicateMetaObjects(old, new); ok {
+		return old_.GetResourceVersion() != new_.GetResourceVersion()
+	} else {
+		return true
+	}
+}
+
+// Note that changes to metadata and status do not change the generation.
+//
+// ([ProcessorPredicate] signature)
+func GenerationChangedPredicate(old any, new any) bool {
+	if old_, new_, ok := getPredicateMetaObjects(old, new); ok {
+		return old_.GetGeneration() != new_.GetGeneration()
+	} else {
+		return true
+	}
+}
+
+// ([ProcessorPredicate] signature)
+func LabelsChangedPredicate(old any, new any) bool {
+	if old_, new_, ok := getPredicateMetaObjects(old, new); ok {
+		return !maps.Equal(old_.GetLabels(), new_.GetLabels())
+	} else {
+		return true
+	}
+}
+
+// ([ProcessorPredicate] signature)
+func AnnotationsChangedPredicate(old any, new any) bool {
+	if old_, new_, ok := getPredicateMetaObjects(old, new); ok {
+		return !maps.Equal(old_.GetAnnotations(), new_.GetAnnotations())
+	} else {
+		return true
+	}
+}
+
+// True if the deletion timestamp or the finalizers changed.
+//
+// ([ProcessorPredicate] signature)
+func DeletionChangedPredicate(old any, new any) bool {
+	if old_, new_, ok := getPredicateMetaObjects(old, new); ok {
+		return !old_.GetDeletionTimestamp().Equal(new_.GetDeletionTimestamp()) || !slices.Equal(old_.GetFinalizers(), new_.GetFinalizers())
+	} else {
+		return true
+	}
+}
+
+// Utils
+
+func newProcessorWorkqueue(name string, options *ProcessorOptions) workqueue.TypedRateLimitingInterface[ProcessorKey] {
+	rateLimiting := DefaultProcessorRateLimiting
+	config := workqueue.TypedRateLimitingQueueConfig[ProcessorKey]{Name: name}
+
+	if options != nil {
+		if options.RateLimiting != nil {
+			rateLimiting = *options.RateLimiting
+		}
+		if options.Metrics != nil {
+			config.MetricsProvider = processorMetricsProvider{options.Metrics}
+		}
+	}
+
+	return workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiting.NewRateLimiter(), config)
+}
+
+func getPredicateMetaObjects(old any, new any) (meta.Object, meta.Object, bool) {
+	if old_, ok := old.(meta.Object); ok {
+		if new_, ok := new.(meta.Object); ok {
+			return old_, new_, true
+		}
+	}
+	return nil, nil, false
+}
+
+func hasFinalizer(metaObject meta.Object, finalizer string) bool {
+	for _, finalizer_ := range metaObject.GetFinalizers() {
+		if finalizer_ == finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProcessorFinalizer(t *testing.T) {
+	kubernetes := fake.NewClientset(&core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: "a", Namespace: "default"}})
+	configMaps := kubernetes.CoreV1().ConfigMaps("default")
+	informer := informers.NewSharedInformerFactory(kubernetes, 0).Core().V1().ConfigMaps().Informer()
+
+	var processed, finalized, deleted int
+	var finalizeError error
+
+	processor := NewProcessor("test", "configmap", informer, 0,
+		func(name string, namespace string) (any, error) {
+			return configMaps.Get(context.TODO(), name, meta.GetOptions{})
+		},
+		func(object any) (bool, error) {
+			processed++
+			return true, nil
+		})
+	processor.Finalizer = "test/finalizer"
+	processor.Finalize = func(object any) error {
+		finalized++
+		return finalizeError
+	}
+	processor.UpdateControllerObject = func(object any) (any, error) {
+		return configMaps.Update(context.TODO(), object.(*core.ConfigMap), meta.UpdateOptions{})
+	}
+	processor.Deleted = func(name string, namespace string) error {
+		deleted++
+		return nil
+	}
+
+	getFinalizers := func() []string {
+		if configMap, err := configMaps.Get(context.TODO(), "a", meta.GetOptions{}); err == nil {
+			return configMap.Finalizers
+		} else {
+			t.Fatal(err)
+			return nil
+		}
+	}
+
+	// First reconcile adds the finalizer
+	processor.processWorkItem(testProcessorKey)
+	if finalizers := getFinalizers(); (processed != 1) || (len(finalizers) != 1) || (finalizers[0] != "test/finalizer") {
+		t.Errorf("processed %d, finalizers: %v", processed, finalizers)
+	}
+
+	// Marked for deletion (the fake clientset would just delete it)
+	configMap, _ := configMaps.Get(context.TODO(), "a", meta.GetOptions{})
+	now := meta.Now()
+	configMap.DeletionTimestamp = &now
+	if _, err := configMaps.Update(context.TODO(), configMap, meta.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed finalization keeps the finalizer and requeues
+	finalizeError = errors.New("not yet")
+	processor.processWorkItem(testProcessorKey)
+	if finalizers := getFinalizers(); (finalized != 1) || (processed != 1) || (len(finalizers) != 1) {
+		t.Errorf("finalized %d, processed %d, finalizers: %v", finalized, processed, finalizers)
+	}
+	if requeues := processor.Workqueue.NumRequeues(testProcessorKey); requeues != 1 {
+		t.Errorf("requeues: %d", requeues)
+	}
+
+	// Successful finalization removes the finalizer
+	finalizeError = nil
+	processor.processWorkItem(testProcessorKey)
+	if finalizers := getFinalizers(); (finalized != 2) || (len(finalizers) != 0) {
+		t.Errorf("finalized %d, finalizers: %v", finalized, finalizers)
+	}
+	if requeues := processor.Workqueue.NumRequeues(testProcessorKey); requeues != 0 {
+		t.Errorf("requeues: %d", requeues)
+	}
+
+	if err := configMaps.Delete(context.TODO(), "a", meta.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	processor.processWorkItem(testProcessorKey)
+	if deleted != 1 {
+		t.Errorf("deleted: %d", deleted)
+	}
+}
+
+func TestProcessorPredicates(t *testing.T) {
+	kubernetes := fake.NewClientset()
+	informer := informers.NewSharedInformerFactory(kubernetes, 0).Core().V1().ConfigMaps().Informer()
+	processor := NewProcessor("test", "configmap", informer, 0, nil, nil)
+
+	old := &core.ConfigMap{ObjectMeta: meta.ObjectMeta{Name: "a", Namespace: "default", ResourceVersion: "1", Generation: 1}}
+
+	// Default skips no-op resyncs
+	processor.onUpdated(old, old.DeepCopy())
+	if length := processor.Workqueue.Len(); length != 0 {
+		t.Errorf("no-op resync enqueued: %d", length)
+	}
+
+	new := old.DeepCopy()
+	new.ResourceVersion = "2"
+	processor.onUpdated(old, new)
+	if length := processor.Workqueue.Len(); length != 1 {
+		t.Errorf("resource version change not enqueued: %d", length)
+	}
+	processor.Workqueue.Get()
+	processor.Workqueue.Done(testProcessorKey)
+
+	processor.Predicates = []ProcessorPredicate{GenerationChangedPredicate, LabelsChangedPredicate}
+	processor.onUpdated(old, new)
+	if length := processor.Workqueue.Len(); length != 0 {
+		t.Errorf("unchanged generation enqueued: %d", length)
+	}
+
+	new.Labels = map[string]string{"label": "value"}
+	processor.onUpdated(old, new)
+	if length := processor.Workqueue.Len(); length != 1 {
+		t.Errorf("label change not enqueued: %d", length)
+	}
+	processor.Workqueue.Get()
+	processor.Workqueue.Done(testProcessorKey)
+
+	// Deletion is always enqueued
+	new = old.DeepCopy()
+	now := meta.Now()
+	new.DeletionTimestamp = &now
+	processor.onUpdated(old, new)
+	if length := processor.Workqueue.Len(); length != 1 {
+		t.Errorf("deletion not enqueued: %d", length)
+	}
+}
+
+func TestProcessorReconcile(t *testing.T) {
+	kubernetes := fake.NewClientset()
+	informer := informers.NewSharedInformerFactory(kubernetes, 0).Core().V1().ConfigMaps().Informer()
+
+	var result ProcessResult
+	var err error
+	metrics := new(testProcessorMetrics)
+	processor := NewReconcileProcessorWithOptions("test", "configmap", informer, 0,
+		func(name string, namespace string) (any, error) {
+			return name, nil
+		},
+		func(object any) (ProcessResult, error) {
+			return result, err
+		}, &ProcessorOptions{Metrics: metrics})
+
+	next := func() {
+		item, _ := processor.Workqueue.Get()
+		processor.processWorkItem(item)
+		processor.Workqueue.Done(item)
+	}
+
+	// Requeue after a delay
+	result = ProcessRequeueAfter(50 * time.Millisecond)
+	processor.Workqueue.Add(testProcessorKey)
+	next()
+	if length := processor.Workqueue.Len(); length != 0 {
+		t.Errorf("requeued too soon: %d", length)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if length := processor.Workqueue.Len(); length != 1 {
+		t.Errorf("not requeued: %d", length)
+	}
+
+	// Rate limited
+	result = ProcessRequeue
+	next()
+	if requeues := processor.Workqueue.NumRequeues(testProcessorKey); requeues != 1 {
+		t.Errorf("requeues: %d", requeues)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Permanent errors are not requeued
+	result = ProcessDone
+	err = NewPermanentError(errors.New("permanent"))
+	next()
+	if requeues := processor.Workqueue.NumRequeues(testProcessorKey); requeues != 0 {
+		t.Errorf("requeues: %d", requeues)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if length := processor.Workqueue.Len(); length != 0 {
+		t.Errorf("requeued: %d", length)
+	}
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	if (metrics.added != 3) || (metrics.retried != 2) || (metrics.processed != 3) || (metrics.depth != 0) {
+		t.Errorf("metrics: %+v", metrics)
+	}
+}
+
+type testProcessorMetrics struct {
+	depth, added, retried, processed int
+	lock                             sync.Mutex
+}
+
+func (self *testProcessorMetrics) Depth(processor string, delta int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.depth += delta
+}
+
+func (self *testProcessorMetrics) Added(processor string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.added++
+}
+
+func (self *testProcessorMetrics) Retried(processor string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.retried++
+}
+
+func (self *testProcessorMetrics) QueueLatency(processor string, duration time.Duration) {
+}
+
+func (self *testProcessorMetrics) ProcessingDuration(processor string, duration time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.processed++
+}
+
+var testProcessorKey = ProcessorKey{Namespace: "default", Name: "a"}
+
+func TestProcessorShutDown(t *testing.T) {
+	kubernetes := fake.NewClientset()
+	informer := informers.NewSharedInformerFactory(kubernetes, 0).Core().V1().ConfigMaps().Informer()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	processor := NewProcessorWithOptions("test", "configmap", informer, 0,
+		func(name string, namespace string) (any, error) {
+			return name, nil
+		},
+		func(object any) (bool, error) {
+			close(started)
+			<-release
+			return true, nil
+		}, &ProcessorOptions{RateLimiting: &ProcessorRateLimiting{BaseDelay: time.Hour, MaxDelay: time.Hour}})
+
+	processor.Workqueue.AddRateLimited(ProcessorKey{Name: "delayed"})
+	processor.Workqueue.Add(testProcessorKey)
+
+	stopChannel := make(chan struct{})
+	defer close(stopChannel)
+	processor.Start(1, stopChannel)
+	<-started
+
+	shutDown := make(chan struct{})
+	go func() {
+		processor.ShutDown()
+		close(shutDown)
+	}()
+
+	select {
+	case <-shutDown:
+		t.Fatal("did not wait for the work item")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-shutDown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not shut down")
+	}
+}
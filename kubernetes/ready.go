@@ -0,0 +1,247 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Conditions that [IsReady] checks for kinds that it doesn't know specifically.
+var StandardReadyConditions = []string{"Ready", "Available", "Established"}
+
+// Returns true if the object is ready according to its kind:
+//
+//   - Deployment: the rollout is complete and all updated replicas are available
+//   - StatefulSet: all replicas are ready and (for rolling updates) updated
+//   - DaemonSet: all scheduled pods are available and (for rolling updates) updated
+//   - Job: the Complete condition is true
+//   - Pod: the Ready condition is true, or the pod has succeeded
+//   - Other kinds: if the status has an observedGeneration it must be current,
+//     and those of the [StandardReadyConditions] that the status has must all
+//     be true (none may be false or unknown); objects without these are ready
+//     as soon as they exist
+//
+// Returns an error if the object can never become ready, e.g. a failed Job or
+// Pod, or a Deployment that exceeded its progress deadline.
+func IsReady(object *unstructured.Unstructured) (bool, error) {
+	gvk := object.GroupVersionKind()
+
+	switch gvk.GroupKind() {
+	case apps.SchemeGroupVersion.WithKind("Deployment").GroupKind():
+		var deployment apps.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &deployment); err == nil {
+			return isDeploymentReady(&deployment)
+		} else {
+			return false, err
+		}
+
+	case apps.SchemeGroupVersion.WithKind("StatefulSet").GroupKind():
+		var statefulSet apps.StatefulSet
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &statefulSet); err == nil {
+			return isStatefulSetReady(&statefulSet), nil
+		} else {
+			return false, err
+		}
+
+	case apps.SchemeGroupVersion.WithKind("DaemonSet").GroupKind():
+		var daemonSet apps.DaemonSet
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &daemonSet); err == nil {
+			return isDaemonSetReady(&daemonSet), nil
+		} else {
+			return false, err
+		}
+
+	case batch.SchemeGroupVersion.WithKind("Job").GroupKind():
+		var job batch.Job
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &job); err == nil {
+			return isJobReady(&job)
+		} else {
+			return false, err
+		}
+
+	case core.SchemeGroupVersion.WithKind("Pod").GroupKind():
+		var pod core.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &pod); err == nil {
+			return isPodReadyOrSucceeded(&pod)
+		} else {
+			return false, err
+		}
+	}
+
+	if observedGeneration, ok, _ := unstructured.NestedInt64(object.Object, "status", "observedGeneration"); ok && (observedGeneration < object.GetGeneration()) {
+		return false, nil
+	}
+
+	for _, type_ := range StandardReadyConditions {
+		if status, ok := GetUnstructuredCondition(object, type_); ok && (status != "True") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Returns the status of the condition of the type in "status.conditions".
+func GetUnstructuredCondition(object *unstructured.Unstructured, type_ string) (string, bool) {
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, condition := range conditions {
+		if condition_, ok := condition.(map[string]any); ok && (condition_["type"] == type_) {
+			status, _ := condition_["status"].(string)
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// Returns a condition that is true when all the condition types have status
+// "True".
+func NewConditionsReadyFunc(types ...string) UnstructuredConditionFunc {
+	return func(object *unstructured.Unstructured) (bool, error) {
+		for _, type_ := range types {
+			if status, _ := GetUnstructuredCondition(object, type_); status != "True" {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// Returns a condition that is true when the JSONPath expression (with or
+// without the enclosing braces, e.g. "{.status.phase}" or ".status.phase")
+// matches the value. An empty value matches any non-empty result.
+func NewJSONPathReadyFunc(expression string, value string) (UnstructuredConditionFunc, error) {
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+
+	parser := jsonpath.New("ready").AllowMissingKeys(true)
+	if err := parser.Parse(expression); err != nil {
+		return nil, fmt.Errorf("JSONPath %q: %w", expression, err)
+	}
+
+	return func(object *unstructured.Unstructured) (bool, error) {
+		results, err := parser.FindResults(object.Object)
+		if err != nil {
+			return false, nil
+		}
+
+		for _, result := range results {
+			for _, value_ := range result {
+				if !value_.IsValid() {
+					continue
+				}
+
+				value_ := fmt.Sprintf("%v", value_.Interface())
+				if value == "" {
+					if value_ != "" {
+						return true, nil
+					}
+				} else if value_ == value {
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	}, nil
+}
+
+// Utils
+
+func isDeploymentReady(deployment *apps.Deployment) (bool, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, nil
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if (condition.Type == apps.DeploymentProgressing) && (condition.Status == core.ConditionFalse) && (condition.Reason == "ProgressDeadlineExceeded") {
+			return false, fmt.Errorf("deployment %s/%s exceeded its progress deadline: %s", deployment.Namespace, deployment.Name, condition.Message)
+		}
+	}
+
+	replicas := getReplicas(deployment.Spec.Replicas)
+	status := deployment.Status
+	// Old replicas must be gone, too
+	return (status.UpdatedReplicas >= replicas) && (status.Replicas <= status.UpdatedReplicas) && (status.AvailableReplicas >= status.UpdatedReplicas), nil
+}
+
+func isStatefulSetReady(statefulSet *apps.StatefulSet) bool {
+	if statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return false
+	}
+
+	replicas := getReplicas(statefulSet.Spec.Replicas)
+	if statefulSet.Status.ReadyReplicas < replicas {
+		return false
+	}
+
+	if statefulSet.Spec.UpdateStrategy.Type == apps.OnDeleteStatefulSetStrategyType {
+		return true
+	}
+
+	var partition int32
+	if (statefulSet.Spec.UpdateStrategy.RollingUpdate != nil) && (statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil) {
+		partition = *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+	}
+
+	if statefulSet.Status.UpdatedReplicas < replicas-partition {
+		return false
+	}
+
+	// With a partition the current revision is never updated
+	return (partition > 0) || (statefulSet.Status.CurrentRevision == statefulSet.Status.UpdateRevision)
+}
+
+func isDaemonSetReady(daemonSet *apps.DaemonSet) bool {
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
+		return false
+	}
+
+	desired := daemonSet.Status.DesiredNumberScheduled
+	if (daemonSet.Spec.UpdateStrategy.Type != apps.OnDeleteDaemonSetStrategyType) && (daemonSet.Status.UpdatedNumberScheduled < desired) {
+		return false
+	}
+
+	return daemonSet.Status.NumberAvailable >= desired
+}
+
+func isJobReady(job *batch.Job) (bool, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status == core.ConditionTrue {
+			switch condition.Type {
+			case batch.JobComplete:
+				return true, nil
+
+			case batch.JobFailed:
+				return false, fmt.Errorf("job %s/%s failed: %s", job.Namespace, job.Name, condition.Message)
+			}
+		}
+	}
+	return false, nil
+}
+
+func isPodReadyOrSucceeded(pod *core.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case core.PodSucceeded:
+		return true, nil
+
+	case core.PodFailed:
+		return false, fmt.Errorf("pod %s/%s failed: %s", pod.Namespace, pod.Name, pod.Status.Message)
+	}
+
+	return IsPodReady(pod), nil
+}
+
+func getReplicas(replicas *int32) int32 {
+	if replicas != nil {
+		return *replicas
+	} else {
+		return 1
+	}
+}
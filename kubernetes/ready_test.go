@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestIsReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		object *unstructured.Unstructured
+		ready  bool
+		err    bool
+	}{
+		{"deployment available", newTestReadyObject("apps/v1", "Deployment", map[string]any{"replicas": int64(2)}, map[string]any{
+			"observedGeneration": int64(1), "replicas": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2),
+		}), true, false},
+		{"deployment old replicas", newTestReadyObject("apps/v1", "Deployment", map[string]any{"replicas": int64(2)}, map[string]any{
+			"observedGeneration": int64(1), "replicas": int64(3), "updatedReplicas": int64(2), "availableReplicas": int64(3),
+		}), false, false},
+		{"deployment not observed", newTestReadyObject("apps/v1", "Deployment", nil, map[string]any{
+			"replicas": int64(1), "updatedReplicas": int64(1), "availableReplicas": int64(1),
+		}), false, false},
+		{"deployment deadline", newTestReadyObject("apps/v1", "Deployment", nil, map[string]any{
+			"observedGeneration": int64(1),
+			"conditions":         []any{map[string]any{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"}},
+		}), false, true},
+		{"statefulset rolling", newTestReadyObject("apps/v1", "StatefulSet", map[string]any{"replicas": int64(2)}, map[string]any{
+			"observedGeneration": int64(1), "readyReplicas": int64(2), "updatedReplicas": int64(2), "currentRevision": "a", "updateRevision": "b",
+		}), false, false},
+		{"statefulset partition", newTestReadyObject("apps/v1", "StatefulSet", map[string]any{
+			"replicas":       int64(3),
+			"updateStrategy": map[string]any{"type": "RollingUpdate", "rollingUpdate": map[string]any{"partition": int64(2)}},
+		}, map[string]any{
+			"observedGeneration": int64(1), "readyReplicas": int64(3), "updatedReplicas": int64(1), "currentRevision": "a", "updateRevision": "b",
+		}), true, false},
+		{"statefulset on delete", newTestReadyObject("apps/v1", "StatefulSet", map[string]any{"updateStrategy": map[string]any{"type": "OnDelete"}}, map[string]any{
+			"observedGeneration": int64(1), "readyReplicas": int64(1),
+		}), true, false},
+		{"daemonset updating", newTestReadyObject("apps/v1", "DaemonSet", nil, map[string]any{
+			"observedGeneration": int64(1), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(2), "numberAvailable": int64(3),
+		}), false, false},
+		{"daemonset available", newTestReadyObject("apps/v1", "DaemonSet", nil, map[string]any{
+			"observedGeneration": int64(1), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(3),
+		}), true, false},
+		{"job complete", newTestReadyObject("batch/v1", "Job", nil, map[string]any{
+			"conditions": []any{map[string]any{"type": "Complete", "status": "True"}},
+		}), true, false},
+		{"job failed", newTestReadyObject("batch/v1", "Job", nil, map[string]any{
+			"conditions": []any{map[string]any{"type": "Failed", "status": "True"}},
+		}), false, true},
+		{"pod ready", newTestReadyObject("v1", "Pod", nil, map[string]any{
+			"phase": "Running", "conditions": []any{map[string]any{"type": "Ready", "status": "True"}},
+		}), true, false},
+		{"pod failed", newTestReadyObject("v1", "Pod", nil, map[string]any{"phase": "Failed"}), false, true},
+		{"established", newTestReadyObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", nil, map[string]any{
+			"conditions": []any{map[string]any{"type": "NamesAccepted", "status": "True"}, map[string]any{"type": "Established", "status": "False"}},
+		}), false, false},
+		{"some conditions", newTestReadyObject("example.com/v1", "Widget", nil, map[string]any{
+			"conditions": []any{map[string]any{"type": "Ready", "status": "True"}, map[string]any{"type": "Available", "status": "False"}},
+		}), false, false},
+		{"all conditions", newTestReadyObject("example.com/v1", "Widget", nil, map[string]any{
+			"conditions": []any{map[string]any{"type": "Ready", "status": "True"}, map[string]any{"type": "Available", "status": "True"}},
+		}), true, false},
+		{"no conditions", newTestConfigMap("a", nil), true, false},
+	}
+
+	for _, test := range tests {
+		ready, err := IsReady(test.object)
+		if (ready != test.ready) || ((err != nil) != test.err) {
+			t.Errorf("%s: %v, %v", test.name, ready, err)
+		}
+	}
+
+	phase, err := NewJSONPathReadyFunc(".status.phase", "Running")
+	if err != nil {
+		t.Fatalf("NewJSONPathReadyFunc: %s", err.Error())
+	}
+	if ready, _ := phase(newTestReadyObject("v1", "Pod", nil, map[string]any{"phase": "Pending"})); ready {
+		t.Errorf("JSONPath: ready")
+	}
+	if ready, _ := phase(newTestReadyObject("v1", "Pod", nil, map[string]any{"phase": "Running"})); !ready {
+		t.Errorf("JSONPath: not ready")
+	}
+	if _, err := NewJSONPathReadyFunc("{.status[", ""); err == nil {
+		t.Errorf("JSONPath: no error")
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	dynamic := newTestDynamic()
+	discovery := dynamic.Discovery.(*fakediscovery.FakeDiscovery)
+	discovery.Resources = append(discovery.Resources, &meta.APIResourceList{
+		GroupVersion: "apps/v1",
+		APIResources: []meta.APIResource{{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: meta.Verbs{"get", "list", "watch"}}},
+	})
+	fake := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, map[schema.GroupVersionResource]string{gvr: "DeploymentList"})
+	dynamic.Dynamic = fake
+
+	// Does not exist yet
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		deployment := newTestReadyObject("apps/v1", "Deployment", nil, map[string]any{"observedGeneration": int64(1)})
+		fake.Tracker().Create(gvr, deployment, "default")
+
+		time.Sleep(100 * time.Millisecond)
+		deployment = deployment.DeepCopy()
+		unstructured.SetNestedField(deployment.Object, map[string]any{
+			"observedGeneration": int64(1), "replicas": int64(1), "updatedReplicas": int64(1), "availableReplicas": int64(1),
+		}, "status")
+		fake.Tracker().Update(gvr, deployment, "default")
+	}()
+
+	if object, err := dynamic.WaitForReady(gvk, "a", "default", &ReadyOptions{WaitOptions: WaitOptions{Timeout: 5 * time.Second}}); err == nil {
+		if replicas, _, _ := unstructured.NestedInt64(object.Object, "status", "availableReplicas"); replicas != 1 {
+			t.Errorf("WaitForReady: %d", replicas)
+		}
+	} else {
+		t.Fatalf("WaitForReady: %s", err.Error())
+	}
+
+	// Never becomes true
+	if _, err := dynamic.WaitForReady(gvk, "a", "default", &ReadyOptions{
+		WaitOptions:   WaitOptions{Timeout: 200 * time.Millisecond},
+		JSONPath:      "{.status.replicas}",
+		JSONPathValue: "2",
+	}); err == nil {
+		t.Errorf("WaitForReady: no timeout")
+	}
+}
+
+func newTestReadyObject(apiVersion string, kind string, spec map[string]any, status map[string]any) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name":       "a",
+			"namespace":  "default",
+			"generation": int64(1),
+		},
+	}}
+	if spec != nil {
+		object.Object["spec"] = spec
+	}
+	if status != nil {
+		object.Object["status"] = status
+	}
+	return object
+}
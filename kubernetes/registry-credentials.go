@@ -1,8 +1,12 @@
 package kubernetes
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/tliron/go-ard"
 	"github.com/tliron/go-kutil/util"
 	"github.com/tliron/go-transcribe"
@@ -16,6 +20,13 @@ import (
 type RegistryCredentials struct {
 	Username string
 	Password string
+
+	// OAuth2 refresh token that the client exchanges for an access token;
+	// used instead of the username and password
+	IdentityToken string
+
+	// Bearer token that is sent to the registry as is
+	RegistryToken string
 }
 
 func NewRegistryCredentials(username string, password string) *RegistryCredentials {
@@ -25,24 +36,50 @@ func NewRegistryCredentials(username string, password string) *RegistryCredentia
 	}
 }
 
+// Supports the fields of Docker's config.json entries: "username",
+// "password", "auth" (which takes precedence over "username" and "password"),
+// "identitytoken", and "registrytoken".
 func NewRegistryCredentialsFromARD(value ard.Value) (*RegistryCredentials, error) {
 	value_ := ard.With(value)
-	if username, ok := value_.Get("username").String(); ok {
-		var self RegistryCredentials
-		self.Username = username
-		if password, ok := value_.Get("password").String(); ok {
-			self.Password = password
-			return &self, nil
+
+	var self RegistryCredentials
+	self.Username, _ = value_.Get("username").String()
+	self.Password, _ = value_.Get("password").String()
+	self.IdentityToken, _ = value_.Get("identitytoken").String()
+	self.RegistryToken, _ = value_.Get("registrytoken").String()
+
+	if auth, ok := value_.Get("auth").String(); ok && (auth != "") {
+		var err error
+		if self.Username, self.Password, err = ParseRegistryCredentialsAuth(auth); err != nil {
+			return nil, err
 		}
 	}
-	return nil, fmt.Errorf("malformed registry credentials: %s", value)
+
+	if self.IsEmpty() {
+		return nil, fmt.Errorf("malformed registry credentials: %s", value)
+	}
+
+	return &self, nil
+}
+
+func (self *RegistryCredentials) IsEmpty() bool {
+	return (self.Username == "") && (self.Password == "") && (self.IdentityToken == "") && (self.RegistryToken == "")
 }
 
 func (self *RegistryCredentials) ToARD() ard.Value {
-	return ard.StringMap{
-		"username": self.Username,
-		"password": self.Password,
+	map_ := make(ard.StringMap)
+	if (self.Username != "") || (self.Password != "") {
+		map_["username"] = self.Username
+		map_["password"] = self.Password
+		map_["auth"] = RegistryCredentialsAuth(self.Username, self.Password)
 	}
+	if self.IdentityToken != "" {
+		map_["identitytoken"] = self.IdentityToken
+	}
+	if self.RegistryToken != "" {
+		map_["registrytoken"] = self.RegistryToken
+	}
+	return map_
 }
 
 //
@@ -51,11 +88,17 @@ func (self *RegistryCredentials) ToARD() ard.Value {
 
 type RegistryCredentialsTable map[string]*RegistryCredentials
 
+// Entries without credentials (e.g. the empty entries Docker writes when it
+// uses a credentials store) are skipped.
 func NewRegistryCredentialsTableFromARD(value ard.Value) (RegistryCredentialsTable, error) {
-	if map_, ok := value.(ard.Map); ok {
+	if map_, ok := ard.With(value).ConvertSimilar().Map(); ok {
 		self := make(RegistryCredentialsTable)
 		for server, credentials := range map_ {
 			if server_, ok := server.(string); ok {
+				if credentials_, ok := ard.With(credentials).ConvertSimilar().Map(); ok && (len(credentials_) == 0) {
+					continue
+				}
+
 				var err error
 				if self[server_], err = NewRegistryCredentialsFromARD(credentials); err != nil {
 					return nil, err
@@ -71,35 +114,57 @@ func NewRegistryCredentialsTableFromARD(value ard.Value) (RegistryCredentialsTab
 }
 
 func NewRegistryCredentialsTableFromSecret(secret *core.Secret) (RegistryCredentialsTable, error) {
-	switch secret.Type {
-	case core.SecretTypeDockerConfigJson:
-		if data, ok := secret.Data[core.DockerConfigJsonKey]; ok {
-			if value, err := ard.DecodeJSON(data, false); err == nil {
-				if auths := ard.With(value).Get("auths").Value; auths != nil {
-					return NewRegistryCredentialsTableFromARD(auths)
-				} else {
-					return nil, fmt.Errorf("malformed %q secret: %s", core.SecretTypeDockerConfigJson, value)
-				}
-			} else {
-				return nil, err
-			}
+	if dockerConfig, err := NewDockerConfigFromSecret(secret); err == nil {
+		if dockerConfig != nil {
+			return dockerConfig.Auths, nil
 		} else {
-			return nil, fmt.Errorf("malformed %q secret: %s", core.SecretTypeDockerConfigJson, secret.Data)
+			return nil, nil
 		}
+	} else {
+		return nil, err
+	}
+}
 
-	case core.SecretTypeDockercfg:
-		if data, ok := secret.Data[core.DockerConfigKey]; ok {
-			if value, err := ard.DecodeJSON(data, false); err == nil {
-				return NewRegistryCredentialsTableFromARD(value)
-			} else {
-				return nil, err
+// Returns the credentials for the registry host, which may also include a
+// repository path (as supported by Podman), in which case the most specific
+// entry is returned. Hosts are normalized with [NormalizeRegistryHost].
+//
+// Returns nil if not found.
+func (self RegistryCredentialsTable) Get(host string) *RegistryCredentials {
+	if credentials, ok := self[host]; ok {
+		return credentials
+	}
+
+	key := NormalizeRegistryHost(host)
+	var found *RegistryCredentials
+	var foundLength int
+	for server, credentials := range self {
+		server = NormalizeRegistryHost(server)
+		if (key == server) || strings.HasPrefix(key, server+"/") {
+			if len(server) > foundLength {
+				found = credentials
+				foundLength = len(server)
 			}
-		} else {
-			return nil, fmt.Errorf("malformed %q secret: %s", core.SecretTypeDockercfg, secret.Data)
 		}
 	}
+	return found
+}
 
-	return nil, nil
+// Returns the credentials for the resource's repository (see
+// [RegistryCredentialsTable.Get]), or anonymous if not found.
+//
+// ([authn.Keychain] interface)
+func (self RegistryCredentialsTable) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	if credentials := self.Get(resource.String()); credentials != nil {
+		return authn.FromConfig(authn.AuthConfig{
+			Username:      credentials.Username,
+			Password:      credentials.Password,
+			IdentityToken: credentials.IdentityToken,
+			RegistryToken: credentials.RegistryToken,
+		}), nil
+	} else {
+		return authn.Anonymous, nil
+	}
 }
 
 func (self RegistryCredentialsTable) ToARD() ard.Value {
@@ -131,6 +196,61 @@ func (self RegistryCredentialsTable) ToSecret(secret *core.Secret) error {
 
 // Utils
 
+// Docker Hub is known by several names.
+var dockerHubHosts = []string{
+	"docker.io",
+	"index.docker.io",
+	"registry-1.docker.io",
+	"registry.hub.docker.com",
+}
+
+// Normalizes registry hosts as they appear in Docker and Podman configs and
+// in image references, so that they can be compared:
+//
+//   - The scheme and the API version path ("/v1/" or "/v2/") are removed
+//   - The default HTTPS port (443) is removed
+//   - All Docker Hub aliases become "docker.io"
+//
+// Other paths are kept, as Podman allows entries per repository, e.g.
+// "quay.io/organization".
+func NormalizeRegistryHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host = strings.TrimSuffix(host, "/")
+
+	host, path, _ := strings.Cut(host, "/")
+	switch path {
+	case "v1", "v2":
+		path = ""
+	}
+
+	host = strings.TrimSuffix(host, ":443")
+	if slices.Contains(dockerHubHosts, host) {
+		host = dockerHubHosts[0]
+	}
+
+	if path != "" {
+		return host + "/" + path
+	} else {
+		return host
+	}
+}
+
+// Decodes the "auth" field of Docker's config.json entries.
+func ParseRegistryCredentialsAuth(auth string) (string, string, error) {
+	if bytes, err := util.FromBase64(auth); err == nil {
+		if username, password, ok := strings.Cut(util.BytesToString(bytes), ":"); ok {
+			return username, password, nil
+		} else {
+			return "", "", errors.New("malformed registry credentials auth")
+		}
+	} else {
+		return "", "", fmt.Errorf("malformed registry credentials auth: %w", err)
+	}
+}
+
+// Encodes the "auth" field of Docker's config.json entries.
 func RegistryCredentialsAuth(username string, password string) string {
 	// See: https://github.com/kubernetes/kubectl/blob/3874cf79897cfe1e070e592391792658c44b78d4/pkg/generate/versioned/secret_for_docker_registry.go#L166
 	auth := fmt.Sprintf("%s:%s", username, password)
@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+)
+
+const testDockerConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1YnBhc3M="},
+		"quay.io": {"username": "quay", "password": "quaypass"},
+		"quay.io/organization": {"identitytoken": "token"},
+		"localhost:5000": {"auth": "bG9jYWw6bG9jYWxwYXNz"},
+		"store.example.com": {}
+	},
+	"credHelpers": {
+		"helper.example.com": "test"
+	}
+}`
+
+const testDockercfg = `{
+	"legacy.example.com": {"auth": "bGVnYWN5OmxlZ2FjeXBhc3M=", "email": "legacy@example.com"}
+}`
+
+const testDockerCredentialHelper = `#!/bin/sh
+read server
+case "$server" in
+	https://index.docker.io/v1/) echo '{"Username":"<token>","Secret":"refresh"}' ;;
+	helper.example.com|store.example.com) echo '{"Username":"helper","Secret":"helperpass"}' ;;
+	*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+
+func TestDockerConfig(t *testing.T) {
+	dockerConfig, err := NewDockerConfigFromJSON([]byte(testDockerConfig))
+	if err != nil {
+		t.Fatalf("NewDockerConfigFromJSON: %s", err.Error())
+	}
+
+	// The empty entry is skipped
+	if len(dockerConfig.Auths) != 4 {
+		t.Errorf("auths: %d", len(dockerConfig.Auths))
+	}
+
+	tests := []struct {
+		image    string
+		username string
+		password string
+		token    string
+	}{
+		{"nginx", "hub", "hubpass", ""},
+		{"docker.io/library/nginx:1.29", "hub", "hubpass", ""},
+		{"registry-1.docker.io/user/image", "hub", "hubpass", ""},
+		{"quay.io/other/image", "quay", "quaypass", ""},
+		{"quay.io/organization/image@sha256:0000000000000000000000000000000000000000000000000000000000000000", "", "", "token"},
+		{"QUAY.io:443/other/image", "quay", "quaypass", ""},
+		{"localhost:5000/image", "local", "localpass", ""},
+		{"localhost:5001/image", "", "", ""},
+	}
+
+	for _, test := range tests {
+		credentials, err := dockerConfig.GetCredentialsForImage(test.image)
+		if err != nil {
+			t.Errorf("%s: %s", test.image, err.Error())
+			continue
+		}
+
+		if test.username == "" && test.token == "" {
+			if credentials != nil {
+				t.Errorf("%s: %+v", test.image, credentials)
+			}
+		} else if (credentials == nil) || (credentials.Username != test.username) || (credentials.Password != test.password) || (credentials.IdentityToken != test.token) {
+			t.Errorf("%s: %+v", test.image, credentials)
+		}
+	}
+
+	// Legacy
+	legacy, err := NewDockerConfigFromJSON([]byte(testDockercfg))
+	if err != nil {
+		t.Fatalf("NewDockerConfigFromJSON: %s", err.Error())
+	}
+	if credentials := legacy.Auths.Get("legacy.example.com"); (credentials == nil) || (credentials.Username != "legacy") || (credentials.Password != "legacypass") {
+		t.Errorf("legacy: %+v", credentials)
+	}
+
+	// Earlier configs take precedence
+	legacy.Auths["quay.io"] = NewRegistryCredentials("other", "otherpass")
+	dockerConfig.Merge(legacy)
+	if credentials := dockerConfig.Auths.Get("quay.io"); credentials.Username != "quay" {
+		t.Errorf("Merge: %+v", credentials)
+	}
+	if credentials := dockerConfig.Auths.Get("legacy.example.com"); credentials == nil {
+		t.Errorf("Merge: no legacy")
+	}
+}
+
+func TestDockerConfigCredentialHelpers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, DockerCredentialHelperPrefix+"test"), []byte(testDockerCredentialHelper), 0700); err != nil {
+		t.Fatalf("WriteFile: %s", err.Error())
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dockerConfig, err := NewDockerConfigFromJSON([]byte(testDockerConfig))
+	if err != nil {
+		t.Fatalf("NewDockerConfigFromJSON: %s", err.Error())
+	}
+
+	if credentials, err := dockerConfig.GetCredentials("helper.example.com"); (err != nil) || (credentials == nil) || (credentials.Username != "helper") {
+		t.Errorf("credHelpers: %+v, %v", credentials, err)
+	}
+
+	dockerConfig.CredsStore = "test"
+
+	if credentials, err := dockerConfig.GetCredentialsForImage("nginx"); (err != nil) || (credentials == nil) || (credentials.IdentityToken != "refresh") {
+		t.Errorf("credsStore: %+v, %v", credentials, err)
+	}
+
+	if credentials, err := dockerConfig.GetCredentials("store.example.com"); (err != nil) || (credentials == nil) || (credentials.Password != "helperpass") {
+		t.Errorf("credsStore: %+v, %v", credentials, err)
+	}
+
+	// Not in the store, so falls back to auths
+	if credentials, err := dockerConfig.GetCredentials("quay.io"); (err != nil) || (credentials == nil) || (credentials.Username != "quay") {
+		t.Errorf("credsStore: %+v, %v", credentials, err)
+	}
+
+	// The helpers of a lower-precedence config must not override our stored
+	// credentials
+	higher := NewDockerConfig()
+	higher.Auths["helper.example.com"] = NewRegistryCredentials("higher", "higherpass")
+	higher.Auths["store.example.com"] = NewRegistryCredentials("higher", "higherpass")
+	higher.Merge(dockerConfig)
+
+	for _, host := range []string{"helper.example.com", "store.example.com"} {
+		if credentials, err := higher.GetCredentials(host); (err != nil) || (credentials == nil) || (credentials.Username != "higher") {
+			t.Errorf("Merge: %s: %+v, %v", host, credentials, err)
+		}
+	}
+
+	if credentials, err := higher.GetCredentialsForImage("nginx"); (err != nil) || (credentials == nil) || (credentials.IdentityToken != "refresh") {
+		t.Errorf("Merge: credsStore: %+v, %v", credentials, err)
+	}
+}
+
+func TestLoadDefaultDockerConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	authFile := filepath.Join(home, "auth.json")
+	t.Setenv("REGISTRY_AUTH_FILE", authFile)
+	os.WriteFile(authFile, []byte(`{"auths": {"quay.io": {"username": "podman", "password": "podmanpass"}}}`), 0600)
+
+	os.Mkdir(filepath.Join(home, ".docker"), 0700)
+	os.WriteFile(filepath.Join(home, ".docker", "config.json"), []byte(testDockerConfig), 0600)
+	os.WriteFile(filepath.Join(home, ".dockercfg"), []byte(testDockercfg), 0600)
+
+	dockerConfig, err := LoadDefaultDockerConfig()
+	if err != nil {
+		t.Fatalf("LoadDefaultDockerConfig: %s", err.Error())
+	}
+
+	if credentials := dockerConfig.Auths.Get("quay.io"); (credentials == nil) || (credentials.Username != "podman") {
+		t.Errorf("podman: %+v", credentials)
+	}
+	if credentials := dockerConfig.Auths.Get("docker.io"); (credentials == nil) || (credentials.Username != "hub") {
+		t.Errorf("docker: %+v", credentials)
+	}
+	if credentials := dockerConfig.Auths.Get("legacy.example.com"); credentials == nil {
+		t.Errorf("legacy: no credentials")
+	}
+}
+
+func TestRegistryCredentialsTableSecret(t *testing.T) {
+	table := RegistryCredentialsTable{
+		"localhost:5000": NewRegistryCredentials("user", "pass"),
+		"quay.io":        {IdentityToken: "token"},
+	}
+
+	var secret core.Secret
+	if err := table.ToSecret(&secret); err != nil {
+		t.Fatalf("ToSecret: %s", err.Error())
+	}
+
+	table, err := NewRegistryCredentialsTableFromSecret(&secret)
+	if err != nil {
+		t.Fatalf("NewRegistryCredentialsTableFromSecret: %s", err.Error())
+	}
+	if credentials := table.Get("localhost:5000"); (credentials == nil) || (credentials.Username != "user") || (credentials.Password != "pass") {
+		t.Errorf("round trip: %+v", credentials)
+	}
+	if credentials := table.Get("https://quay.io"); (credentials == nil) || (credentials.IdentityToken != "token") {
+		t.Errorf("round trip: %+v", credentials)
+	}
+}
@@ -1,54 +1,419 @@
 package kubernetes
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	contextpkg "context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 
+	namepkg "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/tliron/go-ard"
+	"github.com/tliron/go-kutil/util"
+	errorspkg "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubernetespkg "k8s.io/client-go/kubernetes"
 )
 
-func GetInternalRegistryHost(context contextpkg.Context, kubernetes kubernetespkg.Interface) (string, error) {
-	// TODO: OpenShift
+// See: https://github.com/kubernetes/enhancements/tree/master/keps/sig-cluster-lifecycle/generic/1755-communicating-a-local-registry
+const (
+	LocalRegistryHostingNamespace = "kube-public"
+	LocalRegistryHostingName      = "local-registry-hosting"
+	LocalRegistryHostingKey       = "localRegistryHosting.v1"
+)
+
+//
+// InternalRegistry
+//
+
+type InternalRegistry struct {
+	// Reachable from outside the cluster, e.g. "localhost:5001"; empty if
+	// the registry has to be exposed first (see [GetInternalRegistry])
+	Host string
+
+	// Reachable from the container runtime of the nodes, i.e. for pulling
+	// images for pods
+	HostFromContainerRuntime string
+
+	// Reachable from within pods
+	HostFromClusterNetwork string
+}
+
+// Detects the internal registry of the cluster, trying in order:
+//
+//   - The "local-registry-hosting" ConfigMap (KEP-1755), as created by kind
+//     and k3d
+//   - OpenShift's integrated registry
+//   - Minikube's registry addon
+//
+// For OpenShift and Minikube we only know the in-cluster address of the
+// registry, so Host will be empty. To reach them from outside the cluster
+// expose them first, e.g. via OpenShift's "default-route" or via
+// "kubectl port-forward".
+func GetInternalRegistry(context contextpkg.Context, kubernetes kubernetespkg.Interface) (*InternalRegistry, error) {
+	if configMap, err := kubernetes.CoreV1().ConfigMaps(LocalRegistryHostingNamespace).Get(context, LocalRegistryHostingName, meta.GetOptions{}); err == nil {
+		if code, ok := configMap.Data[LocalRegistryHostingKey]; ok {
+			return newInternalRegistryFromLocalRegistryHosting(code)
+		}
+	} else if !errorspkg.IsNotFound(err) {
+		return nil, err
+	}
+
+	// OpenShift
+	if service, err := kubernetes.CoreV1().Services("openshift-image-registry").Get(context, "image-registry", meta.GetOptions{}); err == nil {
+		port := 5000
+		if len(service.Spec.Ports) > 0 {
+			port = int(service.Spec.Ports[0].Port)
+		}
+		host := fmt.Sprintf("%s.%s.svc:%d", service.Name, service.Namespace, port)
+		return &InternalRegistry{"", host, host}, nil
+	} else if !errorspkg.IsNotFound(err) {
+		return nil, err
+	}
 
 	// Minikube
 	if service, err := kubernetes.CoreV1().Services("kube-system").Get(context, "registry", meta.GetOptions{}); err == nil {
-		return fmt.Sprintf("%s:80", service.Spec.ClusterIP), nil
+		host := fmt.Sprintf("%s:80", service.Spec.ClusterIP)
+		return &InternalRegistry{"", host, host}, nil
+	} else if !errorspkg.IsNotFound(err) {
+		return nil, err
+	}
+
+	return nil, errors.New("internal registry not found")
+}
+
+// Returns the host of the internal registry as reachable from within pods.
+// See [GetInternalRegistry].
+func GetInternalRegistryHost(context contextpkg.Context, kubernetes kubernetespkg.Interface) (string, error) {
+	if internalRegistry, err := GetInternalRegistry(context, kubernetes); err == nil {
+		return internalRegistry.HostFromClusterNetwork, nil
 	} else {
 		return "", err
 	}
 }
 
-/*
-func PushTarballToRegistry(path string, name string) error {
-	if tag, err := namepkg.NewTag(name); err == nil {
-		if image, err := tarball.ImageFromPath(path, &tag); err == nil {
-			return remote.Write(tag, image)
+func newInternalRegistryFromLocalRegistryHosting(code string) (*InternalRegistry, error) {
+	value, _, err := ard.DecodeYAML([]byte(code), false)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %q: %w", LocalRegistryHostingKey, err)
+	}
+
+	var self InternalRegistry
+	value_ := ard.With(value)
+	self.Host, _ = value_.Get("host").String()
+	self.HostFromContainerRuntime, _ = value_.Get("hostFromContainerRuntime").String()
+	self.HostFromClusterNetwork, _ = value_.Get("hostFromClusterNetwork").String()
+
+	if self.Host == "" {
+		return nil, fmt.Errorf("malformed %q: no \"host\"", LocalRegistryHostingKey)
+	}
+
+	// Unset hosts are the same as the more general ones
+	if self.HostFromContainerRuntime == "" {
+		self.HostFromContainerRuntime = self.Host
+	}
+	if self.HostFromClusterNetwork == "" {
+		self.HostFromClusterNetwork = self.HostFromContainerRuntime
+	}
+
+	return &self, nil
+}
+
+//
+// TarballFormat
+//
+
+type TarballFormat string
+
+const (
+	// As created by "docker save"
+	DockerArchiveTarballFormat TarballFormat = "docker-archive"
+
+	// As created by "podman save --format=oci-archive"
+	OCILayoutTarballFormat TarballFormat = "oci-layout"
+)
+
+//
+// RegistryOptions
+//
+
+type RegistryOptions struct {
+	// Can be nil for anonymous access
+	Credentials RegistryCredentialsTable
+
+	// Allow plain HTTP and skip TLS verification
+	Insecure bool
+
+	// For pulling from multi-platform images, e.g. "linux/arm64"; empty for
+	// the default ("linux/amd64") for [DockerArchiveTarballFormat] and all
+	// platforms for [OCILayoutTarballFormat]
+	Platform string
+}
+
+// Pushes a docker-archive or OCI-layout tarball to the registry. The format is
+// detected automatically.
+//
+// The name is an image reference, e.g. "localhost:5000/my/image:latest".
+// OCI-layout tarballs that contain more than one manifest are pushed as an
+// index.
+func PushTarballToRegistry(context contextpkg.Context, path string, name string, options *RegistryOptions) error {
+	return pushTarballToRegistry(context, func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}, name, options)
+}
+
+// Like [PushTarballToRegistry] but for a gzipped tarball.
+func PushGzippedTarballToRegistry(context contextpkg.Context, path string, name string, options *RegistryOptions) error {
+	return pushTarballToRegistry(context, func() (io.ReadCloser, error) {
+		if file, err := os.Open(path); err == nil {
+			if reader, err := gzip.NewReader(file); err == nil {
+				return gzipFileReader{reader, file}, nil
+			} else {
+				file.Close()
+				return nil, err
+			}
 		} else {
-			return err
+			return nil, err
 		}
-	} else {
+	}, name, options)
+}
+
+// Pulls the image (or index) from the registry and writes it as a tarball in
+// the format.
+func PullTarballFromRegistry(context contextpkg.Context, name string, path string, format TarballFormat, options *RegistryOptions) error {
+	reference, remoteOptions, err := getRegistryReference(context, name, options)
+	if err != nil {
 		return err
 	}
+
+	switch format {
+	case DockerArchiveTarballFormat:
+		if image, err := remote.Image(reference, remoteOptions...); err == nil {
+			return tarball.WriteToFile(path, reference, image)
+		} else {
+			return err
+		}
+
+	case OCILayoutTarballFormat:
+		descriptor, err := remote.Get(reference, remoteOptions...)
+		if err != nil {
+			return err
+		}
+
+		dir, err := os.MkdirTemp("", "kutil-oci-layout-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		layoutPath, err := layout.Write(dir, empty.Index)
+		if err != nil {
+			return err
+		}
+
+		annotations := layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": reference.Identifier(),
+		})
+
+		if descriptor.MediaType.IsIndex() && ((options == nil) || (options.Platform == "")) {
+			if index, err := descriptor.ImageIndex(); err == nil {
+				if err := layoutPath.AppendIndex(index, annotations); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		} else {
+			// Will select the platform if it is an index
+			if image, err := descriptor.Image(); err == nil {
+				if err := layoutPath.AppendImage(image, annotations); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+
+		return writeTarball(dir, path)
+
+	default:
+		return fmt.Errorf("unsupported tarball format: %s", format)
+	}
 }
 
-func PushGzippedTarballToRegistry(path string, name string) error {
-	if tag, err := namepkg.NewTag(name); err == nil {
-		opener := func() (io.ReadCloser, error) {
-			if reader, err := os.Open(path); err == nil {
-				return gzip.NewReader(reader)
+func pushTarballToRegistry(context contextpkg.Context, opener tarball.Opener, name string, options *RegistryOptions) error {
+	reference, remoteOptions, err := getRegistryReference(context, name, options)
+	if err != nil {
+		return err
+	}
+
+	format, err := getTarballFormat(opener)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case DockerArchiveTarballFormat:
+		// Must contain exactly one image
+		if image, err := tarball.Image(opener, nil); err == nil {
+			return remote.Write(reference, image, remoteOptions...)
+		} else {
+			return err
+		}
+
+	default:
+		dir, err := os.MkdirTemp("", "kutil-oci-layout-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		if reader, err := opener(); err == nil {
+			err = util.ExtractTarEntries(tar.NewReader(reader), ".", dir, nil)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+
+		layoutPath, err := layout.FromPath(dir)
+		if err != nil {
+			return err
+		}
+
+		index, err := layoutPath.ImageIndex()
+		if err != nil {
+			return err
+		}
+
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return err
+		}
+
+		switch len(indexManifest.Manifests) {
+		case 0:
+			return errors.New("OCI layout has no manifests")
+
+		case 1:
+			descriptor := indexManifest.Manifests[0]
+			if descriptor.MediaType.IsIndex() {
+				if index_, err := index.ImageIndex(descriptor.Digest); err == nil {
+					return remote.WriteIndex(reference, index_, remoteOptions...)
+				} else {
+					return err
+				}
 			} else {
-				return nil, err
+				if image, err := index.Image(descriptor.Digest); err == nil {
+					return remote.Write(reference, image, remoteOptions...)
+				} else {
+					return err
+				}
 			}
+
+		default:
+			return remote.WriteIndex(reference, index, remoteOptions...)
+		}
+	}
+}
+
+// Utils
+
+func getRegistryReference(context contextpkg.Context, name string, options *RegistryOptions) (namepkg.Reference, []remote.Option, error) {
+	if options == nil {
+		options = new(RegistryOptions)
+	}
+
+	var nameOptions []namepkg.Option
+	remoteOptions := []remote.Option{remote.WithContext(context)}
+
+	if options.Credentials != nil {
+		remoteOptions = append(remoteOptions, remote.WithAuthFromKeychain(options.Credentials))
+	}
+
+	if options.Insecure {
+		nameOptions = append(nameOptions, namepkg.Insecure)
+		if transport, ok := remote.DefaultTransport.(*http.Transport); ok {
+			transport = transport.Clone()
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			remoteOptions = append(remoteOptions, remote.WithTransport(transport))
 		}
+	}
+
+	if options.Platform != "" {
+		if platform, err := v1.ParsePlatform(options.Platform); err == nil {
+			remoteOptions = append(remoteOptions, remote.WithPlatform(*platform))
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	if reference, err := namepkg.ParseReference(name, nameOptions...); err == nil {
+		return reference, remoteOptions, nil
+	} else {
+		return nil, nil, err
+	}
+}
 
-		if image, err := tarball.Image(opener, &tag); err == nil {
-			return remote.Write(tag, image)
+// Docker archives have a top-level "manifest.json".
+func getTarballFormat(opener tarball.Opener) (TarballFormat, error) {
+	if reader, err := opener(); err == nil {
+		tarballReader := util.NewTarballReader(tar.NewReader(reader), reader, nil)
+		defer tarballReader.Close()
+		if has, err := tarballReader.Has("manifest.json"); err == nil {
+			if has {
+				return DockerArchiveTarballFormat, nil
+			} else {
+				return OCILayoutTarballFormat, nil
+			}
 		} else {
+			return "", err
+		}
+	} else {
+		return "", err
+	}
+}
+
+func writeTarball(dir string, path string) error {
+	if file, err := os.Create(path); err == nil {
+		tarWriter := tar.NewWriter(file)
+		if err := util.WriteTarEntries(tarWriter, dir, ".", nil); err != nil {
+			file.Close()
 			return err
 		}
+		if err := tarWriter.Close(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
 	} else {
 		return err
 	}
 }
-*/
+
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+// ([io.Closer] interface)
+func (self gzipFileReader) Close() error {
+	err1 := self.Reader.Close()
+	err2 := self.file.Close()
+	if err1 != nil {
+		return err1
+	} else {
+		return err2
+	}
+}
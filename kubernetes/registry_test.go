@@ -0,0 +1,259 @@
+package kubernetes
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	namepkg "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPushPullTarball(t *testing.T) {
+	host := startTestRegistry(t, nil)
+	dir := t.TempDir()
+
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image: %s", err.Error())
+	}
+
+	// docker-archive
+	dockerArchive := filepath.Join(dir, "docker-archive.tar")
+	if err := tarball.WriteToFile(dockerArchive, namepkg.MustParseReference("image:source"), image); err != nil {
+		t.Fatalf("tarball.WriteToFile: %s", err.Error())
+	}
+	if err := PushTarballToRegistry(context.TODO(), dockerArchive, host+"/image:docker", nil); err != nil {
+		t.Fatalf("PushTarballToRegistry: %s", err.Error())
+	}
+	expectTestImage(t, host+"/image:docker", image)
+
+	// Gzipped docker-archive
+	gzipped := filepath.Join(dir, "docker-archive.tar.gz")
+	gzipTestFile(t, dockerArchive, gzipped)
+	if err := PushGzippedTarballToRegistry(context.TODO(), gzipped, host+"/image:gzipped", nil); err != nil {
+		t.Fatalf("PushGzippedTarballToRegistry: %s", err.Error())
+	}
+	expectTestImage(t, host+"/image:gzipped", image)
+
+	// Round trip via docker-archive
+	pulled := filepath.Join(dir, "pulled.tar")
+	if err := PullTarballFromRegistry(context.TODO(), host+"/image:docker", pulled, DockerArchiveTarballFormat, nil); err != nil {
+		t.Fatalf("PullTarballFromRegistry: %s", err.Error())
+	}
+	if err := PushTarballToRegistry(context.TODO(), pulled, host+"/image:pulled", nil); err != nil {
+		t.Fatalf("PushTarballToRegistry: %s", err.Error())
+	}
+	expectTestImage(t, host+"/image:pulled", image)
+
+	// Round trip via oci-layout
+	ociLayout := filepath.Join(dir, "oci-layout.tar")
+	if err := PullTarballFromRegistry(context.TODO(), host+"/image:docker", ociLayout, OCILayoutTarballFormat, nil); err != nil {
+		t.Fatalf("PullTarballFromRegistry: %s", err.Error())
+	}
+	if err := PushTarballToRegistry(context.TODO(), ociLayout, host+"/image:oci", nil); err != nil {
+		t.Fatalf("PushTarballToRegistry: %s", err.Error())
+	}
+	expectTestImage(t, host+"/image:oci", image)
+
+	// Multi-platform index via oci-layout
+	index, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index: %s", err.Error())
+	}
+	if err := remote.WriteIndex(parseTestReference(t, host+"/index:source"), index); err != nil {
+		t.Fatalf("remote.WriteIndex: %s", err.Error())
+	}
+	ociLayout = filepath.Join(dir, "oci-layout-index.tar")
+	if err := PullTarballFromRegistry(context.TODO(), host+"/index:source", ociLayout, OCILayoutTarballFormat, nil); err != nil {
+		t.Fatalf("PullTarballFromRegistry: %s", err.Error())
+	}
+	if err := PushTarballToRegistry(context.TODO(), ociLayout, host+"/index:oci", nil); err != nil {
+		t.Fatalf("PushTarballToRegistry: %s", err.Error())
+	}
+	if descriptor, err := remote.Get(parseTestReference(t, host+"/index:oci")); err == nil {
+		if digest, _ := index.Digest(); descriptor.Digest != digest {
+			t.Errorf("index: %s, expected %s", descriptor.Digest, digest)
+		}
+	} else {
+		t.Errorf("remote.Get: %s", err.Error())
+	}
+}
+
+func TestPushTarballCredentials(t *testing.T) {
+	host := startTestRegistry(t, NewRegistryCredentials("user", "pass"))
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "image.tar")
+	if err := tarball.WriteToFile(path, namepkg.MustParseReference("image:source"), image); err != nil {
+		t.Fatalf("tarball.WriteToFile: %s", err.Error())
+	}
+
+	if err := PushTarballToRegistry(context.TODO(), path, host+"/image:latest", nil); err == nil {
+		t.Errorf("anonymous push succeeded")
+	}
+
+	options := RegistryOptions{
+		Credentials: RegistryCredentialsTable{
+			host + "/other": NewRegistryCredentials("other", "otherpass"),
+			host + "/image": NewRegistryCredentials("user", "pass"),
+		},
+	}
+	if err := PushTarballToRegistry(context.TODO(), path, host+"/image:latest", &options); err != nil {
+		t.Errorf("PushTarballToRegistry: %s", err.Error())
+	}
+	if err := PushTarballToRegistry(context.TODO(), path, host+"/other:latest", &options); err == nil {
+		t.Errorf("push with wrong credentials succeeded")
+	}
+}
+
+func TestGetInternalRegistry(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   runtime.Object
+		host     string
+		internal string
+	}{
+		{"kind", &core.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{Name: LocalRegistryHostingName, Namespace: LocalRegistryHostingNamespace},
+			Data:       map[string]string{LocalRegistryHostingKey: "host: \"localhost:5001\"\nhelp: \"https://kind.sigs.k8s.io/docs/user/local-registry/\"\n"},
+		}, "localhost:5001", "localhost:5001"},
+		{"k3d", &core.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{Name: LocalRegistryHostingName, Namespace: LocalRegistryHostingNamespace},
+			Data:       map[string]string{LocalRegistryHostingKey: "host: localhost:5000\nhostFromContainerRuntime: registry.localhost:5000\nhostFromClusterNetwork: registry.localhost:5000\n"},
+		}, "localhost:5000", "registry.localhost:5000"},
+		{"OpenShift", &core.Service{
+			ObjectMeta: meta.ObjectMeta{Name: "image-registry", Namespace: "openshift-image-registry"},
+			Spec:       core.ServiceSpec{Ports: []core.ServicePort{{Port: 5000}}},
+		}, "", "image-registry.openshift-image-registry.svc:5000"},
+		{"Minikube", &core.Service{
+			ObjectMeta: meta.ObjectMeta{Name: "registry", Namespace: "kube-system"},
+			Spec:       core.ServiceSpec{ClusterIP: "10.96.0.10"},
+		}, "", "10.96.0.10:80"},
+	}
+
+	for _, test := range tests {
+		kubernetes := fake.NewClientset(test.object)
+
+		if internalRegistry, err := GetInternalRegistry(context.TODO(), kubernetes); err == nil {
+			if (internalRegistry.Host != test.host) || (internalRegistry.HostFromClusterNetwork != test.internal) {
+				t.Errorf("%s: %+v", test.name, internalRegistry)
+			}
+		} else {
+			t.Errorf("%s: %s", test.name, err.Error())
+		}
+
+		if host, err := GetInternalRegistryHost(context.TODO(), kubernetes); (err != nil) || (host != test.internal) {
+			t.Errorf("%s: %q, %v", test.name, host, err)
+		}
+	}
+
+	if _, err := GetInternalRegistry(context.TODO(), fake.NewClientset()); err == nil {
+		t.Errorf("no internal registry: no error")
+	}
+}
+
+// Returns the host; requires basic authentication if credentials are provided.
+func startTestRegistry(t *testing.T, credentials *RegistryCredentials) string {
+	t.Helper()
+
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	if credentials != nil {
+		handler_ := handler
+		handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if username, password, ok := request.BasicAuth(); !ok || (username != credentials.Username) || (password != credentials.Password) {
+				writer.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler_.ServeHTTP(writer, request)
+		})
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func expectTestImage(t *testing.T, name string, expected v1.Image) {
+	t.Helper()
+
+	image, err := remote.Image(parseTestReference(t, name))
+	if err != nil {
+		t.Errorf("%s: %s", name, err.Error())
+		return
+	}
+
+	configName, _ := image.ConfigName()
+	expectedConfigName, _ := expected.ConfigName()
+	if configName != expectedConfigName {
+		t.Errorf("%s: config %s, expected %s", name, configName, expectedConfigName)
+	}
+
+	layers, _ := image.Layers()
+	expectedLayers, _ := expected.Layers()
+	if len(layers) != len(expectedLayers) {
+		t.Errorf("%s: %d layers, expected %d", name, len(layers), len(expectedLayers))
+		return
+	}
+	for index, layer := range layers {
+		digest, _ := layer.Digest()
+		expectedDigest, _ := expectedLayers[index].Digest()
+		if digest != expectedDigest {
+			t.Errorf("%s: layer %s, expected %s", name, digest, expectedDigest)
+		}
+	}
+}
+
+func parseTestReference(t *testing.T, name string) namepkg.Reference {
+	t.Helper()
+
+	reference, err := namepkg.ParseReference(name)
+	if err != nil {
+		t.Fatalf("ParseReference: %s", err.Error())
+	}
+	return reference
+}
+
+func gzipTestFile(t *testing.T, path string, gzippedPath string) {
+	t.Helper()
+
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err.Error())
+	}
+	defer reader.Close()
+
+	file, err := os.Create(gzippedPath)
+	if err != nil {
+		t.Fatalf("Create: %s", err.Error())
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	if _, err := io.Copy(writer, reader); err != nil {
+		t.Fatalf("Copy: %s", err.Error())
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %s", err.Error())
+	}
+}
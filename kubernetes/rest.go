@@ -4,36 +4,26 @@ import (
 	contextpkg "context"
 	"fmt"
 	"io"
-	"path/filepath"
-	"strconv"
 	"strings"
 
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/client-go/kubernetes/scheme"
 	restpkg "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
+// Writes the reader's content to a file in the container via "tar" (see
+// [UploadToContainer]). Missing directories will be created. When permissions
+// is nil the file mode will be 0644.
 func WriteToContainer(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, reader io.Reader, targetPath string, permissions *int64) error {
-	dir := filepath.Dir(targetPath)
-	if err := Exec(context, rest, config, namespace, podName, containerName, nil, nil, nil, false, "mkdir", "--parents", dir); err == nil {
-		if err := Exec(context, rest, config, namespace, podName, containerName, reader, nil, nil, false, "cp", "/dev/stdin", targetPath); err == nil {
-			if permissions != nil {
-				octal := strconv.FormatInt(*permissions, 8)
-				return Exec(context, rest, config, namespace, podName, containerName, nil, nil, nil, false, "chmod", octal, targetPath)
-			} else {
-				return nil
-			}
-		} else {
-			return err
-		}
-	} else {
-		return err
-	}
+	return uploadReaderToContainer(newContainerExec(context, rest, config, namespace, podName, containerName), reader, targetPath, permissions)
 }
 
+// Writes the content of a file in the container via "cat". Use
+// [DownloadFromContainer] for directory trees.
 func ReadFromContainer(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, writer io.Writer, sourcePath string) error {
-	return Exec(context, rest, config, namespace, podName, containerName, nil, writer, nil, false, "cat", sourcePath)
+	return readFileFromContainer(newContainerExec(context, rest, config, namespace, podName, containerName), writer, sourcePath)
 }
 
 func Exec(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, containerName string, stdin io.Reader, stdout io.Writer, stderr io.Writer, tty bool, command ...string) error {
@@ -65,9 +55,7 @@ func Exec(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Co
 		streamOptions.Stdout = stdout
 	}
 
-	request := rest.Post().Namespace(namespace).Resource("pods").Name(podName).SubResource("exec").VersionedParams(&execOptions, scheme.ParameterCodec)
-
-	if executor, err := remotecommand.NewSPDYExecutor(config, "POST", request.URL()); err == nil {
+	if executor, err := NewExecExecutor(rest, config, namespace, podName, &execOptions); err == nil {
 		if err = executor.StreamWithContext(context, streamOptions); err == nil {
 			return nil
 		} else {
@@ -78,6 +66,26 @@ func Exec(context contextpkg.Context, rest restpkg.Interface, config *restpkg.Co
 	}
 }
 
+// Prefers WebSockets and falls back to SPDY for servers that don't support
+// them (like kubectl).
+func NewExecExecutor(rest restpkg.Interface, config *restpkg.Config, namespace string, podName string, execOptions *core.PodExecOptions) (remotecommand.Executor, error) {
+	url := rest.Post().Namespace(namespace).Resource("pods").Name(podName).SubResource("exec").VersionedParams(execOptions, scheme.ParameterCodec).URL()
+
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(config, "POST", url)
+	if err != nil {
+		return nil, err
+	}
+
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(config, "GET", url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
 //
 // ExecError
 //
@@ -13,15 +13,36 @@ import (
 	kubernetespkg "k8s.io/client-go/kubernetes"
 )
 
-var interval = 10 * time.Second
-var timeout = 60 * time.Second
+//
+// WaitOptions
+//
 
+type WaitOptions struct {
+	// How often to poll; ignored by watch-based waits
+	Interval time.Duration
+
+	// Zero for no timeout (the context may still have a deadline)
+	Timeout time.Duration
+}
+
+// Used by [Wait] and by the functions in this file that are based on it.
+var DefaultWaitOptions = WaitOptions{
+	Interval: 10 * time.Second,
+	Timeout:  60 * time.Second,
+}
+
+// Polls with [DefaultWaitOptions].
 func WaitForPod(context contextpkg.Context, kubernetes kubernetespkg.Interface, log commonlog.Logger, namespace string, appName string) (*core.Pod, error) {
+	return WaitForPodWithOptions(context, kubernetes, log, namespace, appName, nil)
+}
+
+// Nil options means [DefaultWaitOptions].
+func WaitForPodWithOptions(context contextpkg.Context, kubernetes kubernetespkg.Interface, log commonlog.Logger, namespace string, appName string, options *WaitOptions) (*core.Pod, error) {
 	log.Infof("waiting for a pod for app %q", appName)
 
 	var pod *core.Pod
 
-	err := Wait(context, func(context contextpkg.Context) (bool, error) {
+	err := WaitWithOptions(context, func(context contextpkg.Context) (bool, error) {
 		if pods, err := GetPods(context, kubernetes, namespace, appName); err == nil {
 			for _, pod_ := range pods.Items {
 				for _, containerStatus := range pod_.Status.ContainerStatuses {
@@ -48,7 +69,7 @@ func WaitForPod(context contextpkg.Context, kubernetes kubernetespkg.Interface,
 		} else {
 			return false, err
 		}
-	})
+	}, options)
 
 	if (err == nil) && (pod != nil) {
 		log.Infof("a pod is available for app %q", appName)
@@ -58,11 +79,17 @@ func WaitForPod(context contextpkg.Context, kubernetes kubernetespkg.Interface,
 	}
 }
 
+// Polls with [DefaultWaitOptions].
 func WaitForDeployment(context contextpkg.Context, kubernetes kubernetespkg.Interface, log commonlog.Logger, namespace string, appName string) (*apps.Deployment, error) {
+	return WaitForDeploymentWithOptions(context, kubernetes, log, namespace, appName, nil)
+}
+
+// Nil options means [DefaultWaitOptions].
+func WaitForDeploymentWithOptions(context contextpkg.Context, kubernetes kubernetespkg.Interface, log commonlog.Logger, namespace string, appName string, options *WaitOptions) (*apps.Deployment, error) {
 	log.Infof("waiting for a deployment for app %q", appName)
 
 	var deployment *apps.Deployment
-	err := Wait(context, func(context contextpkg.Context) (bool, error) {
+	err := WaitWithOptions(context, func(context contextpkg.Context) (bool, error) {
 		var err error
 		if deployment, err = kubernetes.AppsV1().Deployments(namespace).Get(context, appName, meta.GetOptions{}); err == nil {
 			for _, condition := range deployment.Status.Conditions {
@@ -82,7 +109,7 @@ func WaitForDeployment(context contextpkg.Context, kubernetes kubernetespkg.Inte
 		} else {
 			return false, err
 		}
-	})
+	}, options)
 
 	if err == nil {
 		log.Infof("a deployment is available for app %q", appName)
@@ -101,8 +128,28 @@ func WaitForDeletion(context contextpkg.Context, log commonlog.Logger, name stri
 	}
 }
 
+// Polls with [DefaultWaitOptions].
 func Wait(context contextpkg.Context, condition wait.ConditionWithContextFunc) error {
-	return wait.PollUntilContextTimeout(context, interval, timeout, true, condition)
+	return WaitWithOptions(context, condition, nil)
+}
+
+// Nil options means [DefaultWaitOptions]. A zero interval means the default
+// interval.
+func WaitWithOptions(context contextpkg.Context, condition wait.ConditionWithContextFunc, options *WaitOptions) error {
+	if options == nil {
+		options = &DefaultWaitOptions
+	}
+
+	interval := options.Interval
+	if interval == 0 {
+		interval = DefaultWaitOptions.Interval
+	}
+
+	if options.Timeout > 0 {
+		return wait.PollUntilContextTimeout(context, interval, options.Timeout, true, condition)
+	} else {
+		return wait.PollUntilContextCancel(context, interval, true, condition)
+	}
 }
 
 /*
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tliron/commonlog"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForPodWithOptions(t *testing.T) {
+	log := commonlog.GetLogger("test")
+	options := WaitOptions{Interval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+
+	pod := newTestPod("app-1", map[string]string{"app.kubernetes.io/name": "app"}, true)
+	pod.Status.Conditions = append(pod.Status.Conditions, core.PodCondition{Type: core.ContainersReady, Status: core.ConditionTrue})
+	kubernetes := fake.NewClientset()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		kubernetes.CoreV1().Pods("default").Create(context.TODO(), pod, meta.CreateOptions{})
+	}()
+
+	if pod_, err := WaitForPodWithOptions(context.TODO(), kubernetes, log, "default", "app", &options); err == nil {
+		if pod_.Name != "app-1" {
+			t.Errorf("WaitForPodWithOptions: %s", pod_.Name)
+		}
+	} else {
+		t.Errorf("WaitForPodWithOptions: %s", err.Error())
+	}
+
+	// Must time out well before DefaultWaitOptions.Timeout
+	options.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := WaitForPodWithOptions(context.TODO(), kubernetes, log, "default", "other", &options); err == nil {
+		t.Error("WaitForPodWithOptions: no timeout")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("WaitForPodWithOptions: timed out after %s", time.Since(start))
+	}
+}
+
+func TestWaitForDeploymentWithOptions(t *testing.T) {
+	log := commonlog.GetLogger("test")
+	options := WaitOptions{Interval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+
+	kubernetes := fake.NewClientset(&apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Name: "app", Namespace: "default"},
+		Status: apps.DeploymentStatus{
+			Conditions: []apps.DeploymentCondition{{Type: apps.DeploymentAvailable, Status: core.ConditionTrue}},
+		},
+	})
+
+	if deployment, err := WaitForDeploymentWithOptions(context.TODO(), kubernetes, log, "default", "app", &options); err == nil {
+		if deployment.Name != "app" {
+			t.Errorf("WaitForDeploymentWithOptions: %s", deployment.Name)
+		}
+	} else {
+		t.Errorf("WaitForDeploymentWithOptions: %s", err.Error())
+	}
+
+	options.Timeout = 100 * time.Millisecond
+	if _, err := WaitForDeploymentWithOptions(context.TODO(), kubernetes, log, "default", "missing", &options); err == nil {
+		t.Error("WaitForDeploymentWithOptions: no error")
+	}
+}
@@ -32,6 +32,14 @@ func NewTarStreamPackage(context contextpkg.Context, url exturl.URL) (*TarStream
 	return &self, nil
 }
 
+// The reader will be closed by [TarStreamPackage.Close].
+func NewTarStreamPackageFromReader(reader io.ReadCloser) *TarStreamPackage {
+	return &TarStreamPackage{
+		reader:    reader,
+		tarReader: tar.NewReader(reader),
+	}
+}
+
 // StreamPackage interface
 func (self *TarStreamPackage) Next() (Stream, error) {
 	for {
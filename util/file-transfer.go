@@ -0,0 +1,168 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//
+// FileTransferOptions
+//
+
+type FileTransferOptions struct {
+	// Called whenever bytes are written to the target
+	Progress func(progress FileTransferProgress)
+
+	// When true will compare the SHA-256 checksums of all regular files in the
+	// source and the target after the transfer. Note that this involves reading
+	// both again.
+	Verify bool
+}
+
+//
+// FileTransferProgress
+//
+
+type FileTransferProgress struct {
+	Path        string // of the current file relative to the transfer's root, slash-separated ("." for the root)
+	Transferred int64  // bytes, for all files so far
+	Total       int64  // bytes, for all files; -1 if unknown
+}
+
+//
+// FileTransferTracker
+//
+
+// Reports progress for a transfer. Safe for concurrent use.
+type FileTransferTracker struct {
+	progress    func(progress FileTransferProgress)
+	transferred int64
+	total       int64
+	lock        sync.Mutex
+}
+
+// Options can be nil. Total can be -1 if unknown.
+func NewFileTransferTracker(options *FileTransferOptions, total int64) *FileTransferTracker {
+	var self FileTransferTracker
+	if options != nil {
+		self.progress = options.Progress
+	}
+	self.total = total
+	return &self
+}
+
+// Wraps the writer to report progress for the path.
+func (self *FileTransferTracker) Writer(path string, writer io.Writer) io.Writer {
+	if self.progress == nil {
+		return writer
+	}
+	return &fileTransferWriter{self, path, writer}
+}
+
+func (self *FileTransferTracker) add(path string, n int) {
+	self.lock.Lock()
+	self.transferred += int64(n)
+	progress := FileTransferProgress{Path: path, Transferred: self.transferred, Total: self.total}
+	self.lock.Unlock()
+
+	self.progress(progress)
+}
+
+type fileTransferWriter struct {
+	tracker *FileTransferTracker
+	path    string
+	writer  io.Writer
+}
+
+// ([io.Writer] interface)
+func (self *fileTransferWriter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	if n > 0 {
+		self.tracker.add(self.path, n)
+	}
+	return n, err
+}
+
+// Returns the total size of the regular files at the path, which can be a file
+// or a directory.
+func GetFileTransferSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			} else {
+				return err
+			}
+		}
+		return nil
+	})
+	return size, err
+}
+
+//
+// FileChecksums
+//
+
+// Hex-encoded SHA-256 checksums mapped by path relative to the transfer's root,
+// slash-separated ("." for the root).
+type FileChecksums map[string]string
+
+// Checksums the regular files at the path, which can be a file or a directory.
+func GetFileChecksums(path string) (FileChecksums, error) {
+	self := make(FileChecksums)
+	err := filepath.WalkDir(path, func(path_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if relativePath, err := filepath.Rel(path, path_); err == nil {
+				if file, err := os.Open(path_); err == nil {
+					defer file.Close()
+					return self.Add(filepath.ToSlash(relativePath), file)
+				} else {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+		return nil
+	})
+	return self, err
+}
+
+// Reads the reader to the end.
+func (self FileChecksums) Add(path string, reader io.Reader) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err == nil {
+		self[path] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Makes sure that all our checksums exist in the target and are equal. The
+// target may have additional checksums.
+func (self FileChecksums) Verify(target FileChecksums) error {
+	for path, checksum := range self {
+		if targetChecksum, ok := target[path]; ok {
+			if targetChecksum != checksum {
+				return fmt.Errorf("checksum mismatch for %q: %s != %s", path, targetChecksum, checksum)
+			}
+		} else {
+			return fmt.Errorf("missing from target: %q", path)
+		}
+	}
+	return nil
+}
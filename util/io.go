@@ -3,8 +3,10 @@ package util
 import (
 	contextpkg "context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -42,8 +44,13 @@ func ContextualRead(context contextpkg.Context, reader io.Reader, p []byte) (int
 	}
 }
 
+var ErrChannelFull = errors.New("channel full")
+
 // Sends the byte slice to a channel and returns its length.
 //
+// Sending is non-blocking: if the channel is full then will return
+// [ErrChannelFull].
+//
 // When copy is true will copy the byte slice before sending it to the
 // channel. This is necessary for ensuring that the submitted data is
 // indeed the data that will be received, even if the underlying array
@@ -59,7 +66,34 @@ func WriteBytesToChannel(ch chan []byte, p []byte, copy bool) (int, error) {
 		select {
 		case ch <- p:
 		default:
-			return 0, errors.New("channel full")
+			return 0, ErrChannelFull
+		}
+	}
+
+	return length, nil
+}
+
+// Sends the byte slice to a channel and returns its length.
+//
+// Sending blocks while the channel is full. Will return the context error
+// if the context is done before the byte slice could be sent.
+//
+// When copy is true will copy the byte slice before sending it to the
+// channel. This is necessary for ensuring that the submitted data is
+// indeed the data that will be received, even if the underlying array
+// changes after this call.
+func WriteBytesToChannelContext(context contextpkg.Context, ch chan []byte, p []byte, copy bool) (int, error) {
+	if copy {
+		p = append(p[:0:0], p...)
+	}
+
+	length := len(p)
+
+	if length > 0 {
+		select {
+		case ch <- p:
+		case <-context.Done():
+			return 0, context.Err()
 		}
 	}
 
@@ -188,30 +222,265 @@ func (self *SyncedWriter) Close() error {
 	}
 }
 
+//
+// ChannelOverflow
+//
+
+type ChannelOverflow string
+
+const (
+	// Writing fails with [ErrChannelFull] when the channel is full.
+	FailChannelOverflow ChannelOverflow = "fail"
+
+	// Writing blocks while the channel is full.
+	BlockChannelOverflow ChannelOverflow = "block"
+
+	// Writing spills to a bounded ring buffer while the channel is full.
+	SpillChannelOverflow ChannelOverflow = "spill"
+
+	// Writing drops the bytes while the channel is full.
+	DropChannelOverflow ChannelOverflow = "drop"
+)
+
+func (self ChannelOverflow) Validate(name string) error {
+	switch self {
+	case FailChannelOverflow, BlockChannelOverflow, SpillChannelOverflow, DropChannelOverflow:
+		return nil
+	default:
+		return fmt.Errorf("%s is not %q, %q, %q, or %q: %s", name, FailChannelOverflow, BlockChannelOverflow, SpillChannelOverflow, DropChannelOverflow, self)
+	}
+}
+
 //
 // ChannelWriter
 //
 
+const DEFAULT_SPILL_SIZE = 1024 * 1024
+
 type ChannelWriter struct {
-	ch   chan []byte
-	copy bool
+	ch       chan []byte
+	copy     bool
+	overflow ChannelOverflow
+	context  contextpkg.Context
+	dropped  atomic.Uint64
+
+	spill       *RingBuffer
+	spillLock   sync.Mutex
+	spillSignal chan struct{}
+	spilled     chan struct{}
+	pumping     bool
+	closing     bool
 }
 
-// Creates an [io.Writer] that writes bytes to a channel. The expectation is that
-// something else will be receiving from the channel and processing the bytes.
+// Creates an [io.WriteCloser] that writes bytes to a channel. The expectation is
+// that something else will be receiving from the channel and processing the bytes.
 // Writing is non-blocking: if the channel is full then [ChannelWriter.Write] will
-// return an error.
+// return [ErrChannelFull].
 //
 // When copy is true the implementation copies the byte slice before sending it to
 // the channel. This ensures that the submitted data is indeed the data that will be
 // received, even if the underlying array changes after submission.
 func NewChannelWriter(ch chan []byte, copy bool) *ChannelWriter {
-	return &ChannelWriter{ch, copy}
+	return &ChannelWriter{
+		ch:       ch,
+		copy:     copy,
+		overflow: FailChannelOverflow,
+	}
+}
+
+// Like [NewChannelWriter] except that if the channel is full then
+// [ChannelWriter.Write] will block until there is room in the channel or until
+// the context is done.
+func NewBlockingChannelWriter(context contextpkg.Context, ch chan []byte, copy bool) *ChannelWriter {
+	return &ChannelWriter{
+		ch:       ch,
+		copy:     copy,
+		overflow: BlockChannelOverflow,
+		context:  context,
+	}
+}
+
+// Like [NewChannelWriter] except that if the channel is full then
+// [ChannelWriter.Write] will silently drop the bytes. The number of dropped bytes
+// is available via [ChannelWriter.Dropped].
+func NewDroppingChannelWriter(ch chan []byte, copy bool) *ChannelWriter {
+	return &ChannelWriter{
+		ch:       ch,
+		copy:     copy,
+		overflow: DropChannelOverflow,
+	}
+}
+
+// Like [NewChannelWriter] except that if the channel is full then
+// [ChannelWriter.Write] will spill the bytes into a ring buffer of spillSize
+// bytes (or [DEFAULT_SPILL_SIZE] when not positive), which is drained into the
+// channel on a separate goroutine as soon as there is room. If the ring buffer
+// itself fills up then the oldest bytes are overwritten. The number of overwritten bytes is available via
+// [ChannelWriter.Dropped].
+//
+// [ChannelWriter.Close] must be called, otherwise there will be a goroutine leak.
+// It will block until the ring buffer is drained or until the context is done.
+func NewSpillingChannelWriter(context contextpkg.Context, ch chan []byte, copy bool, spillSize int) *ChannelWriter {
+	if spillSize <= 0 {
+		spillSize = DEFAULT_SPILL_SIZE
+	}
+
+	self := ChannelWriter{
+		ch:          ch,
+		copy:        copy,
+		overflow:    SpillChannelOverflow,
+		context:     context,
+		spill:       NewRingBuffer(spillSize),
+		spillSignal: make(chan struct{}, 1),
+		spilled:     make(chan struct{}),
+	}
+
+	go self.pump()
+
+	return &self
+}
+
+// Creates an [io.WriteCloser] according to the overflow policy, which
+// defaults to [FailChannelOverflow]. The context is used only for
+// [BlockChannelOverflow] and [SpillChannelOverflow]. The spillSize is used only
+// for [SpillChannelOverflow].
+func NewChannelWriterFor(overflow ChannelOverflow, context contextpkg.Context, ch chan []byte, copy bool, spillSize int) *ChannelWriter {
+	switch overflow {
+	case BlockChannelOverflow:
+		return NewBlockingChannelWriter(context, ch, copy)
+	case SpillChannelOverflow:
+		return NewSpillingChannelWriter(context, ch, copy, spillSize)
+	case DropChannelOverflow:
+		return NewDroppingChannelWriter(ch, copy)
+	default:
+		return NewChannelWriter(ch, copy)
+	}
+}
+
+// The number of bytes that were dropped due to overflow.
+func (self *ChannelWriter) Dropped() uint64 {
+	dropped := self.dropped.Load()
+	if self.spill != nil {
+		self.spillLock.Lock()
+		dropped += self.spill.Dropped()
+		self.spillLock.Unlock()
+	}
+	return dropped
 }
 
 // ([io.Writer] interface)
 func (self *ChannelWriter) Write(p []byte) (int, error) {
-	return WriteBytesToChannel(self.ch, p, self.copy)
+	switch self.overflow {
+	case BlockChannelOverflow:
+		return WriteBytesToChannelContext(self.context, self.ch, p, self.copy)
+
+	case SpillChannelOverflow:
+		return self.writeOrSpill(p)
+
+	case DropChannelOverflow:
+		if _, err := WriteBytesToChannel(self.ch, p, self.copy); err != nil {
+			self.dropped.Add(uint64(len(p)))
+		}
+		return len(p), nil
+
+	default:
+		return WriteBytesToChannel(self.ch, p, self.copy)
+	}
+}
+
+// Does not close the channel. For [SpillChannelOverflow] will block until
+// the ring buffer is drained into the channel or until the context is done.
+// For other overflow policies does nothing.
+//
+// ([io.Closer] interface)
+func (self *ChannelWriter) Close() error {
+	if self.spill != nil {
+		self.spillLock.Lock()
+		if !self.closing {
+			self.closing = true
+			self.signalSpill()
+		}
+		self.spillLock.Unlock()
+
+		// The pump also stops when the context is done, and we must wait for it
+		// so that it won't send to the channel after we return
+		<-self.spilled
+
+		self.spillLock.Lock()
+		drained := !self.pumping && (self.spill.Len() == 0)
+		self.spillLock.Unlock()
+
+		if !drained {
+			return self.context.Err()
+		}
+	}
+
+	return nil
+}
+
+func (self *ChannelWriter) writeOrSpill(p []byte) (int, error) {
+	self.spillLock.Lock()
+	defer self.spillLock.Unlock()
+
+	if self.closing {
+		return 0, io.ErrClosedPipe
+	}
+
+	// We can only bypass the ring buffer if it's not in use, otherwise we'd
+	// be changing the order of the data
+	if (self.spill.Len() == 0) && !self.pumping {
+		if length, err := WriteBytesToChannel(self.ch, p, self.copy); err == nil {
+			return length, nil
+		}
+	}
+
+	// Note: the ring buffer always copies
+	self.spill.Write(p)
+	self.signalSpill()
+	return len(p), nil
+}
+
+// Call while holding spillLock
+func (self *ChannelWriter) signalSpill() {
+	select {
+	case self.spillSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (self *ChannelWriter) pump() {
+	defer close(self.spilled)
+
+	for {
+		self.spillLock.Lock()
+		if self.spill.Len() == 0 {
+			self.pumping = false
+			closing := self.closing
+			self.spillLock.Unlock()
+
+			if closing {
+				return
+			}
+
+			select {
+			case <-self.spillSignal:
+				continue
+			case <-self.context.Done():
+				return
+			}
+		}
+
+		p := make([]byte, min(self.spill.Len(), BUFFER_SIZE))
+		self.spill.Read(p)
+		self.pumping = true
+		self.spillLock.Unlock()
+
+		select {
+		case self.ch <- p:
+		case <-self.context.Done():
+			return
+		}
+	}
 }
 
 //
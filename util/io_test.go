@@ -0,0 +1,132 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChannelWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		overflow  ChannelOverflow
+		spillSize int
+		cancelled bool
+		writes    []string
+		writeErr  error
+		received  string
+		partial   bool // when true received is only a prefix
+		dropped   uint64
+	}{
+		{"fail", FailChannelOverflow, 0, false, []string{"a", "b"}, ErrChannelFull, "0", false, 0},
+		{"fail cancelled", FailChannelOverflow, 0, true, []string{"a", "b"}, ErrChannelFull, "0", false, 0},
+		{"default", "", 0, false, []string{"a", "b"}, ErrChannelFull, "0", false, 0},
+		{"block cancelled", BlockChannelOverflow, 0, true, []string{"a", "b"}, context.Canceled, "0", false, 0},
+		{"drop", DropChannelOverflow, 0, false, []string{"a", "b"}, nil, "0", false, 2},
+		{"drop cancelled", DropChannelOverflow, 0, true, []string{"a", "b"}, nil, "0", false, 2},
+		{"spill", SpillChannelOverflow, 0, false, []string{"a", "b"}, nil, "0ab", false, 0},
+		{"spill overwrite", SpillChannelOverflow, 2, false, []string{"a", "bc"}, nil, "0bc", false, 1},
+		{"spill cancelled", SpillChannelOverflow, 0, true, []string{"a", "b"}, nil, "0", true, 0},
+	}
+
+	for _, test := range tests {
+		context_, cancel := context.WithCancel(context.TODO())
+		if test.cancelled {
+			cancel()
+		}
+
+		// The channel starts out full
+		ch := make(chan []byte, 1)
+		ch <- []byte("0")
+
+		writer := NewChannelWriterFor(test.overflow, context_, ch, true, test.spillSize)
+		for _, write := range test.writes {
+			if _, err := writer.Write([]byte(write)); !errors.Is(err, test.writeErr) {
+				t.Errorf("%s: Write %q: %v", test.name, write, err)
+			}
+		}
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- writer.Close()
+			close(ch)
+		}()
+
+		var received strings.Builder
+		for p := range ch {
+			received.Write(p)
+		}
+
+		select {
+		case err := <-closed:
+			if (err != nil) && !test.cancelled {
+				t.Errorf("%s: Close: %s", test.name, err.Error())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Close blocked", test.name)
+		}
+
+		if test.partial {
+			// Some of the spill may have been sent before the pump noticed the context
+			if !strings.HasPrefix(received.String(), test.received) || !strings.HasPrefix(test.received+strings.Join(test.writes, ""), received.String()) {
+				t.Errorf("%s: received %q", test.name, received.String())
+			}
+		} else if received.String() != test.received {
+			t.Errorf("%s: received %q", test.name, received.String())
+		}
+
+		if dropped := writer.Dropped(); dropped != test.dropped {
+			t.Errorf("%s: dropped %d", test.name, dropped)
+		}
+
+		cancel()
+	}
+}
+
+func TestBlockingChannelWriter(t *testing.T) {
+	ch := make(chan []byte, 1)
+	ch <- []byte("0")
+
+	writer := NewBlockingChannelWriter(context.TODO(), ch, true)
+	written := make(chan error, 1)
+	go func() {
+		_, err := writer.Write([]byte("a"))
+		written <- err
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("Write did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if p := <-ch; string(p) != "0" {
+		t.Errorf("received %q", p)
+	}
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("Write: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write still blocked")
+	}
+
+	if p := <-ch; string(p) != "a" {
+		t.Errorf("received %q", p)
+	}
+}
+
+func TestSpillingChannelWriterClosed(t *testing.T) {
+	ch := make(chan []byte, 1)
+	writer := NewSpillingChannelWriter(context.TODO(), ch, true, 0)
+	if err := writer.Close(); err != nil {
+		t.Errorf("Close: %s", err.Error())
+	}
+	if _, err := writer.Write([]byte("a")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
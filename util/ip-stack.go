@@ -66,6 +66,13 @@ func (self IPStack) ServerBinds(address string) []IPStackBind {
 				{"tcp4", "0.0.0.0"},
 			}
 
+		case "localhost":
+			// Loopback for both protocols (otherwise only one of them would be bound)
+			return []IPStackBind{
+				{"tcp6", "::1"},
+				{"tcp4", "127.0.0.1"},
+			}
+
 		default:
 			return []IPStackBind{
 				{"tcp", address},
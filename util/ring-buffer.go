@@ -0,0 +1,96 @@
+package util
+
+import (
+	"io"
+)
+
+//
+// RingBuffer
+//
+
+type RingBuffer struct {
+	buffer  []byte
+	start   int
+	length  int
+	dropped uint64
+}
+
+// Creates a bounded byte buffer of a fixed size. When writing would exceed
+// the size then the oldest bytes are overwritten and counted as dropped.
+//
+// Note that the implementation is not thread-safe.
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{
+		buffer: make([]byte, size),
+	}
+}
+
+// The number of bytes available for reading.
+func (self *RingBuffer) Len() int {
+	return self.length
+}
+
+// The maximum number of bytes that can be stored.
+func (self *RingBuffer) Cap() int {
+	return len(self.buffer)
+}
+
+// The total number of bytes that were overwritten before they could be read.
+func (self *RingBuffer) Dropped() uint64 {
+	return self.dropped
+}
+
+func (self *RingBuffer) Reset() {
+	self.start = 0
+	self.length = 0
+}
+
+// Always succeeds and returns the length of the byte slice, even if some (or
+// all) of the bytes had to be dropped.
+//
+// ([io.Writer] interface)
+func (self *RingBuffer) Write(p []byte) (int, error) {
+	length := len(p)
+	size := len(self.buffer)
+
+	if length >= size {
+		// Only the tail of p can fit
+		self.dropped += uint64(self.length + length - size)
+		copy(self.buffer, p[length-size:])
+		self.start = 0
+		self.length = size
+		return length, nil
+	}
+
+	if overflow := self.length + length - size; overflow > 0 {
+		// Discard the oldest bytes
+		self.dropped += uint64(overflow)
+		self.start = (self.start + overflow) % size
+		self.length -= overflow
+	}
+
+	end := (self.start + self.length) % size
+	written := copy(self.buffer[end:], p)
+	copy(self.buffer, p[written:])
+	self.length += length
+
+	return length, nil
+}
+
+// Returns [io.EOF] if the buffer is empty.
+//
+// ([io.Reader] interface)
+func (self *RingBuffer) Read(p []byte) (int, error) {
+	if self.length == 0 {
+		return 0, io.EOF
+	}
+
+	count := min(len(p), self.length)
+	read := copy(p[:count], self.buffer[self.start:])
+	copy(p[read:count], self.buffer)
+
+	self.start = (self.start + count) % len(self.buffer)
+	self.length -= count
+
+	return count, nil
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"io"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	ringBuffer := NewRingBuffer(8)
+
+	ringBuffer.Write([]byte("hello"))
+	if ringBuffer.Len() != 5 {
+		t.Errorf("Len: %d", ringBuffer.Len())
+	}
+
+	p := make([]byte, 3)
+	if n, _ := ringBuffer.Read(p); string(p[:n]) != "hel" {
+		t.Errorf("Read: %q", p[:n])
+	}
+
+	// Wraps around
+	ringBuffer.Write([]byte("world"))
+	if ringBuffer.Dropped() != 0 {
+		t.Errorf("Dropped: %d", ringBuffer.Dropped())
+	}
+
+	// Overwrites the oldest
+	ringBuffer.Write([]byte("!!!"))
+	if ringBuffer.Dropped() != 2 {
+		t.Errorf("Dropped: %d", ringBuffer.Dropped())
+	}
+
+	if b, _ := io.ReadAll(ringBuffer); string(b) != "world!!!" {
+		t.Errorf("ReadAll: %q", b)
+	}
+
+	if _, err := ringBuffer.Read(p); err != io.EOF {
+		t.Error("Read empty")
+	}
+
+	// Larger than the buffer
+	ringBuffer.Write([]byte("0123456789"))
+	if b, _ := io.ReadAll(ringBuffer); string(b) != "23456789" {
+		t.Errorf("ReadAll: %q", b)
+	}
+	if ringBuffer.Dropped() != 4 {
+		t.Errorf("Dropped: %d", ringBuffer.Dropped())
+	}
+}
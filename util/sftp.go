@@ -0,0 +1,302 @@
+package util
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// Opens an SFTP session, connecting if necessary. If the existing connection
+// turns out to be broken it will be recreated once. The session should be
+// closed when done.
+func (self *SSHClient) SFTP() (*sftp.Client, error) {
+	for attempt := 0; ; attempt++ {
+		if client, err := self.Client(); err == nil {
+			if sftpClient, err := sftp.NewClient(client); err == nil {
+				return sftpClient, nil
+			} else {
+				self.drop(client)
+				if attempt > 0 {
+					return nil, err
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+}
+
+// Copies to the target path via SFTP, creating its directory if necessary.
+func (self *SSHClient) Copy(reader io.Reader, targetPath string, permissions *int64) error {
+	if sftpClient, err := self.SFTP(); err == nil {
+		defer sftpClient.Close()
+
+		if err := sftpClient.MkdirAll(path.Dir(targetPath)); err != nil {
+			return err
+		}
+
+		if file, err := sftpClient.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err == nil {
+			if _, err := file.ReadFrom(reader); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+
+		if permissions != nil {
+			return sftpClient.Chmod(targetPath, fs.FileMode(*permissions))
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Uploads a local file or directory (recursively) via SFTP. For a directory
+// the target path is the directory into which its contents will be copied.
+// Missing directories will be created. Preserves modes and modification times.
+//
+// Options can be nil.
+func (self *SSHClient) Upload(sourcePath string, targetPath string, options *FileTransferOptions) error {
+	sftpClient, err := self.SFTP()
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+
+	total, err := GetFileTransferSize(sourcePath)
+	if err != nil {
+		return err
+	}
+	tracker := NewFileTransferTracker(options, total)
+
+	if err := sftpClient.MkdirAll(path.Dir(targetPath)); err != nil {
+		return err
+	}
+
+	// We will set directory modes and times after their contents are written
+	var dirs []sftpDir
+
+	if err := filepath.WalkDir(sourcePath, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(sourcePath, entryPath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		targetPath_ := path.Join(targetPath, relativePath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if err := sftpClient.MkdirAll(targetPath_); err != nil {
+				return err
+			}
+			dirs = append(dirs, sftpDir{targetPath_, info})
+			return nil
+		} else if info.Mode().IsRegular() {
+			if err := uploadSFTPFile(sftpClient, entryPath, targetPath_, relativePath, tracker); err != nil {
+				return err
+			}
+			return setSFTPFileInfo(sftpClient, targetPath_, info)
+		} else {
+			return &fs.PathError{Op: "upload", Path: entryPath, Err: fs.ErrInvalid}
+		}
+	}); err != nil {
+		return err
+	}
+
+	for index := len(dirs) - 1; index >= 0; index-- {
+		if err := setSFTPFileInfo(sftpClient, dirs[index].path, dirs[index].info); err != nil {
+			return err
+		}
+	}
+
+	if (options != nil) && options.Verify {
+		if sourceChecksums, err := GetFileChecksums(sourcePath); err == nil {
+			if targetChecksums, err := getSFTPChecksums(sftpClient, targetPath); err == nil {
+				return sourceChecksums.Verify(targetChecksums)
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Downloads a remote file or directory (recursively) via SFTP. For a directory
+// the target path is the directory into which its contents will be copied.
+// Missing directories will be created. Preserves modes and modification times.
+//
+// Options can be nil.
+func (self *SSHClient) Download(sourcePath string, targetPath string, options *FileTransferOptions) error {
+	sftpClient, err := self.SFTP()
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+
+	sourcePath = path.Clean(sourcePath)
+
+	var total int64
+	walker := sftpClient.Walk(sourcePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if info := walker.Stat(); info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	tracker := NewFileTransferTracker(options, total)
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return err
+	}
+
+	var dirs []sftpDir
+
+	walker = sftpClient.Walk(sourcePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		relativePath, err := relativeSlashPath(walker.Path(), sourcePath)
+		if err != nil {
+			return err
+		}
+		targetPath_ := filepath.Join(targetPath, filepath.FromSlash(relativePath))
+
+		info := walker.Stat()
+		if info.IsDir() {
+			if err := os.MkdirAll(targetPath_, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, sftpDir{targetPath_, info})
+		} else if info.Mode().IsRegular() {
+			if err := downloadSFTPFile(sftpClient, walker.Path(), targetPath_, info.Mode().Perm(), relativePath, tracker); err != nil {
+				return err
+			}
+			if err := setLocalFileInfo(targetPath_, info); err != nil {
+				return err
+			}
+		} else {
+			return &fs.PathError{Op: "download", Path: walker.Path(), Err: fs.ErrInvalid}
+		}
+	}
+
+	for index := len(dirs) - 1; index >= 0; index-- {
+		if err := setLocalFileInfo(dirs[index].path, dirs[index].info); err != nil {
+			return err
+		}
+	}
+
+	if (options != nil) && options.Verify {
+		if sourceChecksums, err := getSFTPChecksums(sftpClient, sourcePath); err == nil {
+			if targetChecksums, err := GetFileChecksums(targetPath); err == nil {
+				return sourceChecksums.Verify(targetChecksums)
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type sftpDir struct {
+	path string
+	info fs.FileInfo
+}
+
+func uploadSFTPFile(sftpClient *sftp.Client, sourcePath string, targetPath string, relativePath string, tracker *FileTransferTracker) error {
+	if sourceFile, err := os.Open(sourcePath); err == nil {
+		defer sourceFile.Close()
+
+		if targetFile, err := sftpClient.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err == nil {
+			if _, err := io.Copy(tracker.Writer(relativePath, targetFile), sourceFile); err != nil {
+				targetFile.Close()
+				return err
+			}
+			return targetFile.Close()
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func downloadSFTPFile(sftpClient *sftp.Client, sourcePath string, targetPath string, mode fs.FileMode, relativePath string, tracker *FileTransferTracker) error {
+	if sourceFile, err := sftpClient.Open(sourcePath); err == nil {
+		defer sourceFile.Close()
+		return writeTrackedFile(sourceFile, targetPath, mode, relativePath, tracker)
+	} else {
+		return err
+	}
+}
+
+func setSFTPFileInfo(sftpClient *sftp.Client, path string, info fs.FileInfo) error {
+	if err := sftpClient.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return sftpClient.Chtimes(path, time.Now(), info.ModTime())
+}
+
+func setLocalFileInfo(path string, info fs.FileInfo) error {
+	if err := os.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, time.Time{}, info.ModTime())
+}
+
+func getSFTPChecksums(sftpClient *sftp.Client, root string) (FileChecksums, error) {
+	checksums := make(FileChecksums)
+
+	walker := sftpClient.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+
+		if walker.Stat().Mode().IsRegular() {
+			if relativePath, err := relativeSlashPath(walker.Path(), root); err == nil {
+				if file, err := sftpClient.Open(walker.Path()); err == nil {
+					err := checksums.Add(relativePath, file)
+					file.Close()
+					if err != nil {
+						return nil, err
+					}
+				} else {
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+		}
+	}
+
+	return checksums, nil
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	server := startTestSSHServer(t)
+	server.SFTPRoot = filepath.Join(root, "remote")
+	if err := os.Mkdir(server.SFTPRoot, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("server.Start: %s", err.Error())
+	}
+	defer server.Close()
+
+	config := testSSHClientConfig(t, server)
+	config.HostKeyMode = InsecureSSHHostKey
+	client := NewSSHClient(config)
+	defer client.Close()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	source := filepath.Join(root, "source")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(source, "sub", "hello.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var transferred int64
+	options := FileTransferOptions{
+		Progress: func(progress FileTransferProgress) {
+			transferred = progress.Transferred
+		},
+		Verify: true,
+	}
+
+	if err := client.Upload(source, "/target", &options); err != nil {
+		t.Fatalf("client.Upload: %s", err.Error())
+	}
+	if transferred != 5 {
+		t.Errorf("transferred: %d", transferred)
+	}
+
+	download := filepath.Join(root, "download")
+	if err := client.Download("/target", download, &options); err != nil {
+		t.Fatalf("client.Download: %s", err.Error())
+	}
+
+	if info, err := os.Stat(filepath.Join(download, "sub", "hello.txt")); err == nil {
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("mode: %s", info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("mtime: %s", info.ModTime())
+		}
+	} else {
+		t.Errorf("%s", err.Error())
+	}
+	if info, err := os.Stat(filepath.Join(download, "sub")); (err != nil) || (info.Mode().Perm() != 0750) {
+		t.Errorf("dir mode: %v", info.Mode())
+	}
+
+	if info, err := os.Stat(filepath.Join(server.SFTPRoot, "target", "sub", "hello.txt")); (err != nil) || (info.Size() != 5) {
+		t.Errorf("upload: %v, %v", info, err)
+	}
+
+	permissions := int64(0640)
+	if err := client.Copy(strings.NewReader("copied"), "/copied/file.txt", &permissions); err != nil {
+		t.Fatalf("client.Copy: %s", err.Error())
+	}
+	copied := filepath.Join(server.SFTPRoot, "copied", "file.txt")
+	if content, err := os.ReadFile(copied); (err != nil) || (string(content) != "copied") {
+		t.Errorf("client.Copy: %q", content)
+	}
+	if info, err := os.Stat(copied); (err != nil) || (info.Mode().Perm() != 0640) {
+		t.Errorf("client.Copy: %v", info)
+	}
+
+	// Cannot escape the root
+	if err := client.Copy(strings.NewReader("escaped"), "/../escaped.txt", nil); err != nil {
+		t.Fatalf("client.Copy: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+		t.Error("escaped the SFTP root")
+	}
+	if _, err := os.Stat(filepath.Join(server.SFTPRoot, "escaped.txt")); err != nil {
+		t.Errorf("not in the SFTP root: %s", err.Error())
+	}
+}
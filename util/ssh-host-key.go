@@ -0,0 +1,149 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//
+// SSHHostKeyMode
+//
+
+type SSHHostKeyMode string
+
+const (
+	// The host key must be in the known_hosts file.
+	StrictSSHHostKey SSHHostKeyMode = "strict"
+
+	// Unknown hosts are added to the known_hosts file, but a changed host key
+	// for a known host is rejected.
+	TrustOnFirstUseSSHHostKey SSHHostKeyMode = "trust-on-first-use"
+
+	// The host key must match [SSHClientConfig.HostKeyFingerprint].
+	PinnedSSHHostKey SSHHostKeyMode = "pinned"
+
+	// Any host key is accepted. Vulnerable to man-in-the-middle attacks.
+	InsecureSSHHostKey SSHHostKeyMode = "insecure"
+)
+
+// Returns the default location of the known_hosts file, "~/.ssh/known_hosts".
+func DefaultSSHKnownHostsPath() (string, error) {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".ssh", "known_hosts"), nil
+	} else {
+		return "", err
+	}
+}
+
+// Creates a host key callback for the mode.
+//
+// For [StrictSSHHostKey] and [TrustOnFirstUseSSHHostKey] knownHostsPath can be
+// empty, in which case [DefaultSSHKnownHostsPath] will be used.
+//
+// For [PinnedSSHHostKey] the fingerprint can be either in the SHA256 format
+// ("SHA256:" followed by unpadded base64) or in the legacy MD5 format
+// (colon-separated hex, optionally prefixed by "MD5:"), as printed by
+// "ssh-keygen -l".
+func NewSSHHostKeyCallback(mode SSHHostKeyMode, knownHostsPath string, fingerprint string) (ssh.HostKeyCallback, error) {
+	switch mode {
+	case StrictSSHHostKey, TrustOnFirstUseSSHHostKey:
+		if knownHostsPath == "" {
+			var err error
+			if knownHostsPath, err = DefaultSSHKnownHostsPath(); err != nil {
+				return nil, err
+			}
+		}
+
+		if mode == StrictSSHHostKey {
+			return knownhosts.New(knownHostsPath)
+		} else {
+			knownHosts := sshKnownHosts{path: knownHostsPath}
+			return knownHosts.trustOnFirstUse, nil
+		}
+
+	case PinnedSSHHostKey:
+		if fingerprint == "" {
+			return nil, errors.New("no SSH host key fingerprint")
+		}
+
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			var fingerprint_ string
+			var match bool
+			if strings.HasPrefix(fingerprint, "SHA256:") {
+				fingerprint_ = ssh.FingerprintSHA256(key)
+				match = fingerprint_ == fingerprint
+			} else {
+				fingerprint_ = ssh.FingerprintLegacyMD5(key)
+				match = fingerprint_ == strings.TrimPrefix(fingerprint, "MD5:")
+			}
+
+			if match {
+				return nil
+			} else {
+				return fmt.Errorf("SSH host key fingerprint for %s is %s instead of %s", hostname, fingerprint_, fingerprint)
+			}
+		}, nil
+
+	case InsecureSSHHostKey:
+		return ssh.InsecureIgnoreHostKey(), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported SSH host key mode: %q", mode)
+	}
+}
+
+//
+// sshKnownHosts
+//
+
+var sshKnownHostsLock sync.Mutex
+
+type sshKnownHosts struct {
+	path string
+}
+
+// ([ssh.HostKeyCallback] signature)
+func (self *sshKnownHosts) trustOnFirstUse(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	sshKnownHostsLock.Lock()
+	defer sshKnownHostsLock.Unlock()
+
+	if exists, err := DoesFileExist(self.path); err == nil {
+		if exists {
+			if callback, err := knownhosts.New(self.path); err == nil {
+				if err := callback(hostname, remote, key); err == nil {
+					return nil
+				} else {
+					var keyError *knownhosts.KeyError
+					if !errors.As(err, &keyError) || (len(keyError.Want) > 0) {
+						// Mismatch or revoked
+						return err
+					}
+				}
+			} else {
+				return err
+			}
+		}
+	} else {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(self.path), 0700); err != nil {
+		return err
+	}
+
+	if file, err := os.OpenFile(self.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
+		defer file.Close()
+		_, err := file.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+		return err
+	} else {
+		return err
+	}
+}
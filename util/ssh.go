@@ -1,72 +1,402 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"path/filepath"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+const DEFAULT_SSH_PORT = 22
+
+// Runs the command via a new connection, which is closed afterwards. Note that
+// the host key is not verified. Use [SSHClient] for more control.
 func ExecSSH(host string, port int, username string, key string, stdin io.Reader, command ...string) (string, error) {
-	if signer, err := ssh.ParsePrivateKey(StringToBytes(key)); err == nil {
-		config := ssh.ClientConfig{
-			User: username,
-			Auth: []ssh.AuthMethod{
-				ssh.PublicKeys(signer),
-			},
-			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-				return nil
-			},
+	client := NewSSHClient(SSHClientConfig{
+		Host:        host,
+		Port:        port,
+		Username:    username,
+		Key:         key,
+		HostKeyMode: InsecureSSHHostKey,
+	})
+	defer client.Close()
+
+	return client.Exec(stdin, command...)
+}
+
+// Copies to the target path via a new connection, which is closed afterwards.
+// Note that the host key is not verified. Use [SSHClient] for more control.
+func CopySSH(host string, port int, username string, key string, reader io.Reader, targetPath string, permissions *int64) error {
+	client := NewSSHClient(SSHClientConfig{
+		Host:        host,
+		Port:        port,
+		Username:    username,
+		Key:         key,
+		HostKeyMode: InsecureSSHHostKey,
+	})
+	defer client.Close()
+
+	return client.Copy(reader, targetPath, permissions)
+}
+
+//
+// SSHClientConfig
+//
+
+type SSHClientConfig struct {
+	Host     string
+	Port     int // when zero will use DEFAULT_SSH_PORT
+	Username string
+
+	// Authentication methods are attempted in this order (each only if set)
+	Key           string // PEM private key
+	KeyPassphrase string // for an encrypted Key
+	Agent         bool   // use the agent at the SSH_AUTH_SOCK environment variable
+	Password      string
+
+	HostKeyMode        SSHHostKeyMode // when empty will use StrictSSHHostKey
+	KnownHostsPath     string         // when empty will use DefaultSSHKnownHostsPath
+	HostKeyFingerprint string         // for PinnedSSHHostKey
+
+	Timeout   time.Duration // for establishing the connection; zero means no timeout
+	KeepAlive time.Duration // interval for keepalive requests; zero means none
+}
+
+// Returns "{username}@{host}:{port}".
+func (self *SSHClientConfig) String() string {
+	return self.Username + "@" + self.Address()
+}
+
+// Returns "{host}:{port}".
+func (self *SSHClientConfig) Address() string {
+	port := self.Port
+	if port == 0 {
+		port = DEFAULT_SSH_PORT
+	}
+	return net.JoinHostPort(self.Host, strconv.Itoa(port))
+}
+
+//
+// SSHClient
+//
+
+// Shares a single connection among all its sessions. The connection is created
+// on demand and is recreated if it was lost. With [SSHClientConfig.KeepAlive]
+// a lost connection will be detected (and closed) even when idle.
+//
+// Safe for concurrent use.
+type SSHClient struct {
+	Config SSHClientConfig
+
+	client    *ssh.Client
+	agentConn net.Conn
+	stop      chan struct{}
+	lock      sync.Mutex
+}
+
+func NewSSHClient(config SSHClientConfig) *SSHClient {
+	return &SSHClient{Config: config}
+}
+
+// Returns the connection, connecting if necessary. Do not close it directly;
+// instead call [SSHClient.Close].
+func (self *SSHClient) Client() (*ssh.Client, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.client != nil {
+		return self.client, nil
+	}
+
+	config := ssh.ClientConfig{
+		User:    self.Config.Username,
+		Timeout: self.Config.Timeout,
+	}
+
+	var err error
+	if config.HostKeyCallback, err = NewSSHHostKeyCallback(self.hostKeyMode(), self.Config.KnownHostsPath, self.Config.HostKeyFingerprint); err != nil {
+		return nil, err
+	}
+
+	if self.Config.Key != "" {
+		var signer ssh.Signer
+		if self.Config.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(StringToBytes(self.Config.Key), StringToBytes(self.Config.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(StringToBytes(self.Config.Key))
+		}
+		if err != nil {
+			return nil, err
 		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
 
-		if client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), &config); err == nil {
-			defer client.Close()
+	var agentConn net.Conn
+	if self.Config.Agent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("SSH_AUTH_SOCK is not set")
+		}
+		if agentConn, err = net.Dial("unix", socket); err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+	}
 
-			if session, err := client.NewSession(); err == nil {
-				defer session.Close()
+	if self.Config.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(self.Config.Password))
+	}
 
-				var stdout strings.Builder
-				var stderr strings.Builder
+	if self.client, err = ssh.Dial("tcp", self.Config.Address(), &config); err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, err
+	}
 
-				session.Stdin = stdin
-				session.Stdout = &stdout
-				session.Stderr = &stderr
+	self.agentConn = agentConn
 
-				// TODO: handle spaces
-				if err := session.Run(strings.Join(command, " ")); err == nil {
-					return stdout.String(), nil
-				} else {
-					// TODO: special error object to contain stderr separately
-					return "", fmt.Errorf("%s\n%s", err.Error(), stderr.String())
-				}
-			} else {
-				return "", err
-			}
+	if self.Config.KeepAlive > 0 {
+		self.stop = make(chan struct{})
+		go self.keepAlive(self.client, self.stop)
+	}
+
+	return self.client, nil
+}
+
+// Runs the command and returns its stdout. Arguments are quoted for the remote
+// shell. If the command fails the error will be an [*SSHCommandError].
+func (self *SSHClient) Exec(stdin io.Reader, command ...string) (string, error) {
+	if session, err := self.NewSession(); err == nil {
+		defer session.Close()
+
+		var stdout strings.Builder
+		var stderr strings.Builder
+
+		session.Stdin = stdin
+		session.Stdout = &stdout
+		session.Stderr = &stderr
+
+		commandLine := JoinShellQuote(command...)
+		if err := session.Run(commandLine); err == nil {
+			return stdout.String(), nil
 		} else {
-			return "", err
+			return "", NewSSHCommandError(commandLine, stderr.String(), err)
 		}
 	} else {
 		return "", err
 	}
 }
 
-func CopySSH(host string, port int, username string, key string, reader io.Reader, targetPath string, permissions *int64) error {
-	dir := filepath.Dir(targetPath)
-	if _, err := ExecSSH(host, port, username, key, nil, "mkdir", "--parents", dir); err == nil {
-		if _, err := ExecSSH(host, port, username, key, reader, "cp", "/dev/stdin", targetPath); err == nil {
-			if permissions != nil {
-				_, err := ExecSSH(host, port, username, key, nil, "chmod", strconv.FormatInt(*permissions, 8), targetPath)
-				return err
+// Opens a session, connecting if necessary. If the existing connection turns
+// out to be broken it will be recreated once.
+func (self *SSHClient) NewSession() (*ssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		if client, err := self.Client(); err == nil {
+			if session, err := client.NewSession(); err == nil {
+				return session, nil
+			} else {
+				self.drop(client)
+				if attempt > 0 {
+					return nil, err
+				}
 			}
-			return nil
 		} else {
-			return err
+			return nil, err
 		}
+	}
+}
+
+// Closes the connection, if there is one. The client can still be used
+// afterwards, in which case a new connection will be created.
+func (self *SSHClient) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.close()
+}
+
+func (self *SSHClient) hostKeyMode() SSHHostKeyMode {
+	if self.Config.HostKeyMode == "" {
+		return StrictSSHHostKey
+	}
+	return self.Config.HostKeyMode
+}
+
+// Closes the connection if it is still the current one.
+func (self *SSHClient) drop(client *ssh.Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.client == client {
+		self.close()
+	}
+}
+
+func (self *SSHClient) close() error {
+	if self.client == nil {
+		return nil
+	}
+
+	if self.stop != nil {
+		close(self.stop)
+		self.stop = nil
+	}
+
+	err := self.client.Close()
+	self.client = nil
+
+	if self.agentConn != nil {
+		self.agentConn.Close()
+		self.agentConn = nil
+	}
+
+	return err
+}
+
+func (self *SSHClient) keepAlive(client *ssh.Client, stop chan struct{}) {
+	ticker := time.NewTicker(self.Config.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !sendSSHKeepAlive(client, self.Config.KeepAlive) {
+				// Closing the client will also end a request that is still waiting
+				self.drop(client)
+				return
+			}
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+//
+// SSHClientPool
+//
+
+// Shares an [SSHClient] per username, host, and port.
+//
+// Safe for concurrent use.
+type SSHClientPool struct {
+	clients map[string]*SSHClient
+	lock    sync.Mutex
+}
+
+func NewSSHClientPool() *SSHClientPool {
+	return &SSHClientPool{
+		clients: make(map[string]*SSHClient),
+	}
+}
+
+// Returns the existing client for the config's username, host, and port, or
+// creates a new one. Note that if the client already exists the rest of the
+// config is ignored.
+func (self *SSHClientPool) Get(config SSHClientConfig) *SSHClient {
+	key := config.String()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if client, ok := self.clients[key]; ok {
+		return client
 	} else {
-		return err
+		client = NewSSHClient(config)
+		self.clients[key] = client
+		return client
+	}
+}
+
+// Closes and removes all clients.
+func (self *SSHClientPool) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var errs []error
+	for _, client := range self.clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	self.clients = make(map[string]*SSHClient)
+
+	return errors.Join(errs...)
+}
+
+//
+// SSHCommandError
+//
+
+type SSHCommandError struct {
+	Command  string
+	ExitCode int    // -1 if the server did not report it
+	Signal   string // if the command was terminated by a signal (e.g. "TERM")
+	Stderr   string
+	Err      error
+}
+
+func NewSSHCommandError(command string, stderr string, err error) *SSHCommandError {
+	self := SSHCommandError{
+		Command:  command,
+		ExitCode: -1,
+		Stderr:   stderr,
+		Err:      err,
+	}
+
+	var exitError *ssh.ExitError
+	if errors.As(err, &exitError) {
+		self.ExitCode = exitError.ExitStatus()
+		self.Signal = exitError.Signal()
+	}
+
+	return &self
+}
+
+// ([error] interface)
+func (self *SSHCommandError) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "SSH command %q: %s", self.Command, self.Err.Error())
+	if stderr := strings.TrimSpace(self.Stderr); stderr != "" {
+		builder.WriteString("\n")
+		builder.WriteString(stderr)
+	}
+	return builder.String()
+}
+
+// (for [errors.Unwrap])
+func (self *SSHCommandError) Unwrap() error {
+	return self.Err
+}
+
+// Utils
+
+// Returns false if the request failed or did not return within the timeout,
+// in which case the client should be closed.
+func sendSSHKeepAlive(client *ssh.Client, timeout time.Duration) bool {
+	result := make(chan error, 1)
+	go func() {
+		// Servers are expected to reply even if they don't support the request
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		return err == nil
+	case <-timer.C:
+		return false
 	}
 }
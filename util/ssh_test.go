@@ -0,0 +1,219 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/tliron/go-kutil/internal/sshtest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHClient(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.Password = "secret"
+	if err := server.Start(); err != nil {
+		t.Fatalf("server.Start: %s", err.Error())
+	}
+	defer server.Close()
+
+	client := NewSSHClient(testSSHClientConfig(t, server))
+	client.Config.Password = "secret"
+	client.Config.HostKeyMode = PinnedSSHHostKey
+	client.Config.HostKeyFingerprint = ssh.FingerprintSHA256(server.HostKey.PublicKey())
+	defer client.Close()
+
+	if stdout, err := client.Exec(nil, "echo", "hello world", "it's"); err == nil {
+		if expected := `echo 'hello world' 'it'\''s'`; stdout != expected {
+			t.Errorf("stdout: %q", stdout)
+		}
+	} else {
+		t.Errorf("client.Exec: %s", err.Error())
+	}
+
+	connection, _ := client.Client()
+
+	_, err := client.Exec(nil, "fail")
+	var commandError *SSHCommandError
+	if errors.As(err, &commandError) {
+		if (commandError.ExitCode != 3) || (commandError.Stderr != "failed\n") {
+			t.Errorf("SSHCommandError: %+v", commandError)
+		}
+	} else {
+		t.Errorf("not an SSHCommandError: %v", err)
+	}
+
+	if connection_, _ := client.Client(); connection_ != connection {
+		t.Error("connection was not reused")
+	}
+
+	// Wrong fingerprint
+	client2 := NewSSHClient(client.Config)
+	client2.Config.HostKeyFingerprint = "SHA256:AAAA"
+	if _, err := client2.Exec(nil, "echo"); err == nil {
+		client2.Close()
+		t.Error("pinned fingerprint was not verified")
+	}
+
+	// Legacy fingerprints may have a prefix
+	for _, fingerprint := range []string{ssh.FingerprintLegacyMD5(server.HostKey.PublicKey()), "MD5:" + ssh.FingerprintLegacyMD5(server.HostKey.PublicKey())} {
+		client3 := NewSSHClient(client.Config)
+		client3.Config.HostKeyFingerprint = fingerprint
+		if _, err := client3.Exec(nil, "echo"); err != nil {
+			t.Errorf("%s: %s", fingerprint, err.Error())
+		}
+		client3.Close()
+	}
+}
+
+func TestSSHClientTrustOnFirstUse(t *testing.T) {
+	server := startTestSSHServer(t)
+	if err := server.Start(); err != nil {
+		t.Fatalf("server.Start: %s", err.Error())
+	}
+	defer server.Close()
+
+	config := testSSHClientConfig(t, server)
+	config.KnownHostsPath = filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	// Strict fails for an unknown host
+	config.HostKeyMode = StrictSSHHostKey
+	if _, err := execTestSSH(config); err == nil {
+		t.Error("strict accepted an unknown host")
+	}
+
+	// Trust on first use adds it
+	config.HostKeyMode = TrustOnFirstUseSSHHostKey
+	if _, err := execTestSSH(config); err != nil {
+		t.Errorf("trust on first use: %s", err.Error())
+	}
+
+	// Now strict succeeds
+	config.HostKeyMode = StrictSSHHostKey
+	if _, err := execTestSSH(config); err != nil {
+		t.Errorf("strict: %s", err.Error())
+	}
+
+	// But not if the host key changes (simulated by a second server for which we
+	// have the first server's key)
+	server2 := startTestSSHServer(t)
+	if err := server2.Start(); err != nil {
+		t.Fatalf("server.Start: %s", err.Error())
+	}
+	defer server2.Close()
+
+	config = withTestSSHServerAddress(t, config, server2)
+	if err := appendKnownHost(config.KnownHostsPath, server2.Address, server.HostKey.PublicKey()); err != nil {
+		t.Fatalf("appendKnownHost: %s", err.Error())
+	}
+
+	config.HostKeyMode = TrustOnFirstUseSSHHostKey
+	if _, err := execTestSSH(config); err == nil {
+		t.Error("trust on first use accepted a changed host key")
+	}
+}
+
+func TestSSHClientAgent(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %s", err.Error())
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("keyring.Add: %s", err.Error())
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("net.Listen: %s", err.Error())
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			if conn, err := listener.Accept(); err == nil {
+				go agent.ServeAgent(keyring, conn)
+			} else {
+				return
+			}
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	// Only the key will be accepted
+	server := startTestSSHServer(t)
+	server.Password = ""
+	if publicKey, err := ssh.NewPublicKey(key.Public()); err == nil {
+		server.AuthorizedKeys = []ssh.PublicKey{publicKey}
+	} else {
+		t.Fatalf("ssh.NewPublicKey: %s", err.Error())
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("server.Start: %s", err.Error())
+	}
+	defer server.Close()
+
+	config := testSSHClientConfig(t, server)
+	config.HostKeyMode = InsecureSSHHostKey
+	config.Agent = true
+	if _, err := execTestSSH(config); err != nil {
+		t.Errorf("agent: %s", err.Error())
+	}
+}
+
+// Utils
+
+func execTestSSH(config SSHClientConfig) (string, error) {
+	client := NewSSHClient(config)
+	defer client.Close()
+	return client.Exec(nil, "echo")
+}
+
+func startTestSSHServer(t *testing.T) *sshtest.Server {
+	server, err := sshtest.NewServer(func(session *sshtest.Session) uint32 {
+		if session.Command == "fail" {
+			fmt.Fprintln(session.Stderr, "failed")
+			return 3
+		}
+		fmt.Fprint(session.Stdout, session.Command)
+		return 0
+	})
+	if err != nil {
+		t.Fatalf("sshtest.NewServer: %s", err.Error())
+	}
+	server.Password = "test"
+	return server
+}
+
+func testSSHClientConfig(t *testing.T, server *sshtest.Server) SSHClientConfig {
+	return withTestSSHServerAddress(t, SSHClientConfig{Username: "test", Password: server.Password}, server)
+}
+
+func withTestSSHServerAddress(t *testing.T, config SSHClientConfig, server *sshtest.Server) SSHClientConfig {
+	host, port, err := net.SplitHostPort(server.Address)
+	if err != nil {
+		t.Fatalf("net.SplitHostPort: %s", err.Error())
+	}
+	config.Host = host
+	config.Port, _ = strconv.Atoi(port)
+	return config
+}
+
+func appendKnownHost(path string, address string, key ssh.PublicKey) error {
+	if file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+		defer file.Close()
+		_, err := file.WriteString(knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n")
+		return err
+	} else {
+		return err
+	}
+}
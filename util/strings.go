@@ -142,3 +142,48 @@ func JoinQuoteL(strings []string, separator string, lastSeparator string, couple
 
 	return builder.String()
 }
+
+// Joins the arguments into a POSIX shell command line. Arguments that contain
+// anything other than safe characters are enclosed in single quotes, so that
+// the shell will pass them on verbatim.
+func JoinShellQuote(args ...string) string {
+	var builder stringspkg.Builder
+
+	for index, arg := range args {
+		if index > 0 {
+			builder.WriteRune(' ')
+		}
+		builder.WriteString(ShellQuote(arg))
+	}
+
+	return builder.String()
+}
+
+// Quotes the string for a POSIX shell, if necessary.
+func ShellQuote(string_ string) string {
+	if string_ == "" {
+		return "''"
+	}
+
+	if stringspkg.IndexFunc(string_, isShellUnsafe) == -1 {
+		return string_
+	}
+
+	// Single quotes cannot be escaped within single quotes, so we close the quote,
+	// add an escaped quote, and then reopen the quote
+	return "'" + stringspkg.ReplaceAll(string_, "'", `'\''`) + "'"
+}
+
+func isShellUnsafe(rune_ rune) bool {
+	switch {
+	case (rune_ >= 'a') && (rune_ <= 'z'), (rune_ >= 'A') && (rune_ <= 'Z'), (rune_ >= '0') && (rune_ <= '9'):
+		return false
+	}
+
+	switch rune_ {
+	case '-', '_', '.', ',', '/', ':', '=', '+', '@', '%':
+		return false
+	}
+
+	return true
+}
@@ -45,3 +45,9 @@ func TestJoinQuote(t *testing.T) {
 		t.Error("JoinQuoteL many")
 	}
 }
+
+func TestJoinShellQuote(t *testing.T) {
+	if s := JoinShellQuote("ls", "-l", "my file", "it's", "", "a=b"); s != `ls -l 'my file' 'it'\''s' '' a=b` {
+		t.Errorf("JoinShellQuote: %s", s)
+	}
+}
@@ -2,7 +2,14 @@ package util
 
 import (
 	"archive/tar"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -59,3 +66,316 @@ func (self *TarEncoder) copy() {
 		self.pipeWriter.CloseWithError(err)
 	}
 }
+
+// Writes the file or directory at the path (recursively) as tar entries. The
+// entry for the path itself is named root, and the entries within a directory
+// are named relative to it. Preserves modes and modification times, but not
+// ownership. Symbolic links are archived as is (not followed).
+//
+// The tracker can be nil.
+func WriteTarEntries(tarWriter *tar.Writer, path_ string, root string, tracker *FileTransferTracker) error {
+	return filepath.WalkDir(path_, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(path_, entryPath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		// Ownership is not preserved (extracting as root would result in the
+		// owner being root)
+		header.Name = path.Join(root, relativePath)
+		header.Uid = 0
+		header.Gid = 0
+		header.Uname = ""
+		header.Gname = ""
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			header.Name += "/"
+			return tarWriter.WriteHeader(header)
+
+		case tar.TypeSymlink:
+			if header.Linkname, err = os.Readlink(entryPath); err != nil {
+				return err
+			}
+			return tarWriter.WriteHeader(header)
+
+		case tar.TypeReg:
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if file, err := os.Open(entryPath); err == nil {
+				defer file.Close()
+				var writer io.Writer = tarWriter
+				if tracker != nil {
+					writer = tracker.Writer(relativePath, writer)
+				}
+				_, err := io.Copy(writer, file)
+				return err
+			} else {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported file type: %s", entryPath)
+		}
+	})
+}
+
+// Extracts tar entries, as written by [WriteTarEntries], to the target path.
+// The entry named root is extracted to the target path itself, and entries
+// under it are extracted relative to the target path. Other entries are
+// rejected. Restores modes (without setuid, setgid, and sticky bits) and
+// modification times.
+//
+// Extraction is guarded against path traversal: entries may not be extracted
+// outside of the target path, neither directly (e.g. "../file") nor through
+// symbolic links, whether existing or extracted earlier. Thus symbolic links
+// must be relative and may not point outside of the target path, and hard
+// links must point to entries that were already extracted.
+//
+// The tracker can be nil.
+func ExtractTarEntries(tarReader *tar.Reader, root string, targetPath string, tracker *FileTransferTracker) error {
+	type dir struct {
+		path   string
+		header *tar.Header
+	}
+
+	// We will restore directory modes and times after their contents are written
+	var dirs []dir
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		relativePath, err := relativeSlashPath(header.Name, root)
+		if err != nil {
+			return err
+		}
+
+		if err := checkNoSymlinks(targetPath, relativePath); err != nil {
+			return err
+		}
+
+		entryPath := filepath.Join(targetPath, filepath.FromSlash(relativePath))
+		mode := fs.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(entryPath); (err == nil) && (info.Mode()&fs.ModeSymlink != 0) && (relativePath != ".") {
+				return fmt.Errorf("a symbolic link already exists: %s", entryPath)
+			}
+
+			if err := os.MkdirAll(entryPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dir{entryPath, header})
+
+		case tar.TypeReg:
+			if err := prepareTarEntry(entryPath); err != nil {
+				return err
+			}
+
+			if err := writeTrackedFile(tarReader, entryPath, mode, relativePath, tracker); err != nil {
+				return err
+			}
+
+			if err := os.Chmod(entryPath, mode); err != nil {
+				return err
+			}
+
+			if err := os.Chtimes(entryPath, header.AccessTime, header.ModTime); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if (relativePath == ".") || path.IsAbs(header.Linkname) || !filepath.IsLocal(filepath.FromSlash(path.Join(path.Dir(relativePath), header.Linkname))) {
+				return fmt.Errorf("symbolic link points outside of the target: %q -> %q", header.Name, header.Linkname)
+			}
+
+			if err := prepareTarEntry(entryPath); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, entryPath); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			linkRelativePath, err := relativeSlashPath(header.Linkname, root)
+			if err != nil {
+				return fmt.Errorf("hard link points outside of the target: %q -> %q", header.Name, header.Linkname)
+			}
+
+			if err := checkNoSymlinks(targetPath, linkRelativePath); err != nil {
+				return err
+			}
+
+			if err := prepareTarEntry(entryPath); err != nil {
+				return err
+			}
+
+			linkPath := filepath.Join(targetPath, filepath.FromSlash(linkRelativePath))
+			if info, err := os.Lstat(linkPath); err == nil {
+				if !info.Mode().IsRegular() {
+					return fmt.Errorf("hard link does not point to a regular file: %q -> %q", header.Name, header.Linkname)
+				}
+			} else {
+				return err
+			}
+
+			if err := os.Link(linkPath, entryPath); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported tar entry type for %q: %c", header.Name, header.Typeflag)
+		}
+	}
+
+	for index := len(dirs) - 1; index >= 0; index-- {
+		dir := dirs[index]
+		if err := os.Chmod(dir.path, fs.FileMode(dir.header.Mode).Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dir.path, dir.header.AccessTime, dir.header.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checksums the regular files in the tar entries, named as in [ExtractTarEntries].
+func GetTarChecksums(tarReader *tar.Reader, root string) (FileChecksums, error) {
+	checksums := make(FileChecksums)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return checksums, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			if relativePath, err := relativeSlashPath(header.Name, root); err == nil {
+				if err := checksums.Add(relativePath, tarReader); err != nil {
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+		}
+	}
+}
+
+func writeTrackedFile(reader io.Reader, path string, mode fs.FileMode, relativePath string, tracker *FileTransferTracker) error {
+	if file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode); err == nil {
+		var writer io.Writer = file
+		if tracker != nil {
+			writer = tracker.Writer(relativePath, writer)
+		}
+
+		if _, err := io.Copy(writer, reader); err == nil {
+			return file.Close()
+		} else {
+			file.Close()
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+// Makes sure that none of the existing directories between the target path
+// (exclusive) and the relative path (inclusive) are symbolic links, which could
+// otherwise lead outside of the target path. The relative path itself may be a
+// symbolic link if it is not a directory.
+func checkNoSymlinks(targetPath string, relativePath string) error {
+	if relativePath == "." {
+		return nil
+	}
+
+	segments := strings.Split(relativePath, "/")
+	current := targetPath
+	for index, segment := range segments {
+		current = filepath.Join(current, segment)
+		if info, err := os.Lstat(current); err == nil {
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if index < len(segments)-1 {
+					return fmt.Errorf("path goes through a symbolic link: %q", relativePath)
+				}
+			}
+		} else if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates the parent directories and removes an existing non-directory at
+// the path (so that we will not write through a symbolic link).
+func prepareTarEntry(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("a directory already exists: %s", path)
+		}
+		return os.Remove(path)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns "." for the root itself.
+func relativeSlashPath(path_ string, root string) (string, error) {
+	path_ = path.Clean(path_)
+	root = path.Clean(root)
+
+	if path_ == root {
+		return ".", nil
+	}
+
+	var relativePath string
+	if root == "." {
+		relativePath = path_
+	} else if strings.HasPrefix(path_, strings.TrimSuffix(root, "/")+"/") {
+		relativePath = path_[len(strings.TrimSuffix(root, "/"))+1:]
+	} else {
+		return "", fmt.Errorf("not under %q: %q", root, path_)
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(relativePath)) {
+		return "", fmt.Errorf("outside of %q: %q", root, path_)
+	}
+
+	return relativePath, nil
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractTarEntries(t *testing.T) {
+	root := t.TempDir()
+
+	source := filepath.Join(root, "source")
+	if err := os.MkdirAll(filepath.Join(source, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "dir", "file"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	tarWriter := tar.NewWriter(&buffer)
+	if err := WriteTarEntries(tarWriter, source, "app", nil); err != nil {
+		t.Fatalf("WriteTarEntries: %s", err.Error())
+	}
+	tarWriter.Close()
+
+	target := filepath.Join(root, "target")
+	if err := ExtractTarEntries(tar.NewReader(&buffer), "app", target, nil); err != nil {
+		t.Fatalf("ExtractTarEntries: %s", err.Error())
+	}
+
+	if content, err := os.ReadFile(filepath.Join(target, "link")); (err != nil) || (string(content) != "hello") {
+		t.Errorf("symbolic link: %q, %v", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(target, "dir", "file")); (err != nil) || (info.Mode().Perm() != 0640) {
+		t.Errorf("mode: %v, %v", info, err)
+	}
+}
+
+func TestExtractTarEntriesTraversal(t *testing.T) {
+	outside := t.TempDir()
+
+	for name, headers := range map[string][]tar.Header{
+		"dot-dot":           {{Name: "app/../../escape", Typeflag: tar.TypeReg}},
+		"other root":        {{Name: "other/file", Typeflag: tar.TypeReg}},
+		"absolute symlink":  {{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: outside}},
+		"dot-dot symlink":   {{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "../../escape"}},
+		"hard link outside": {{Name: "app/link", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}},
+		"through symlink": {
+			{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "dir"},
+			{Name: "app/dir", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "app/link/file", Typeflag: tar.TypeReg},
+		},
+	} {
+		var buffer bytes.Buffer
+		tarWriter := tar.NewWriter(&buffer)
+		for _, header := range headers {
+			if err := tarWriter.WriteHeader(&header); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tarWriter.Close()
+
+		if err := ExtractTarEntries(tar.NewReader(&buffer), "app", filepath.Join(t.TempDir(), "target"), nil); err == nil {
+			t.Errorf("%s: not rejected", name)
+		}
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+		t.Errorf("wrote outside of the target: %v", entries)
+	}
+}
@@ -105,7 +105,7 @@ func (self *TarballEntryReader) Close() error {
 
 func FixTarballEntryPath(path string) string {
 	if strings.HasPrefix(path, "./") {
-		return path[3:]
+		return path[2:]
 	}
 	return path
 }
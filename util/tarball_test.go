@@ -0,0 +1,25 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestFixTarballEntryPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		fixed string
+	}{
+		{"./x", "x"},
+		{"x", "x"},
+		{"./dir/x", "dir/x"},
+		{"dir/./x", "dir/./x"},
+		{".x", ".x"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if fixed := FixTarballEntryPath(test.path); fixed != test.fixed {
+			t.Errorf("%q: %q, expected %q", test.path, fixed, test.fixed)
+		}
+	}
+}